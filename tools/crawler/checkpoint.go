@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointInterval is the minimum time between two periodic checkpoint writes
+const checkpointInterval = 5 * time.Second
+
+// Checkpoint is the on-disk state of an unfinished crawl. It is written while
+// the crawl runs and removed once the crawl completes without interruption.
+type Checkpoint struct {
+	BaseURL  string         `json:"baseUrl"`
+	Phase    int            `json:"phase"`
+	Frontier []string       `json:"frontier"`
+	Links    []string       `json:"links,omitempty"`
+	Depths   map[string]int `json:"depths,omitempty"`
+	Visited  []string       `json:"visited"`
+	// Failed are the pages whose fetch failed, retried on resume
+	Failed []string `json:"failed,omitempty"`
+	// State is the incremental state collected so far
+	State map[string]*PageState `json:"state,omitempty"`
+	// ItemKeys are the dedup keys of the items saved so far
+	ItemKeys []string `json:"itemKeys,omitempty"`
+	// OutputOffset is the size of a file output after the items saved so
+	// far; a resumed crawl drops what was written after it
+	OutputOffset int64     `json:"outputOffset,omitempty"`
+	Summary      Summary   `json:"summary"`
+	SavedAt      time.Time `json:"savedAt"`
+}
+
+// LoadCheckpoint reads a checkpoint file written by a previous run
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %v", err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint: %v", err)
+	}
+	return &cp, nil
+}
+
+// Save writes the checkpoint atomically so a crash mid-write never leaves a
+// truncated file behind
+func (cp *Checkpoint) Save(path string) error {
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating directory: %v", err)
+		}
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("error marshalling checkpoint: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Resume restores the frontier, visited set and counters from the configured
+// checkpoint file. It must be called before Run, which then continues the
+// existing output instead of replacing it.
+func (c *Crawler) Resume() error {
+	cp, err := LoadCheckpoint(c.config.CheckpointPath())
+	if err != nil {
+		return err
+	}
+	if cp.BaseURL != c.config.BaseURL {
+		return fmt.Errorf("checkpoint was written for %s, not %s", cp.BaseURL, c.config.BaseURL)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resumed = true
+	c.outputOffset = cp.OutputOffset
+	c.phase = cp.Phase
+	c.frontier = cp.Frontier
+	c.links = cp.Links
+	// Failed pages are attempted again, so they only count once they fail
+	// in this run
+	c.summary = cp.Summary
+	c.summary.PagesAttempted -= c.summary.PagesFailed
+	c.summary.PagesFailed = 0
+	for _, u := range cp.Failed {
+		c.failed[u] = true
+	}
+	if c.config.Incremental.Enabled && cp.State != nil {
+		c.state = cp.State
+	}
+	for _, key := range cp.ItemKeys {
+		c.itemKeys[key] = true
+	}
+	for u, depth := range cp.Depths {
+		c.depths[u] = depth
+	}
+	for _, u := range cp.Visited {
+		c.visited[u] = true
+		c.seen[u] = true
+	}
+	for _, u := range cp.Frontier {
+		c.seen[u] = true
+	}
+	for _, u := range cp.Links {
+		c.seen[u] = true
+	}
+	return nil
+}
+
+// markVisited records a completed URL that yielded no items and writes a
+// checkpoint if one is due
+func (c *Crawler) markVisited(u string) {
+	if c.visit(u) {
+		c.saveCheckpoint()
+	}
+}
+
+// completePage runs write, which saves the items of u, and marks u visited
+// with no checkpoint in between. write must not call markVisited.
+func (c *Crawler) completePage(u string, write func()) {
+	c.pageMu.RLock()
+	write()
+	due := c.visit(u)
+	c.pageMu.RUnlock()
+
+	if due {
+		c.saveCheckpoint()
+	}
+}
+
+// visit records u as visited and reports whether a checkpoint is due. The
+// checkpoint is claimed under the lock so that only one worker writes it.
+func (c *Crawler) visit(u string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.visited[u] = true
+	due := time.Since(c.lastCheckpoint) >= checkpointInterval
+	if due {
+		c.lastCheckpoint = time.Now()
+	}
+	return due
+}
+
+// isVisited reports whether u was completed by this or a resumed run
+func (c *Crawler) isVisited(u string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.visited[u]
+}
+
+// saveCheckpoint snapshots the crawl state to the checkpoint file. Saves
+// are serialized so that an older snapshot never replaces a newer one.
+func (c *Crawler) saveCheckpoint() {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	// Wait for pages whose items are being written
+	c.pageMu.Lock()
+	c.mu.Lock()
+	cp := &Checkpoint{
+		BaseURL: c.config.BaseURL,
+		Phase:   c.phase,
+		Links:   append([]string(nil), c.links...),
+		Summary: c.summary,
+		SavedAt: time.Now(),
+	}
+	for _, u := range c.frontier {
+		if !c.visited[u] {
+			cp.Frontier = append(cp.Frontier, u)
+			if c.config.FollowLinks {
+				if cp.Depths == nil {
+					cp.Depths = make(map[string]int)
+				}
+				cp.Depths[u] = c.depths[u]
+			}
+		}
+	}
+	for u := range c.visited {
+		cp.Visited = append(cp.Visited, u)
+	}
+	for u := range c.failed {
+		cp.Failed = append(cp.Failed, u)
+	}
+	for key := range c.itemKeys {
+		cp.ItemKeys = append(cp.ItemKeys, key)
+	}
+	if s, ok := c.sink.(offsetSink); ok {
+		cp.OutputOffset = s.Offset()
+	}
+	if c.state != nil {
+		cp.State = make(map[string]*PageState, len(c.state))
+		for u, page := range c.state {
+			copied := *page
+			cp.State[u] = &copied
+		}
+	}
+	c.lastCheckpoint = cp.SavedAt
+	c.mu.Unlock()
+	c.pageMu.Unlock()
+
+	if err := cp.Save(c.config.CheckpointPath()); err != nil {
+		c.logf("Error saving checkpoint: %v", err)
+	}
+}
+
+// removeCheckpoint deletes the checkpoint file after a completed crawl
+func (c *Crawler) removeCheckpoint() {
+	err := os.Remove(c.config.CheckpointPath())
+	if err != nil && !os.IsNotExist(err) {
+		c.logf("Error removing checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"go-project/tools/crawler"
+)
+
+// fixturesCommand checks a config against saved pages and their expected output
+func fixturesCommand(args []string) int {
+	flags := flag.NewFlagSet("fixtures", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to the crawl configuration file")
+	update := flags.Bool("update", false, "Replace the expected links and records with the current output")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: crawl fixtures -config config.json [-update] fixtures.json")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := crawler.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	path := flags.Arg(0)
+	fixtures, err := crawler.LoadFixtures(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := 0
+	for i, want := range fixtures {
+		got, err := crawler.RunFixture(ctx, config, filepath.Dir(path), want)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", want.File, err)
+			failed++
+			continue
+		}
+		if *update {
+			fixtures[i] = got
+			continue
+		}
+		diffs := crawler.DiffFixture(want, got)
+		if len(diffs) == 0 {
+			fmt.Printf("ok   %s\n", want.File)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s\n", want.File)
+		for _, d := range diffs {
+			fmt.Printf("     %s\n", d)
+		}
+	}
+
+	if *update && failed == 0 {
+		if err := crawler.SaveFixtures(path, fixtures); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Updated %d fixtures in %s\n", len(fixtures), path)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d fixtures failed\n", failed, len(fixtures))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go-project/tools/crawler"
+)
+
+// historyCommand lists past runs of scheduled jobs, or shows one run
+func historyCommand(args []string) int {
+	defaultPath, err := crawler.DefaultJobsPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	jobsPath := flags.String("jobs", defaultPath, "Jobs file")
+	jobName := flags.String("job", "", "Only show runs of this job")
+	limit := flags.Int("n", 20, "Number of runs to show, most recent first (0 for all)")
+	asJSON := flags.Bool("json", false, "Print the runs as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: crawl history [-jobs jobs.json] [-job name] [-n 20] [-json] [run-id]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	jobs, err := crawler.LoadJobs(*jobsPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	history, err := crawler.LoadHistory(jobs.HistoryPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	// Most recent first
+	records := []crawler.RunRecord{}
+	for i := len(history) - 1; i >= 0; i-- {
+		r := history[i]
+		if flags.NArg() > 0 && r.ID != flags.Arg(0) {
+			continue
+		}
+		if *jobName != "" && !strings.EqualFold(r.Job, *jobName) {
+			continue
+		}
+		records = append(records, r)
+	}
+	if flags.NArg() > 0 && len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no run %q in %s\n", flags.Arg(0), jobs.HistoryPath())
+		return 1
+	}
+	if *limit > 0 && len(records) > *limit {
+		records = records[:*limit]
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		return 0
+	}
+
+	if flags.NArg() > 0 {
+		printRecord(records[0])
+		return 0
+	}
+	if len(records) == 0 {
+		fmt.Printf("No runs in %s\n", jobs.HistoryPath())
+		return 0
+	}
+	fmt.Printf("%-32s %-19s %-9s %-9s %7s  %s\n", "RUN", "STARTED", "DURATION", "STATUS", "ITEMS", "OUTPUT")
+	for _, r := range records {
+		fmt.Printf("%-32s %-19s %-9s %-9s %7d  %s\n", r.ID, formatTime(r.StartedAt),
+			r.FinishedAt.Sub(r.StartedAt).Round(time.Second), r.Status, r.Items, r.Output)
+	}
+	return 0
+}
+
+// printRecord prints every detail of a run
+func printRecord(r crawler.RunRecord) {
+	fmt.Printf("Run:      %s\n", r.ID)
+	fmt.Printf("Job:      %s (%s)\n", r.Job, r.Trigger)
+	fmt.Printf("Config:   %s\n", r.Config)
+	fmt.Printf("Started:  %s\n", formatTime(r.StartedAt))
+	fmt.Printf("Finished: %s (%s)\n", formatTime(r.FinishedAt), r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+	fmt.Printf("Status:   %s\n", r.Status)
+	if r.Error != "" {
+		fmt.Printf("Error:    %s\n", r.Error)
+	}
+	fmt.Printf("Pages:    %d ok, %d failed\n", r.PagesSucceeded, r.PagesFailed)
+	fmt.Printf("Items:    %d (%d rejected, %d duplicate, %d filtered)\n", r.Items, r.ItemsRejected, r.ItemsDuplicate, r.ItemsFiltered)
+	if r.Output != "" {
+		fmt.Printf("Output:   %s\n", r.Output)
+	}
+	if r.Report != "" {
+		fmt.Printf("Report:   %s\n", r.Report)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"go-project/tools/crawler"
+)
+
+// jobsCommand lists, adds, removes and runs scheduled crawl jobs
+func jobsCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  crawl jobs list [-jobs jobs.json]
+  crawl jobs add [-jobs jobs.json] -name name -config config.json -schedule "0 3 * * *" [-resume] [-disabled]
+  crawl jobs remove [-jobs jobs.json] <name>
+  crawl jobs run [-jobs jobs.json] [-quiet] <name>`)
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	defaultPath, err := crawler.DefaultJobsPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	flags := flag.NewFlagSet("jobs "+args[0], flag.ExitOnError)
+	jobsPath := flags.String("jobs", defaultPath, "Jobs file")
+
+	load := func() *crawler.JobsFile {
+		jobs, err := crawler.LoadJobs(*jobsPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return nil
+		}
+		return jobs
+	}
+
+	switch args[0] {
+	case "list":
+		flags.Parse(args[1:])
+		jobs := load()
+		if jobs == nil {
+			return 1
+		}
+		if len(jobs.Jobs) == 0 {
+			fmt.Printf("No jobs in %s\n", *jobsPath)
+			return 0
+		}
+
+		// Show the last run of each job
+		history, err := crawler.LoadHistory(jobs.HistoryPath())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning:", err)
+		}
+		last := make(map[string]crawler.RunRecord)
+		for _, record := range history {
+			last[record.Job] = record
+		}
+
+		now := time.Now()
+		for _, job := range jobs.Jobs {
+			fmt.Printf("%s  %q\n", job.Name, job.Schedule)
+			fmt.Printf("  config: %s\n", jobs.ConfigPath(job))
+			if job.Disabled {
+				fmt.Println("  next:   disabled")
+			} else if schedule, err := crawler.ParseSchedule(job.Schedule); err == nil {
+				fmt.Printf("  next:   %s\n", formatTime(schedule.Next(now)))
+			}
+			if record, ok := last[job.Name]; ok {
+				fmt.Printf("  last:   %s %s, %d items\n", formatTime(record.StartedAt), record.Status, record.Items)
+			}
+		}
+		return 0
+
+	case "add":
+		name := flags.String("name", "", "Job name")
+		configPath := flags.String("config", "", "Crawl config file")
+		schedule := flags.String("schedule", "", "Cron expression, e.g. \"0 3 * * *\" or @daily")
+		resume := flags.Bool("resume", false, "Continue from the checkpoint left by an interrupted run")
+		disabled := flags.Bool("disabled", false, "Add the job without scheduling it")
+		flags.Parse(args[1:])
+		if *name == "" || *configPath == "" || *schedule == "" {
+			usage()
+			return 2
+		}
+
+		if _, err := crawler.LoadConfig(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		abs, err := filepath.Abs(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+
+		jobs := load()
+		if jobs == nil {
+			return 1
+		}
+		job := crawler.Job{Name: *name, Config: abs, Schedule: *schedule, Resume: *resume, Disabled: *disabled}
+		if err := jobs.Put(job); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if err := jobs.CheckOutputs(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if err := jobs.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Job %q saved to %s\n", job.Name, *jobsPath)
+		return 0
+
+	case "remove":
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			usage()
+			return 2
+		}
+		jobs := load()
+		if jobs == nil {
+			return 1
+		}
+		if !jobs.Remove(flags.Arg(0)) {
+			fmt.Fprintf(os.Stderr, "Error: no job named %q\n", flags.Arg(0))
+			return 1
+		}
+		if err := jobs.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Job %q removed\n", flags.Arg(0))
+		return 0
+
+	case "run":
+		quiet := flags.Bool("quiet", false, "Only print the result")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			usage()
+			return 2
+		}
+		jobs := load()
+		if jobs == nil {
+			return 1
+		}
+		job, ok := jobs.Find(flags.Arg(0))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: no job named %q\n", flags.Arg(0))
+			return 1
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		var logf func(string)
+		if !*quiet {
+			logf = func(msg string) { fmt.Println(msg) }
+		}
+		record := jobs.RunJob(ctx, job, "manual", logf)
+		printRecord(record)
+		if record.Status != "completed" {
+			return 1
+		}
+		return 0
+	}
+
+	usage()
+	return 2
+}
+
+// daemonCommand runs scheduled jobs until interrupted
+func daemonCommand(args []string) int {
+	defaultPath, err := crawler.DefaultJobsPath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	jobsPath := flags.String("jobs", defaultPath, "Jobs file")
+	verbose := flags.Bool("verbose", false, "Print the log of every crawl")
+	flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := crawler.NewScheduler(*jobsPath, func(msg string) {
+		fmt.Printf("%s %s\n", time.Now().Format(time.DateTime), msg)
+	})
+	s.Verbose = *verbose
+	fmt.Printf("%s Scheduling jobs from %s\n", time.Now().Format(time.DateTime), *jobsPath)
+	if err := s.Run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.DateTime)
+}
@@ -0,0 +1,54 @@
+// Command crawl runs the generic web crawler without a display.
+//
+// Usage:
+//
+//	crawl [run] -config config.json [-resume] [-metrics 127.0.0.1:9090]
+//	crawl validate config.json...
+//	crawl preview -config config.json [-detail] [-url page-url] [-json] <url or saved page>
+//	crawl fixtures -config config.json [-update] fixtures.json
+//	crawl process -config config.json [-o output] results.json
+//	crawl template list|check|new|save ...
+//	crawl jobs list|add|remove|run ...
+//	crawl daemon [-jobs jobs.json] [-verbose]
+//	crawl history [-job name] [-n 20] [-json] [run-id]
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var code int
+	switch command {
+	case "run":
+		code = runCommand(args)
+	case "validate":
+		code = validateCommand(args)
+	case "preview":
+		code = previewCommand(args)
+	case "fixtures":
+		code = fixturesCommand(args)
+	case "process":
+		code = processCommand(args)
+	case "template":
+		code = templateCommand(args)
+	case "jobs":
+		code = jobsCommand(args)
+	case "daemon":
+		code = daemonCommand(args)
+	case "history":
+		code = historyCommand(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q; expected run, validate, preview, fixtures, process, template, jobs, daemon or history\n", command)
+		code = 2
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go-project/tools/crawler"
+)
+
+// previewCommand applies a config to one page and shows what it matched
+func previewCommand(args []string) int {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to the crawl configuration file")
+	detail := flags.Bool("detail", false, "Treat the page as a detail page of a two-phase crawl")
+	pageURL := flags.String("url", "", "Address of a saved page, used to resolve its links (default: the first start page)")
+	asJSON := flags.Bool("json", false, "Print the preview as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: crawl preview -config config.json [-detail] [-url page-url] [-json] <url or saved page>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := crawler.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	c, err := crawler.New(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := c.Preview(ctx, flags.Arg(0), crawler.PreviewOptions{Detail: *detail, PageURL: *pageURL})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		return 0
+	}
+	report.WriteText(os.Stdout)
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"go-project/tools/crawler"
+)
+
+// processCommand runs a config's PostProcess pipeline over existing results
+func processCommand(args []string) int {
+	flags := flag.NewFlagSet("process", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to the crawl configuration file")
+	output := flags.String("o", "", "Output file (default: the input with .processed before the extension)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: crawl process -config config.json [-o output] results.json")
+		fmt.Fprintln(flags.Output(), "The results must be raw: the config's own output has been processed during the crawl.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := crawler.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if len(config.PostProcess) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: %s has no PostProcess steps\n", *configPath)
+		return 1
+	}
+	input := flags.Arg(0)
+	if sameFile(input, config.OutputTarget()) {
+		fmt.Fprintf(os.Stderr, "Error: %s is the output of %s, which already went through its PostProcess steps\n", input, *configPath)
+		return 1
+	}
+	if *output == "" {
+		ext := filepath.Ext(input)
+		*output = strings.TrimSuffix(input, ext) + ".processed" + ext
+	}
+
+	for _, step := range config.PostProcess {
+		if strings.EqualFold(step.Type, crawler.StepEnrich) {
+			fmt.Println("Note: enrich steps only run during a crawl and are skipped")
+			break
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	kept, dropped, err := crawler.ProcessFile(ctx, config, input, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	fmt.Printf("Processed %d items from %s: %d kept, %d filtered out\n", kept+dropped, input, kept, dropped)
+	fmt.Printf("Results saved to %s\n", *output)
+	return 0
+}
+
+// sameFile reports whether the paths name the same existing file
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go-project/tools/crawler"
+)
+
+// runCommand runs a crawl to completion
+func runCommand(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to the crawl configuration file")
+	quiet := flags.Bool("quiet", false, "Only print the final summary")
+	resume := flags.Bool("resume", false, "Continue from the checkpoint left by an interrupted crawl")
+	metrics := flags.String("metrics", "", "Serve live metrics on this address (e.g. 127.0.0.1:9090), overriding Metrics.Listen")
+	flags.Parse(args)
+
+	config, err := crawler.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if *metrics != "" {
+		config.Metrics.Listen = *metrics
+	}
+
+	c, err := crawler.New(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	if *resume {
+		if err := c.Resume(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	}
+
+	// Cancel the crawl on Ctrl+C / SIGTERM; results collected so far are still saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	type result struct {
+		summary crawler.Summary
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		summary, err := c.Run(ctx)
+		done <- result{summary, err}
+	}()
+
+	for ev := range c.Events() {
+		if ev.Type == crawler.EventLog && !*quiet {
+			fmt.Println(ev.Message)
+		}
+	}
+
+	res := <-done
+	if *quiet {
+		fmt.Printf("Pages: %d ok, %d failed; items: %d; time: %s\n",
+			res.summary.PagesSucceeded, res.summary.PagesFailed, res.summary.Items, res.summary.Duration)
+	}
+	if res.err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", res.err)
+		return 1
+	}
+	return 0
+}
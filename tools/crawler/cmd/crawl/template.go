@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go-project/tools/crawler"
+)
+
+// varFlags collects repeated -var name=value flags
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[name] = value
+	return nil
+}
+
+// templateCommand lists, instantiates and saves crawl templates
+func templateCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, `Usage:
+  crawl template list [-dir dir]
+  crawl template check [-dir dir]
+  crawl template new [-dir dir] [-var name=value]... [-o config.json] <template>
+  crawl template save [-dir dir] -config config.json -name name [-description text] [-var name=default]...`)
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	defaultDir, err := crawler.DefaultTemplateDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	flags := flag.NewFlagSet("template "+args[0], flag.ExitOnError)
+	dir := flags.String("dir", defaultDir, "Directory user templates are kept in")
+	vars := varFlags{}
+
+	switch args[0] {
+	case "list":
+		flags.Parse(args[1:])
+		templates, err := crawler.LoadTemplates(*dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		for _, t := range templates {
+			source := "built-in"
+			if t.Path != "" {
+				source = t.Path
+			}
+			fmt.Printf("%s (%s)\n", t.Name, source)
+			if t.Description != "" {
+				fmt.Printf("  %s\n", t.Description)
+			}
+			for _, v := range t.Variables {
+				fmt.Printf("  {%s}", v.Name)
+				if v.Default != "" {
+					fmt.Printf(" = %s", v.Default)
+				}
+				if v.Description != "" {
+					fmt.Printf("  %s", v.Description)
+				}
+				fmt.Println()
+			}
+		}
+		return 0
+
+	case "check":
+		// Every template must give a valid config with its default values,
+		// so that "template new" works without -var
+		flags.Parse(args[1:])
+		templates, err := crawler.LoadTemplates(*dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		code := 0
+		for _, t := range templates {
+			config, err := t.Instantiate(nil)
+			if err == nil {
+				err = crawler.Validate(config)
+			}
+			if err != nil {
+				fmt.Printf("%s: %v\n", t.Name, err)
+				code = 1
+				continue
+			}
+			fmt.Printf("%s: OK\n", t.Name)
+		}
+		return code
+
+	case "new":
+		flags.Var(vars, "var", "Value of a template variable as name=value (repeatable)")
+		out := flags.String("o", "", "Write the config to this file instead of standard output")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			usage()
+			return 2
+		}
+		t, err := crawler.FindTemplate(*dir, flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		config, err := t.Instantiate(vars)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if err := crawler.Validate(config); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: the config is not valid yet:", err)
+		}
+
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(config); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if *out == "" {
+			os.Stdout.Write(buf.Bytes())
+			return 0
+		}
+		if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Config written to %s\n", *out)
+		return 0
+
+	case "save":
+		flags.Var(vars, "var", "Declare a variable used as {name} in the config, as name=default (repeatable)")
+		configPath := flags.String("config", "", "Config file to save as a template")
+		name := flags.String("name", "", "Template name")
+		description := flags.String("description", "", "Template description")
+		flags.Parse(args[1:])
+		if *configPath == "" || *name == "" {
+			usage()
+			return 2
+		}
+
+		// Placeholders may make the config invalid until instantiated, so it
+		// is only parsed here
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		t := crawler.Template{Name: *name, Description: *description}
+		if err := json.Unmarshal(crawler.StripJSONComments(data), &t.Config); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: error parsing config file:", err)
+			return 1
+		}
+		names := make([]string, 0, len(vars))
+		for varName := range vars {
+			names = append(names, varName)
+		}
+		sort.Strings(names)
+		for _, varName := range names {
+			t.Variables = append(t.Variables, crawler.TemplateVariable{Name: varName, Default: vars[varName]})
+		}
+
+		file, err := crawler.SaveTemplate(*dir, t)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Template %q saved to %s\n", t.Name, file)
+		return 0
+	}
+
+	usage()
+	return 2
+}
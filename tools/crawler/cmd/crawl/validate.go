@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go-project/tools/crawler"
+)
+
+// validateCommand checks config files without crawling, printing each
+// problem as file:line:column: message
+func validateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: crawl validate config.json...")
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		_, problems, err := crawler.CheckConfigFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			code = 1
+			continue
+		}
+		for _, p := range problems {
+			if p.Line > 0 {
+				fmt.Printf("%s:%d:%d: %s\n", path, p.Line, p.Column, p.Message)
+			} else {
+				fmt.Printf("%s: %s\n", path, p.Message)
+			}
+		}
+		if len(problems) > 0 {
+			code = 1
+		} else {
+			fmt.Printf("%s: OK\n", path)
+		}
+	}
+	return code
+}
@@ -0,0 +1,373 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CrawlItem represents a generic item crawled from a webpage
+type CrawlItem struct {
+	URL         string            `json:"url"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Attributes  map[string]string `json:"attributes"`
+	Content     []string          `json:"content"`
+	Links       []string          `json:"links"`
+	Timestamp   time.Time         `json:"timestamp"`
+	// Data holds the typed values extracted by FileConfig.Schema
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// FileConfig holds the configuration loaded from a JSON file
+type FileConfig struct {
+	BaseURL             string              `json:"BaseURL"`
+	StartPage           int                 `json:"StartPage"`
+	EndPage             int                 `json:"EndPage"`
+	PagePattern         string              `json:"PagePattern"`
+	Pagination          PaginationConfig    `json:"Pagination"`
+	Sitemaps            []string            `json:"Sitemaps,omitempty"`
+	Selector            string              `json:"Selector"`
+	AttributeSelector   string              `json:"AttributeSelector"`
+	ContentSelector     string              `json:"ContentSelector,omitempty"`
+	AdvancedConfig      AdvancedConfig      `json:"AdvancedConfig"`
+	TwoPhaseCrawlConfig TwoPhaseCrawlConfig `json:"TwoPhaseCrawlConfig"`
+	Schema              []FieldConfig       `json:"Schema,omitempty"`
+	Dedup               DedupConfig         `json:"Dedup"`
+	PostProcess         []ProcessStep       `json:"PostProcess,omitempty"`
+	JSON                JSONConfig          `json:"JSON"`
+	LinkConfig          LinkConfig          `json:"LinkConfig"`
+	OutputFile          string              `json:"OutputFile"`
+	Output              OutputConfig        `json:"Output"`
+	Incremental         IncrementalConfig   `json:"Incremental"`
+	CheckpointFile      string              `json:"CheckpointFile,omitempty"`
+	HTTP                HTTPConfig          `json:"HTTP"`
+	Metrics             MetricsConfig       `json:"Metrics"`
+	Robots              RobotsConfig        `json:"Robots"`
+	TwoPhaseCrawl       bool                `json:"TwoPhaseCrawl"`
+	FollowLinks         bool                `json:"FollowLinks"`
+}
+
+// AdvancedConfig holds advanced configuration options for the crawler.
+// RateLimit is the minimum interval per host in milliseconds; Burst lets a
+// host briefly exceed it. RetryDelay is the base of the exponential backoff
+// (seconds), capped at MaxRetryDelay. After FailureThreshold consecutive
+// failures a host is paused for FailureCooldown seconds. With FollowLinks,
+// MaxDepth is how many links away from the listing pages the crawl may go.
+type AdvancedConfig struct {
+	MaxConcurrent    int      `json:"MaxConcurrent"`
+	MaxRetries       int      `json:"MaxRetries"`
+	RetryDelay       int      `json:"RetryDelay"`
+	MaxRetryDelay    int      `json:"MaxRetryDelay,omitempty"`
+	RateLimit        int      `json:"RateLimit"`
+	Burst            int      `json:"Burst,omitempty"`
+	FailureThreshold int      `json:"FailureThreshold,omitempty"`
+	FailureCooldown  int      `json:"FailureCooldown,omitempty"`
+	MaxDepth         int      `json:"MaxDepth"`
+	CustomFilters    []string `json:"CustomFilters"`
+}
+
+// AttributeConfig holds configuration for attributes to extract in two-phase crawl
+type AttributeConfig struct {
+	Selector          string `json:"Selector"`
+	ElementAttribute  string `json:"ElementAttribute"`
+	JsonAttribute     string `json:"JsonAttribute"`
+	GetElementContent bool   `json:"GetElementContent"`
+}
+
+// TwoPhaseCrawlConfig holds configuration for two-phase crawling
+type TwoPhaseCrawlConfig struct {
+	Attributes []AttributeConfig `json:"Attributes"`
+}
+
+// LinkConfig scopes the links followed when FollowLinks is enabled
+type LinkConfig struct {
+	// Selector picks the elements whose href is followed (default "a[href]")
+	Selector string `json:"Selector,omitempty"`
+	// Scope is "same-domain" (default), "allowlist" or "any"
+	Scope string `json:"Scope,omitempty"`
+	// AllowedDomains lists the domains (and their subdomains) followed with the allowlist scope
+	AllowedDomains []string `json:"AllowedDomains,omitempty"`
+	// Include and Exclude are URL regexes; a link must match one Include
+	// pattern, if any are given, and no Exclude pattern
+	Include []string `json:"Include,omitempty"`
+	Exclude []string `json:"Exclude,omitempty"`
+}
+
+// RetryDelayDuration returns AdvancedConfig.RetryDelay (seconds) as a duration
+func (c FileConfig) RetryDelayDuration() time.Duration {
+	return time.Duration(c.AdvancedConfig.RetryDelay) * time.Second
+}
+
+// RateLimitDuration returns AdvancedConfig.RateLimit (milliseconds) as a duration
+func (c FileConfig) RateLimitDuration() time.Duration {
+	return time.Duration(c.AdvancedConfig.RateLimit) * time.Millisecond
+}
+
+// PageURL returns BaseURL with PagePattern replaced by the page number
+func (c FileConfig) PageURL(page int) string {
+	return strings.Replace(c.BaseURL, c.PagePattern, fmt.Sprintf("%d", page), 1)
+}
+
+// CheckpointPath returns CheckpointFile, defaulting to OutputFile + ".checkpoint"
+func (c FileConfig) CheckpointPath() string {
+	if c.CheckpointFile != "" {
+		return c.CheckpointFile
+	}
+	return c.OutputFile + ".checkpoint"
+}
+
+// LoadConfig reads and validates a FileConfig from a JSON file.
+// Line comments starting with // are allowed outside of string values.
+func LoadConfig(filePath string) (FileConfig, error) {
+	var config FileConfig
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return config, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	if err := json.Unmarshal(StripJSONComments(data), &config); err != nil {
+		return config, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	if err := Validate(config); err != nil {
+		return config, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	return config, nil
+}
+
+// StripJSONComments blanks out // line comments that are not inside a
+// string, so URLs such as https://example.com survive and error offsets still
+// point into the original file
+func StripJSONComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		} else if c == '/' && i+1 < len(data) && data[i+1] == '/' {
+			// Blank the comment out so offsets still match the file
+			for i < len(data) && data[i] != '\n' {
+				out = append(out, ' ')
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// Validate checks a FileConfig for missing or inconsistent values; every
+// problem found is joined in the error
+func Validate(config FileConfig) error {
+	return errors.Join(validateConfig(config)...)
+}
+
+// validateConfig returns every problem of a FileConfig. Checks that depend on
+// an earlier one, such as compiling a missing selector, are skipped.
+func validateConfig(config FileConfig) []error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Check required fields
+	if config.BaseURL == "" && len(config.Sitemaps) == 0 {
+		errs = append(errs, fmt.Errorf("BaseURL is required"))
+	}
+
+	if config.BaseURL != "" {
+		check(validatePagination(config))
+	}
+
+	for i, sm := range config.Sitemaps {
+		if u, err := url.Parse(sm); err != nil || !u.IsAbs() {
+			errs = append(errs, fmt.Errorf("Sitemaps[%d] must be an absolute URL", i))
+		}
+	}
+
+	if config.Selector == "" && config.JSON.ItemsPath == "" && len(config.Sitemaps) == 0 {
+		errs = append(errs, fmt.Errorf("Selector is required"))
+	}
+
+	if config.Selector != "" {
+		if _, err := CompileSelector(config.Selector); err != nil {
+			errs = append(errs, fmt.Errorf("Selector: %v", err))
+		}
+	}
+
+	_, err := compileJSONConfig(config.JSON)
+	check(err)
+
+	if config.ContentSelector != "" {
+		if _, err := CompileSelector(config.ContentSelector); err != nil {
+			errs = append(errs, fmt.Errorf("ContentSelector: %v", err))
+		}
+	}
+
+	if config.TwoPhaseCrawl && config.AttributeSelector == "" && config.JSON.URLPath == "" {
+		errs = append(errs, fmt.Errorf("AttributeSelector (or JSON.URLPath) is required when TwoPhaseCrawl is enabled"))
+	}
+
+	if config.OutputFile == "" {
+		errs = append(errs, fmt.Errorf("OutputFile is required"))
+	}
+
+	check(validateOutput(config))
+
+	// Validate AdvancedConfig
+	if config.AdvancedConfig.MaxConcurrent < 1 {
+		errs = append(errs, fmt.Errorf("AdvancedConfig.MaxConcurrent must be at least 1"))
+	}
+
+	if config.AdvancedConfig.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("AdvancedConfig.MaxRetries must be at least 0"))
+	}
+
+	if config.AdvancedConfig.RetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("AdvancedConfig.RetryDelay must be at least 0"))
+	}
+
+	if config.AdvancedConfig.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("AdvancedConfig.RateLimit must be at least 0"))
+	}
+
+	if config.AdvancedConfig.MaxRetryDelay < 0 || config.AdvancedConfig.Burst < 0 ||
+		config.AdvancedConfig.FailureThreshold < 0 || config.AdvancedConfig.FailureCooldown < 0 {
+		errs = append(errs, fmt.Errorf("AdvancedConfig.MaxRetryDelay, Burst, FailureThreshold and FailureCooldown must be at least 0"))
+	}
+
+	for i, filter := range config.AdvancedConfig.CustomFilters {
+		if _, err := regexp.Compile(filter); err != nil {
+			errs = append(errs, fmt.Errorf("AdvancedConfig.CustomFilters[%d]: %v", i, err))
+		}
+	}
+
+	if config.AdvancedConfig.MaxDepth < 0 {
+		errs = append(errs, fmt.Errorf("AdvancedConfig.MaxDepth must be at least 0"))
+	}
+
+	check(validateLinkConfig(config.LinkConfig))
+	check(validateHTTPConfig(config.HTTP))
+
+	_, err = compileSchema(config.Schema, "Schema")
+	check(err)
+
+	_, err = compileDedupKeys(config.Dedup)
+	check(err)
+
+	_, err = compilePipeline(config.PostProcess)
+	check(err)
+
+	// Validate TwoPhaseCrawlConfig if TwoPhaseCrawl is enabled
+	if config.TwoPhaseCrawl && len(config.TwoPhaseCrawlConfig.Attributes) == 0 && len(config.Schema) == 0 {
+		errs = append(errs, fmt.Errorf("TwoPhaseCrawlConfig.Attributes or Schema is required when TwoPhaseCrawl is enabled"))
+	}
+
+	// Validate each attribute in TwoPhaseCrawlConfig
+	for i, attr := range config.TwoPhaseCrawlConfig.Attributes {
+		if attr.Selector == "" {
+			errs = append(errs, fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].Selector is required", i))
+		} else if _, err := CompileSelector(attr.Selector); err != nil {
+			errs = append(errs, fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].Selector: %v", i, err))
+		}
+
+		if attr.JsonAttribute == "" {
+			errs = append(errs, fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].JsonAttribute is required", i))
+		}
+
+		// If GetElementContent is false, ElementAttribute is required
+		if !attr.GetElementContent && attr.ElementAttribute == "" {
+			errs = append(errs, fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].ElementAttribute is required when GetElementContent is false", i))
+		}
+	}
+
+	return errs
+}
+
+// validateOutput checks the output type and its database settings
+func validateOutput(config FileConfig) error {
+	switch config.OutputType() {
+	case OutputJSON, OutputJSONL, OutputCSV:
+	case OutputSQLite:
+		if config.Output.Table != "" && !sqlIdentifier.MatchString(config.Output.Table) {
+			return fmt.Errorf("Output.Table must be a plain SQL identifier")
+		}
+	case OutputMongoDB:
+		if config.Output.Target == "" {
+			return fmt.Errorf("Output.Target must be a MongoDB connection string when Output.Type is %q", OutputMongoDB)
+		}
+	default:
+		return fmt.Errorf("Output.Type must be one of %q, %q, %q, %q or %q",
+			OutputJSON, OutputJSONL, OutputCSV, OutputSQLite, OutputMongoDB)
+	}
+
+	switch strings.ToLower(config.Output.Record) {
+	case "", RecordItem:
+	case RecordData:
+		if len(config.Schema) == 0 {
+			return fmt.Errorf("Output.Record %q needs a Schema", RecordData)
+		}
+		if t := config.OutputType(); t != OutputJSON && t != OutputJSONL {
+			return fmt.Errorf("Output.Record %q is only supported by the %q and %q output types", RecordData, OutputJSON, OutputJSONL)
+		}
+	default:
+		return fmt.Errorf("Output.Record must be %q or %q", RecordItem, RecordData)
+	}
+	return nil
+}
+
+// validateLinkConfig checks the link following selector, scope and URL patterns
+func validateLinkConfig(links LinkConfig) error {
+	if links.Selector != "" {
+		if _, err := CompileSelector(links.Selector); err != nil {
+			return fmt.Errorf("LinkConfig.Selector: %v", err)
+		}
+	}
+
+	switch links.Scope {
+	case "", ScopeSameDomain, ScopeAny:
+	case ScopeAllowlist:
+		if len(links.AllowedDomains) == 0 {
+			return fmt.Errorf("LinkConfig.AllowedDomains is required when Scope is %q", ScopeAllowlist)
+		}
+	default:
+		return fmt.Errorf("LinkConfig.Scope must be %q, %q or %q", ScopeSameDomain, ScopeAllowlist, ScopeAny)
+	}
+
+	for i, pattern := range links.Include {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("LinkConfig.Include[%d]: %v", i, err)
+		}
+	}
+	for i, pattern := range links.Exclude {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("LinkConfig.Exclude[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,729 @@
+// Package crawler implements the headless generic web crawl engine shared by
+// the Fyne GUI (tools/generic_web_crawler.go) and the crawl command line runner.
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// EventType identifies the kind of progress event emitted by a Crawler
+type EventType int
+
+const (
+	// EventLog carries a human readable log line in Message
+	EventLog EventType = iota
+	// EventProgress carries the overall completion ratio (0..1) in Progress
+	EventProgress
+	// EventItem carries a newly crawled item in Item
+	EventItem
+)
+
+// Event is a progress notification emitted on Crawler.Events
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Message  string
+	Progress float64
+	Item     *CrawlItem
+}
+
+// Summary reports the outcome of a crawl run
+type Summary struct {
+	PagesAttempted int
+	PagesSucceeded int
+	PagesFailed    int
+	PagesBlocked   int
+	PagesUnchanged int
+	Items          int
+	ItemsRejected  int
+	ItemsDuplicate int
+	ItemsFiltered  int
+	Duration       time.Duration
+	Blocked        []string
+}
+
+// Crawler runs a crawl described by a FileConfig. Progress is reported on the
+// Events channel, which callers must drain until it is closed by Run.
+type Crawler struct {
+	config FileConfig
+	events chan Event
+
+	selector        *Selector
+	contentSelector *Selector
+	attrSelectors   []*Selector
+	linkSelector    *Selector
+	filters         []*regexp.Regexp
+	scope           *linkScope
+	paginator       *paginator
+	schema          []*schemaField
+	json            *jsonPaths
+	dedupKeys       []dedupKey
+	pipeline        []processStep
+
+	fetcher *fetcher
+	sink    Sink
+
+	// pageMeta holds the fetch details of each page for enrich steps
+	pageMeta map[string]pageMeta
+
+	// Incremental recrawl: what the previous run saw and what this one sees
+	prevState *CrawlState
+	state     map[string]*PageState
+
+	mu      sync.Mutex
+	summary Summary
+
+	// Resumable state, persisted by saveCheckpoint. phase is 1 while listing
+	// pages are crawled and 2 while detail links are crawled; frontier holds
+	// the URLs of the current phase and links the detail links found so far.
+	// With FollowLinks, frontier holds every discovered URL and depths how
+	// many links away from a listing page each one is.
+	resumed        bool
+	outputOffset   int64
+	phase          int
+	frontier       []string
+	links          []string
+	depths         map[string]int
+	visited        map[string]bool
+	failed         map[string]bool
+	seen           map[string]bool
+	itemKeys       map[string]bool
+	lastCheckpoint time.Time
+	// saveMu serializes checkpoint writes
+	saveMu sync.Mutex
+	// pageMu is read-locked by completePage while a page's items are written
+	// and the page is marked visited, and locked by saveCheckpoint, so that a
+	// checkpoint never holds the items of a page it does not mark visited
+	pageMu sync.RWMutex
+}
+
+// New validates config and prepares a Crawler for a single Run
+func New(config FileConfig) (*Crawler, error) {
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+
+	c := &Crawler{
+		config:   config,
+		events:   make(chan Event, 256),
+		depths:   make(map[string]int),
+		visited:  make(map[string]bool),
+		failed:   make(map[string]bool),
+		seen:     make(map[string]bool),
+		itemKeys: make(map[string]bool),
+	}
+
+	var err error
+	if config.Selector != "" {
+		if c.selector, err = CompileSelector(config.Selector); err != nil {
+			return nil, err
+		}
+	}
+	if config.ContentSelector != "" {
+		if c.contentSelector, err = CompileSelector(config.ContentSelector); err != nil {
+			return nil, err
+		}
+	}
+	for _, attr := range config.TwoPhaseCrawlConfig.Attributes {
+		sel, err := CompileSelector(attr.Selector)
+		if err != nil {
+			return nil, err
+		}
+		c.attrSelectors = append(c.attrSelectors, sel)
+	}
+	linkSelector := config.LinkConfig.Selector
+	if linkSelector == "" {
+		linkSelector = defaultLinkSelector
+	}
+	if c.linkSelector, err = CompileSelector(linkSelector); err != nil {
+		return nil, err
+	}
+	if c.scope, err = newLinkScope(config); err != nil {
+		return nil, err
+	}
+	if c.paginator, err = newPaginator(config); err != nil {
+		return nil, err
+	}
+	if c.schema, err = compileSchema(config.Schema, "Schema"); err != nil {
+		return nil, err
+	}
+	if c.json, err = compileJSONConfig(config.JSON); err != nil {
+		return nil, err
+	}
+	if c.dedupKeys, err = compileDedupKeys(config.Dedup); err != nil {
+		return nil, err
+	}
+	if c.pipeline, err = compilePipeline(config.PostProcess); err != nil {
+		return nil, err
+	}
+	if needsPageMeta(c.pipeline) {
+		c.pageMeta = make(map[string]pageMeta)
+	}
+	for _, filter := range config.AdvancedConfig.CustomFilters {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid custom filter %q: %v", filter, err)
+		}
+		c.filters = append(c.filters, re)
+	}
+
+	if c.fetcher, err = newFetcher(config, c.logf); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Config returns the configuration the crawler was created with
+func (c *Crawler) Config() FileConfig {
+	return c.config
+}
+
+// Events returns the channel progress events are delivered on
+func (c *Crawler) Events() <-chan Event {
+	return c.events
+}
+
+// SetSink replaces the output configured in FileConfig.Output with s. It
+// must be called before Run, which closes the sink when it returns.
+func (c *Crawler) SetSink(s Sink) {
+	c.sink = s
+}
+
+// Run performs the crawl, writing each item to the output as soon as it is
+// found. It stops early when ctx is cancelled, leaving a checkpoint for
+// Resume, and closes the Events channel before returning.
+func (c *Crawler) Run(ctx context.Context) (summary Summary, err error) {
+	defer close(c.events)
+
+	startTime := time.Now()
+
+	if c.config.Metrics.Listen != "" {
+		stop, err := c.serveMetrics(c.config.Metrics.Listen)
+		if err != nil {
+			c.logf("%v", err)
+			return Summary{}, err
+		}
+		defer stop()
+	}
+	defer func() { c.writeReport(err) }()
+
+	if c.config.HTTP.Login != nil {
+		c.logf("Logging in at %s...", c.config.HTTP.Login.URL)
+		if err := c.fetcher.session.doLogin(ctx); err != nil {
+			c.logf("Error logging in: %v", err)
+			return Summary{}, err
+		}
+		c.logf("Logged in")
+	}
+
+	if c.sink == nil {
+		sink, err := openSink(ctx, c.config, c.resumed, c.outputOffset)
+		if err != nil {
+			c.logf("Error opening output: %v", err)
+			return Summary{}, err
+		}
+		c.sink = sink
+	}
+
+	if c.config.Incremental.Enabled {
+		c.loadPreviousState()
+	}
+
+	if c.resumed {
+		c.logf("Resuming from checkpoint: %d pages already crawled, %d items collected", len(c.visited), c.summary.Items)
+	} else {
+		c.phase = 1
+		for _, u := range c.config.StartURLs() {
+			c.enqueue(u, 0)
+		}
+	}
+
+	switch {
+	case c.config.FollowLinks:
+		c.followLinksCrawl(ctx)
+	case c.config.TwoPhaseCrawl:
+		c.twoPhaseCrawl(ctx)
+	default:
+		c.singlePhaseCrawl(ctx)
+	}
+
+	c.mu.Lock()
+	c.summary.Duration += time.Since(startTime)
+	summary = c.summary
+	c.mu.Unlock()
+
+	// Keep a checkpoint when the run was interrupted or pages failed, so a
+	// resumed run can pick up the remaining frontier
+	if ctx.Err() != nil || summary.PagesFailed > 0 {
+		if ctx.Err() != nil {
+			c.logf("Crawling stopped")
+		}
+		c.saveCheckpoint()
+		c.logf("Checkpoint saved to %s", c.config.CheckpointPath())
+	} else {
+		c.removeCheckpoint()
+	}
+
+	c.logf("\nCrawling summary:")
+	c.logf("Total pages attempted: %d", summary.PagesAttempted)
+	if summary.PagesAttempted > 0 {
+		c.logf("Successfully crawled: %d (%.1f%%)", summary.PagesSucceeded, float64(summary.PagesSucceeded)/float64(summary.PagesAttempted)*100)
+		c.logf("Failed pages: %d (%.1f%%)", summary.PagesFailed, float64(summary.PagesFailed)/float64(summary.PagesAttempted)*100)
+	}
+	if summary.PagesUnchanged > 0 {
+		c.logf("Unchanged since last run: %d", summary.PagesUnchanged)
+	}
+	if summary.ItemsRejected > 0 {
+		c.logf("Items rejected by schema: %d", summary.ItemsRejected)
+	}
+	if summary.ItemsFiltered > 0 {
+		c.logf("Items filtered out: %d", summary.ItemsFiltered)
+	}
+	if summary.ItemsDuplicate > 0 {
+		c.logf("Duplicate items skipped: %d", summary.ItemsDuplicate)
+	}
+	if summary.PagesBlocked > 0 {
+		c.logf("Blocked by robots.txt: %d", summary.PagesBlocked)
+		for _, u := range summary.Blocked {
+			c.logf("  %s", u)
+		}
+	}
+	c.logf("Total time: %s", summary.Duration)
+	c.logf("Total items found: %d", summary.Items)
+
+	if err := c.sink.Close(); err != nil {
+		c.logf("Error saving results: %v", err)
+		return summary, err
+	}
+	c.logf("Data saved to %s", c.outputName())
+
+	// Only a complete run is a baseline for the next one
+	if c.config.Incremental.Enabled && ctx.Err() == nil {
+		c.finishIncremental()
+	}
+
+	return summary, ctx.Err()
+}
+
+// singlePhaseCrawl extracts one item per Selector match on each listing page
+func (c *Crawler) singlePhaseCrawl(ctx context.Context) {
+	c.crawlListing(ctx, 1, func(pageURL string, doc *document) int {
+		return c.extractItems(ctx, pageURL, doc, 0)
+	})
+}
+
+// extractItems adds one item per Selector match on a listing page (per JSON
+// item or feed entry in those responses) and returns the number of matches.
+// A sitemap's URLs are added to the frontier instead.
+func (c *Crawler) extractItems(ctx context.Context, pageURL string, doc *document, depth int) int {
+	switch doc.kind {
+	case docJSON:
+		return c.extractJSONItems(pageURL, doc)
+	case docFeed:
+		return c.extractFeed(pageURL, doc)
+	case docSitemap:
+		return c.addSitemap(pageURL, doc, depth)
+	}
+
+	elementCount := 0
+	for _, n := range c.matchItems(doc) {
+		if ctx.Err() != nil {
+			return elementCount
+		}
+		elementCount++
+
+		item := newItem(pageURL)
+		item.Title = strings.TrimSpace(TextContent(n))
+
+		// Extract attributes
+		for _, attr := range n.Attr {
+			item.Attributes[attr.Key] = attr.Val
+
+			// If this is the attribute we're looking for and it's a link, add it to links
+			if attr.Key == c.config.AttributeSelector && (attr.Key == "href" || attr.Key == "src") {
+				item.Links = append(item.Links, attr.Val)
+			}
+		}
+
+		// Extract content from specified selector
+		if c.contentSelector != nil {
+			extractContent(n, c.contentSelector, &item)
+		}
+		applyFilters(c.filters, &item)
+
+		if !c.applySchema(&item, n) {
+			continue
+		}
+		if c.addItem(pageURL, item) {
+			c.logf("Found item: %s", item.Title)
+		}
+	}
+
+	c.logf("Successfully processed page %s, found %d %s elements", pageURL, elementCount, c.config.Selector)
+	return elementCount
+}
+
+// twoPhaseCrawl collects links from the listing pages, then crawls each link
+func (c *Crawler) twoPhaseCrawl(ctx context.Context) {
+	if c.phase < 2 {
+		c.collectLinks(ctx)
+		if ctx.Err() != nil {
+			c.logf("Crawling stopped during phase 1")
+			return
+		}
+
+		c.mu.Lock()
+		c.phase = 2
+		c.frontier = c.links
+		c.mu.Unlock()
+	} else if retry := c.failedListings(); len(retry) > 0 {
+		// Listing pages that failed before phase 2 began are not in the
+		// frontier any more; collect their links now
+		c.logf("Retrying %d listing pages that failed", len(retry))
+		c.mu.Lock()
+		c.frontier = retry
+		c.mu.Unlock()
+		c.collectLinks(ctx)
+		if ctx.Err() != nil {
+			c.logf("Crawling stopped during phase 1")
+			return
+		}
+		c.mu.Lock()
+		c.frontier = c.links
+		c.mu.Unlock()
+	}
+
+	// Phase 2: Crawl each link
+	links := c.frontier
+	c.logf("\nPhase 2: Crawling %d individual links...", len(links))
+	var done int32
+
+	c.runPool(ctx, len(links), func(i int) {
+		if c.isVisited(links[i]) {
+			return
+		}
+
+		doc, ok := c.fetchContent(ctx, links[i])
+		if !ok {
+			return
+		}
+
+		c.completePage(links[i], func() { c.addDetail(links[i], doc, 1) })
+
+		c.progress(0.5 + float64(atomic.AddInt32(&done, 1))/float64(len(links))*0.5)
+	})
+}
+
+// failedListings returns the failed pages of a resumed two-phase crawl that
+// are not detail links, i.e. the listing pages of phase 1
+func (c *Crawler) failedListings() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.failed) == 0 {
+		return nil
+	}
+	links := make(map[string]bool, len(c.links))
+	for _, u := range c.links {
+		links[u] = true
+	}
+	var pages []string
+	for u := range c.failed {
+		if !links[u] && !c.visited[u] {
+			pages = append(pages, u)
+		}
+	}
+	sort.Strings(pages)
+	return pages
+}
+
+// collectLinks runs phase 1 of a two-phase crawl over the listing pages
+func (c *Crawler) collectLinks(ctx context.Context) {
+	// Phase 1: Get all links
+	c.logf("Phase 1: Collecting links...")
+
+	c.crawlListing(ctx, 0.5, func(pageURL string, doc *document) int {
+		switch doc.kind {
+		case docFeed:
+			return c.extractFeed(pageURL, doc)
+		case docSitemap:
+			return c.addSitemap(pageURL, doc, 0)
+		}
+		links, matches := c.listingLinks(pageURL, doc)
+
+		// Drop links already found
+		pageLinks := make([]string, 0)
+		for _, u := range links {
+			if c.markSeen(u.String()) {
+				pageLinks = append(pageLinks, u.String())
+			}
+		}
+
+		// Add found links to the global list
+		c.mu.Lock()
+		c.links = append(c.links, pageLinks...)
+		c.mu.Unlock()
+
+		if len(pageLinks) > 0 {
+			c.logf("Found %d links on page %s", len(pageLinks), pageURL)
+		}
+		return matches
+	})
+}
+
+// markSeen records u in the seen set and reports whether it is new
+func (c *Crawler) markSeen(u string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[u] {
+		return false
+	}
+	c.seen[u] = true
+	return true
+}
+
+// applySchema fills item.Data from the schema fields inside scope. It
+// reports false, counting the item as rejected, when a required field is missing.
+func (c *Crawler) applySchema(item *CrawlItem, scope *html.Node) bool {
+	if len(c.schema) == 0 {
+		return true
+	}
+
+	base, _ := url.Parse(item.URL)
+	data, err := extractObject(c.schema, scope, base)
+	if err != nil {
+		c.mu.Lock()
+		c.summary.ItemsRejected++
+		c.mu.Unlock()
+		c.logf("Rejected item from %s: %v", item.URL, err)
+		return false
+	}
+	item.Data = data
+	return true
+}
+
+// addDetail adds the item of a detail page found at depth. Feeds add one
+// item per entry and sitemaps add their URLs to the frontier.
+func (c *Crawler) addDetail(pageURL string, doc *document, depth int) {
+	switch doc.kind {
+	case docFeed:
+		c.extractFeed(pageURL, doc)
+		return
+	case docSitemap:
+		c.addSitemap(pageURL, doc, depth)
+		return
+	}
+
+	if item, ok := c.extractDetail(pageURL, doc); ok {
+		if c.addItem(pageURL, item) {
+			c.logf("Crawled: %s", item.Title)
+		}
+	}
+}
+
+// extractDetail builds the item for an HTML or JSON detail page; ok is false
+// when the schema rejects it
+func (c *Crawler) extractDetail(pageURL string, page *document) (CrawlItem, bool) {
+	if page.kind == docJSON {
+		return c.jsonDetail(pageURL, page)
+	}
+
+	doc := page.html
+	item := newItem(pageURL)
+	item.Title = findTitle(doc)
+
+	// Extract content based on content selector
+	if c.contentSelector != nil {
+		extractContent(doc, c.contentSelector, &item)
+	}
+
+	// Extract configured attributes from the first matching element
+	for i, attr := range c.config.TwoPhaseCrawlConfig.Attributes {
+		n := c.attrSelectors[i].MatchFirst(doc)
+		if n == nil {
+			continue
+		}
+		if attr.GetElementContent {
+			item.Attributes[attr.JsonAttribute] = strings.TrimSpace(TextContent(n))
+		} else {
+			item.Attributes[attr.JsonAttribute] = getAttr(n, attr.ElementAttribute)
+		}
+	}
+
+	applyFilters(c.filters, &item)
+	return item, c.applySchema(&item, doc)
+}
+
+// fetchPage fetches a page and records the outcome in the summary
+func (c *Crawler) fetchPage(ctx context.Context, pageURL string) (*document, bool) {
+	doc, _, ok := c.fetchPageInfo(ctx, pageURL, nil)
+	return doc, ok
+}
+
+// fetchPageInfo is fetchPage with a conditional request against prev
+func (c *Crawler) fetchPageInfo(ctx context.Context, pageURL string, prev *PageState) (*document, pageInfo, bool) {
+	doc, info, err := c.fetcher.fetch(ctx, pageURL, prev)
+	if err != nil && ctx.Err() != nil {
+		return nil, info, false
+	}
+
+	if errors.Is(err, ErrBlockedByRobots) {
+		c.mu.Lock()
+		c.summary.PagesBlocked++
+		c.summary.Blocked = append(c.summary.Blocked, pageURL)
+		c.mu.Unlock()
+
+		// Blocked pages are done for good; don't retry them on resume
+		c.markVisited(pageURL)
+		c.logf("Skipping %s: %v", pageURL, ErrBlockedByRobots)
+		return nil, info, false
+	}
+
+	c.mu.Lock()
+	c.summary.PagesAttempted++
+	if err != nil {
+		c.summary.PagesFailed++
+		c.failed[pageURL] = true
+	} else {
+		c.summary.PagesSucceeded++
+		delete(c.failed, pageURL)
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.keepPreviousState(pageURL)
+		c.logf("%v", err)
+		return nil, info, false
+	}
+	c.recordPageMeta(pageURL, info)
+	return doc, info, true
+}
+
+// recordPageMeta keeps the fetch details of a page when enrich steps need them
+func (c *Crawler) recordPageMeta(pageURL string, info pageInfo) {
+	if c.pageMeta == nil {
+		return
+	}
+	c.mu.Lock()
+	c.pageMeta[pageURL] = pageMeta{finalURL: info.finalURL, status: info.status, fetchedAt: info.fetchedAt}
+	c.mu.Unlock()
+}
+
+// runPool calls fn(0..n-1) with at most MaxConcurrent calls in flight and
+// stops dispatching new work once ctx is cancelled
+func (c *Crawler) runPool(ctx context.Context, n int, fn func(i int)) {
+	// Control concurrency with a semaphore
+	semaphore := make(chan struct{}, c.config.AdvancedConfig.MaxConcurrent)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		select {
+		case semaphore <- struct{}{}: // Acquire a semaphore slot
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				wg.Done()
+				<-semaphore // Release the semaphore slot
+			}()
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// addItem passes an item found on pageURL through the PostProcess pipeline
+// and saves it. It reports false when the item is filtered out, a duplicate
+// or cannot be saved.
+func (c *Crawler) addItem(pageURL string, item CrawlItem) bool {
+	if len(c.pipeline) > 0 {
+		var meta *pageMeta
+		c.mu.Lock()
+		if m, ok := c.pageMeta[pageURL]; ok {
+			meta = &m
+		}
+		c.mu.Unlock()
+		if !runPipeline(c.pipeline, &item, meta) {
+			c.mu.Lock()
+			c.summary.ItemsFiltered++
+			c.mu.Unlock()
+			c.logf("Filtered out item from %s: %s", pageURL, item.Title)
+			return false
+		}
+	}
+	return c.saveItem(pageURL, item)
+}
+
+// saveItem writes an item to the sink and emits it as an event. It reports
+// false when the item is a duplicate or cannot be saved.
+func (c *Crawler) saveItem(pageURL string, item CrawlItem) bool {
+	key := c.itemKey(&item)
+	c.mu.Lock()
+	if key != "" && c.itemKeys[key] {
+		c.summary.ItemsDuplicate++
+		c.mu.Unlock()
+		c.logf("Skipped duplicate item from %s: %s", pageURL, item.Title)
+		return false
+	}
+	err := c.sink.Write(item)
+	if err == nil {
+		c.summary.Items++
+		if key != "" {
+			c.itemKeys[key] = true
+		}
+		c.recordItem(pageURL, item)
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.logf("Error saving item %s: %v", item.URL, err)
+		return false
+	}
+	c.events <- Event{Type: EventItem, Time: time.Now(), Item: &item}
+	return true
+}
+
+func (c *Crawler) logf(format string, args ...interface{}) {
+	c.events <- Event{Type: EventLog, Time: time.Now(), Message: fmt.Sprintf(format, args...)}
+}
+
+func (c *Crawler) progress(value float64) {
+	c.events <- Event{Type: EventProgress, Time: time.Now(), Progress: value}
+}
+
+// outputName describes where results are written, without MongoDB credentials
+func (c *Crawler) outputName() string {
+	switch c.config.OutputType() {
+	case OutputMongoDB:
+		return "MongoDB"
+	case OutputSQLite:
+		return c.config.OutputTarget() + " (SQLite)"
+	}
+	return c.config.OutputTarget()
+}
+
+func newItem(pageURL string) CrawlItem {
+	return CrawlItem{
+		URL:        pageURL,
+		Timestamp:  time.Now(),
+		Attributes: make(map[string]string),
+		Content:    make([]string, 0),
+		Links:      make([]string, 0),
+	}
+}
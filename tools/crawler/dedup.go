@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// DedupConfig drops items already saved by this crawl. Keys name the values
+// that identify an item: "url", "link" (the canonical form of the first link
+// of a listing item, whose url is the listing page), "title", "description",
+// "attributes.<name>" or "data.<JSONPath>" into the Schema values. Items with the same values
+// for every key are duplicates and only the first one is saved; items with
+// none of the keys are always saved.
+type DedupConfig struct {
+	Keys []string `json:"Keys,omitempty"`
+}
+
+// dedupKey reads one key value from an item
+type dedupKey func(item *CrawlItem) string
+
+// compileDedupKeys parses DedupConfig.Keys
+func compileDedupKeys(config DedupConfig) ([]dedupKey, error) {
+	var keys []dedupKey
+	for i, key := range config.Keys {
+		name, rest, _ := strings.Cut(key, ".")
+		switch strings.ToLower(name) {
+		case "url":
+			keys = append(keys, func(item *CrawlItem) string { return item.URL })
+		case "link":
+			keys = append(keys, func(item *CrawlItem) string {
+				if len(item.Links) == 0 {
+					return ""
+				}
+				if u, ok := resolveURL(item.URL, item.Links[0]); ok {
+					return u.String()
+				}
+				return item.Links[0]
+			})
+		case "title":
+			keys = append(keys, func(item *CrawlItem) string { return item.Title })
+		case "description":
+			keys = append(keys, func(item *CrawlItem) string { return item.Description })
+		case "attributes":
+			if rest == "" {
+				return nil, fmt.Errorf("Dedup.Keys[%d]: attribute name is missing", i)
+			}
+			keys = append(keys, func(item *CrawlItem) string { return item.Attributes[rest] })
+		case "data":
+			path, err := CompileJSONPath(rest)
+			if err != nil {
+				return nil, fmt.Errorf("Dedup.Keys[%d]: %v", i, err)
+			}
+			keys = append(keys, func(item *CrawlItem) string {
+				v, ok := path.First(item.Data)
+				if !ok || v == nil {
+					return ""
+				}
+				s, _ := jsonText(v)
+				return s
+			})
+		default:
+			return nil, fmt.Errorf("Dedup.Keys[%d]: unknown key %q; expected url, link, title, description, attributes.<name> or data.<path>", i, key)
+		}
+	}
+	return keys, nil
+}
+
+// itemKey returns a hash of the dedup key values of item, or "" when dedup
+// is off or the item has none of the keys
+func (c *Crawler) itemKey(item *CrawlItem) string {
+	if len(c.dedupKeys) == 0 {
+		return ""
+	}
+
+	h := fnv.New64a()
+	found := false
+	for _, key := range c.dedupKeys {
+		v := strings.TrimSpace(key(item))
+		found = found || v != ""
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	if !found {
+		return ""
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
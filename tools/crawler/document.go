@@ -0,0 +1,544 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Kinds of fetched documents
+const (
+	docHTML    = "html"
+	docJSON    = "json"
+	docFeed    = "feed"
+	docSitemap = "sitemap"
+)
+
+// maxSitemapSize limits decompressed sitemaps, which may hold 50,000 URLs
+const maxSitemapSize = 64 << 20
+
+// document is a parsed response. Exactly one of html, json, feed or sitemap
+// is set, according to kind, except that a JSON document read from the JSON
+// embedded in an HTML page keeps the page in html.
+type document struct {
+	kind    string
+	html    *html.Node
+	json    interface{}
+	feed    []feedEntry
+	sitemap *sitemap
+}
+
+// sitemap holds the page URLs of a urlset or the sitemaps of a sitemap index
+type sitemap struct {
+	pages    []string
+	sitemaps []string
+}
+
+// feedEntry is one RSS item or Atom entry
+type feedEntry struct {
+	title       string
+	link        string
+	description string
+	content     string
+	published   string
+	author      string
+	id          string
+	categories  []string
+}
+
+// parseDocument parses body according to the response's Content-Type. JSON
+// and XML types are recognized, as well as bodies that look like JSON or XML
+// when the type is missing or generic. XML other than sitemaps and RSS/Atom
+// feeds, and everything else, is parsed as HTML.
+func parseDocument(contentType string, body []byte) (*document, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(body)
+
+	if mediaType == "application/gzip" || mediaType == "application/x-gzip" || bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		// Compressed sitemaps (sitemap.xml.gz)
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing body: %v", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(zr, maxSitemapSize))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing body: %v", err)
+		}
+		return parseDocument("application/xml", data)
+	}
+
+	isJSON := mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+	isXML := mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+	generic := mediaType == "" || mediaType == "text/plain" || mediaType == "application/octet-stream"
+	if generic && len(trimmed) > 0 {
+		isJSON = trimmed[0] == '{' || trimmed[0] == '['
+		isXML = bytes.HasPrefix(trimmed, []byte("<?xml"))
+	}
+
+	switch {
+	case isJSON:
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("error parsing JSON: %v", err)
+		}
+		return &document{kind: docJSON, json: v}, nil
+
+	case isXML:
+		doc, err := parseXMLDocument(body)
+		if doc != nil || err != nil {
+			return doc, err
+		}
+	}
+
+	node, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing HTML: %v", err)
+	}
+	return &document{kind: docHTML, html: node}, nil
+}
+
+// parseXMLDocument parses sitemaps and feeds; it returns nil for other XML
+func parseXMLDocument(body []byte) (*document, error) {
+	root, err := xmlRoot(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing XML: %v", err)
+	}
+
+	switch root {
+	case "urlset", "sitemapindex":
+		var sm struct {
+			URLs     []string `xml:"url>loc"`
+			Sitemaps []string `xml:"sitemap>loc"`
+		}
+		if err := xml.Unmarshal(body, &sm); err != nil {
+			return nil, fmt.Errorf("error parsing sitemap: %v", err)
+		}
+		return &document{kind: docSitemap, sitemap: &sitemap{pages: trimAll(sm.URLs), sitemaps: trimAll(sm.Sitemaps)}}, nil
+
+	case "rss", "RDF":
+		var feed struct {
+			Items    []rssItem `xml:"channel>item"`
+			RDFItems []rssItem `xml:"item"`
+		}
+		if err := xml.Unmarshal(body, &feed); err != nil {
+			return nil, fmt.Errorf("error parsing RSS feed: %v", err)
+		}
+		var entries []feedEntry
+		for _, item := range append(feed.Items, feed.RDFItems...) {
+			entries = append(entries, item.entry())
+		}
+		return &document{kind: docFeed, feed: entries}, nil
+
+	case "feed":
+		var feed struct {
+			Entries []atomEntry `xml:"entry"`
+		}
+		if err := xml.Unmarshal(body, &feed); err != nil {
+			return nil, fmt.Errorf("error parsing Atom feed: %v", err)
+		}
+		var entries []feedEntry
+		for _, entry := range feed.Entries {
+			entries = append(entries, entry.entry())
+		}
+		return &document{kind: docFeed, feed: entries}, nil
+	}
+	return nil, nil
+}
+
+// xmlRoot returns the local name of the document element
+func xmlRoot(body []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func trimAll(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// rssItem is an RSS 2.0 or RSS 1.0 item
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	GUID        string   `xml:"guid"`
+	Categories  []string `xml:"category"`
+}
+
+func (i rssItem) entry() feedEntry {
+	e := feedEntry{
+		title:       i.Title,
+		link:        i.Link,
+		description: i.Description,
+		content:     i.Content,
+		published:   firstNonEmpty(i.PubDate, i.Date),
+		author:      firstNonEmpty(i.Author, i.Creator),
+		id:          i.GUID,
+		categories:  i.Categories,
+	}
+	if e.link == "" && strings.HasPrefix(i.GUID, "http") {
+		e.link = i.GUID
+	}
+	return e
+}
+
+// atomEntry is an Atom entry
+type atomEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	ID        string `xml:"id"`
+	Authors   []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+}
+
+func (a atomEntry) entry() feedEntry {
+	e := feedEntry{
+		title:       a.Title,
+		description: a.Summary,
+		content:     a.Content,
+		published:   firstNonEmpty(a.Published, a.Updated),
+		id:          a.ID,
+	}
+	for _, l := range a.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			e.link = l.Href
+			break
+		}
+	}
+	var authors []string
+	for _, author := range a.Authors {
+		authors = append(authors, author.Name)
+	}
+	e.author = strings.Join(authors, ", ")
+	for _, c := range a.Categories {
+		e.categories = append(e.categories, c.Term)
+	}
+	return e
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// htmlText returns the text of an HTML fragment, as found in feed descriptions
+func htmlText(s string) string {
+	if !strings.ContainsAny(s, "<&") {
+		return strings.TrimSpace(s)
+	}
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	return strings.TrimSpace(whitespace.ReplaceAllString(TextContent(doc), " "))
+}
+
+// feedTime parses the publication date of a feed entry
+func feedTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// JSONConfig tells the crawler how to read JSON responses. ItemsPath selects
+// the items of a listing response (default: the elements of a top-level
+// array, or the whole document). URLPath and TitlePath are read from each
+// item, or from the document of a detail response, for the item's URL and
+// Title; on the listing pages of a two-phase or FollowLinks crawl URLPath
+// gives the links to follow. Schema fields read JSON values through Path.
+// Embedded reads HTML pages as the JSON embedded in them (see embeddedJSON)
+// for sites that render from a __NEXT_DATA__ or window.__INITIAL_STATE__
+// blob; items without a TitlePath then take the page title.
+type JSONConfig struct {
+	ItemsPath string `json:"ItemsPath,omitempty"`
+	URLPath   string `json:"URLPath,omitempty"`
+	TitlePath string `json:"TitlePath,omitempty"`
+	Embedded  bool   `json:"Embedded,omitempty"`
+}
+
+// jsonPaths is a compiled JSONConfig; unset paths are nil
+type jsonPaths struct {
+	items *JSONPath
+	url   *JSONPath
+	title *JSONPath
+}
+
+// compileJSONConfig compiles the paths of a JSONConfig
+func compileJSONConfig(config JSONConfig) (*jsonPaths, error) {
+	var p jsonPaths
+	for _, f := range []struct {
+		name string
+		expr string
+		dst  **JSONPath
+	}{
+		{"JSON.ItemsPath", config.ItemsPath, &p.items},
+		{"JSON.URLPath", config.URLPath, &p.url},
+		{"JSON.TitlePath", config.TitlePath, &p.title},
+	} {
+		if f.expr == "" {
+			continue
+		}
+		path, err := CompileJSONPath(f.expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.name, err)
+		}
+		*f.dst = path
+	}
+	return &p, nil
+}
+
+// jsonItems returns the items of a JSON listing response
+func (c *Crawler) jsonItems(doc *document) []interface{} {
+	if c.json.items != nil {
+		return c.json.items.Eval(doc.json)
+	}
+	if list, ok := doc.json.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{doc.json}
+}
+
+// jsonString returns the text at path in v, if any
+func jsonString(path *JSONPath, v interface{}) string {
+	if path == nil {
+		return ""
+	}
+	value, ok := path.First(v)
+	if !ok {
+		return ""
+	}
+	s, _ := jsonText(value)
+	return strings.TrimSpace(s)
+}
+
+// jsonItem builds the item for a JSON value found on pageURL
+func (c *Crawler) jsonItem(pageURL string, v interface{}) (CrawlItem, bool) {
+	item := newItem(pageURL)
+	if u := jsonString(c.json.url, v); u != "" {
+		if ref, ok := resolveURL(pageURL, u); ok {
+			item.URL = ref.String()
+		}
+	}
+	item.Title = jsonString(c.json.title, v)
+	applyFilters(c.filters, &item)
+	return item, c.applyJSONSchema(&item, v)
+}
+
+// jsonDetail builds the item of a JSON detail page
+func (c *Crawler) jsonDetail(pageURL string, page *document) (CrawlItem, bool) {
+	item, ok := c.jsonItem(pageURL, page.json)
+	if item.Title == "" && page.html != nil {
+		item.Title = findTitle(page.html)
+	}
+	return item, ok
+}
+
+// extractJSONItems adds one item per JSON.ItemsPath match and returns the
+// number of matches
+func (c *Crawler) extractJSONItems(pageURL string, doc *document) int {
+	items := c.jsonItems(doc)
+	for _, v := range items {
+		if item, ok := c.jsonItem(pageURL, v); ok {
+			if c.addItem(pageURL, item) {
+				c.logf("Found item: %s", item.Title)
+			}
+		}
+	}
+	c.logf("Successfully processed page %s, found %d JSON items", pageURL, len(items))
+	return len(items)
+}
+
+// applyJSONSchema is applySchema for a value of a JSON response
+func (c *Crawler) applyJSONSchema(item *CrawlItem, v interface{}) bool {
+	if len(c.schema) == 0 {
+		return true
+	}
+
+	base, _ := url.Parse(item.URL)
+	data, err := extractJSONObject(c.schema, v, base)
+	if err != nil {
+		c.mu.Lock()
+		c.summary.ItemsRejected++
+		c.mu.Unlock()
+		c.logf("Rejected item from %s: %v", item.URL, err)
+		return false
+	}
+	item.Data = data
+	return true
+}
+
+// listingLinks returns the detail links of a listing page: the
+// AttributeSelector of every Selector match, or JSON.URLPath of every JSON
+// item. matches is the number of Selector matches or JSON items.
+func (c *Crawler) listingLinks(pageURL string, doc *document) (links []*url.URL, matches int) {
+	switch doc.kind {
+	case docHTML:
+		nodes := c.matchItems(doc)
+		return resolveLinks(pageURL, nodes, c.config.AttributeSelector), len(nodes)
+	case docJSON:
+		items := c.jsonItems(doc)
+		for _, v := range items {
+			if u, ok := resolveURL(pageURL, jsonString(c.json.url, v)); ok {
+				links = append(links, u)
+			}
+		}
+		return links, len(items)
+	}
+	return nil, 0
+}
+
+// pageLinks returns the links to follow from a page: LinkConfig.Selector
+// matches on HTML pages, JSON.URLPath of each item in JSON responses
+func (c *Crawler) pageLinks(pageURL string, doc *document) []*url.URL {
+	switch doc.kind {
+	case docHTML:
+		return resolveLinks(pageURL, c.linkSelector.MatchAll(doc.html), "href")
+	case docJSON:
+		links, _ := c.listingLinks(pageURL, doc)
+		return links
+	}
+	return nil
+}
+
+// matchItems returns the Selector matches of an HTML page
+func (c *Crawler) matchItems(doc *document) []*html.Node {
+	if c.selector == nil || doc.html == nil {
+		return nil
+	}
+	return c.selector.MatchAll(doc.html)
+}
+
+// extractFeed adds one item per feed entry and returns the number of entries
+func (c *Crawler) extractFeed(pageURL string, doc *document) int {
+	for _, e := range doc.feed {
+		item := newItem(pageURL)
+		if u, ok := resolveURL(pageURL, e.link); ok {
+			item.URL = u.String()
+		}
+		item.Title = htmlText(e.title)
+		item.Description = htmlText(e.description)
+		if content := htmlText(e.content); content != "" {
+			item.Content = append(item.Content, content)
+		}
+		if e.link != "" {
+			item.Links = append(item.Links, item.URL)
+		}
+		if e.published != "" {
+			if t, ok := feedTime(e.published); ok {
+				item.Attributes["published"] = t.Format(time.RFC3339)
+			} else {
+				item.Attributes["published"] = e.published
+			}
+		}
+		if e.author != "" {
+			item.Attributes["author"] = e.author
+		}
+		if e.id != "" {
+			item.Attributes["id"] = e.id
+		}
+		if len(e.categories) > 0 {
+			item.Attributes["categories"] = strings.Join(trimAll(e.categories), ", ")
+		}
+		applyFilters(c.filters, &item)
+
+		if c.addItem(pageURL, item) {
+			c.logf("Found entry: %s", item.Title)
+		}
+	}
+	c.logf("Successfully processed feed %s, found %d entries", pageURL, len(doc.feed))
+	return len(doc.feed)
+}
+
+// addSitemap adds the URLs of a sitemap found at depth to the frontier and
+// returns how many there were. Nested sitemaps of an index are crawled at
+// the same level as the index. Pages are crawled as listing pages, or as
+// detail pages in a two-phase crawl.
+func (c *Crawler) addSitemap(pageURL string, doc *document, depth int) int {
+	sm := doc.sitemap
+	level := depth
+	if depth > 0 {
+		level = depth + 1
+	}
+
+	added := 0
+	for _, raw := range sm.sitemaps {
+		if u, ok := resolveURL(pageURL, raw); ok && c.enqueue(u.String(), level) {
+			added++
+		}
+	}
+
+	for _, raw := range sm.pages {
+		u, ok := resolveURL(pageURL, raw)
+		if !ok {
+			continue
+		}
+		switch {
+		case c.config.TwoPhaseCrawl && !c.config.FollowLinks:
+			if c.phase == 1 && c.markSeen(u.String()) {
+				c.mu.Lock()
+				c.links = append(c.links, u.String())
+				c.mu.Unlock()
+				added++
+			}
+		case c.config.TwoPhaseCrawl && level == 0:
+			if c.enqueue(u.String(), 1) {
+				added++
+			}
+		default:
+			if c.enqueue(u.String(), level) {
+				added++
+			}
+		}
+	}
+
+	c.logf("Sitemap %s: %d pages, %d sitemaps, %d new URLs", pageURL, len(sm.pages), len(sm.sitemaps), added)
+	return len(sm.pages) + len(sm.sitemaps)
+}
@@ -0,0 +1,200 @@
+package crawler
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// scriptAssignment matches the start of a global assigned in an inline
+// script, e.g. window.__INITIAL_STATE__ = {, window["state"] = [ or
+// var data = JSON.parse(
+var scriptAssignment = regexp.MustCompile(`(?:window\.|window\[["']|self\.|globalThis\.|\bvar\s+|\blet\s+|\bconst\s+)([A-Za-z_$][\w$]*)["']?\]?\s*=\s*(JSON\.parse\(\s*|[{\[])`)
+
+// embeddedJSON collects the JSON embedded in n and its descendants, so
+// schema Paths can read data that pages render with JavaScript:
+//
+//   - <script type="application/json" id="__NEXT_DATA__"> is stored under its
+//     id; JSON scripts without an id are listed under their subtype, e.g.
+//     all application/ld+json scripts under "ld+json"
+//   - globals assigned in inline scripts, such as window.__INITIAL_STATE__ =
+//     {...} or var data = JSON.parse('...'), are stored under their name
+//   - data-* attributes holding a JSON object or array are listed under the
+//     attribute name, e.g. $['data-props'][0].price
+//
+// The first value wins when an id or global appears twice. Script content
+// that is not valid JSON, such as object literals with unquoted keys, is
+// skipped.
+func embeddedJSON(n *html.Node) map[string]interface{} {
+	data := make(map[string]interface{})
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "script" {
+				addScriptJSON(data, n)
+			}
+			for _, attr := range n.Attr {
+				if !strings.HasPrefix(attr.Key, "data-") {
+					continue
+				}
+				value := strings.TrimSpace(attr.Val)
+				if !strings.HasPrefix(value, "{") && !strings.HasPrefix(value, "[") {
+					continue
+				}
+				if v, ok := decodeJSONPrefix(value); ok {
+					list, _ := data[attr.Key].([]interface{})
+					data[attr.Key] = append(list, v)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return data
+}
+
+// addScriptJSON adds the JSON of one script element to data
+func addScriptJSON(data map[string]interface{}, n *html.Node) {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	src := text.String()
+
+	mediaType := strings.ToLower(strings.TrimSpace(getAttr(n, "type")))
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		v, ok := decodeJSONPrefix(strings.TrimSpace(src))
+		if !ok {
+			return
+		}
+		if id := getAttr(n, "id"); id != "" {
+			if _, exists := data[id]; !exists {
+				data[id] = v
+			}
+			return
+		}
+		key := mediaType[strings.LastIndex(mediaType, "/")+1:]
+		list, _ := data[key].([]interface{})
+		data[key] = append(list, v)
+		return
+	}
+	if mediaType != "" && !strings.Contains(mediaType, "javascript") && mediaType != "module" {
+		return
+	}
+
+	for _, m := range scriptAssignment.FindAllStringSubmatchIndex(src, -1) {
+		name := src[m[2]:m[3]]
+		if _, exists := data[name]; exists {
+			continue
+		}
+
+		var v interface{}
+		var ok bool
+		if strings.HasPrefix(src[m[4]:m[5]], "JSON.parse") {
+			var s string
+			if s, ok = jsStringPrefix(src[m[5]:]); ok {
+				v, ok = decodeJSONPrefix(s)
+			}
+		} else {
+			// The match ends with the opening { or [ of the value
+			v, ok = decodeJSONPrefix(src[m[5]-1:])
+		}
+		if ok {
+			data[name] = v
+		}
+	}
+}
+
+// decodeJSONPrefix decodes the JSON value at the start of s, ignoring
+// whatever follows it (e.g. the rest of a script)
+func decodeJSONPrefix(s string) (interface{}, bool) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// jsStringPrefix decodes the JavaScript string literal at the start of s
+func jsStringPrefix(s string) (string, bool) {
+	if s == "" || (s[0] != '"' && s[0] != '\'' && s[0] != '`') {
+		return "", false
+	}
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return b.String(), true
+		case c != '\\':
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			return "", false
+		}
+		switch e := s[i]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'x', 'u':
+			size := 2
+			if e == 'u' {
+				size = 4
+			}
+			if i+size >= len(s) {
+				return "", false
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", false
+			}
+			i += size
+			decoded := rune(r)
+			// Characters outside the BMP are written as a \uD8xx\uDCxx pair
+			if utf16.IsSurrogate(decoded) && strings.HasPrefix(s[i+1:], "\\u") && i+6 < len(s) {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 32); err == nil {
+					if pair := utf16.DecodeRune(decoded, rune(low)); pair != utf8.RuneError {
+						decoded = pair
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(decoded)
+		default:
+			// \" \' \\ \/ and line continuations stand for themselves
+			b.WriteByte(e)
+		}
+	}
+	return "", false
+}
+
+// embeddedDocument turns an HTML page into a JSON document holding the JSON
+// embedded in it, for JSON.Embedded. The HTML is kept for the title.
+func embeddedDocument(doc *document) *document {
+	if doc == nil || doc.kind != docHTML {
+		return doc
+	}
+	return &document{kind: docJSON, json: embeddedJSON(doc.html), html: doc.html}
+}
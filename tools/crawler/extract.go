@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// extractContent appends the text of every node under n matching selector
+func extractContent(n *html.Node, selector *Selector, item *CrawlItem) {
+	for _, node := range selector.MatchAll(n) {
+		content := strings.TrimSpace(TextContent(node))
+		if content != "" {
+			item.Content = append(item.Content, content)
+		}
+	}
+}
+
+// applyFilters removes every match of the custom filter patterns from item content
+func applyFilters(filters []*regexp.Regexp, item *CrawlItem) {
+	for _, re := range filters {
+		for i, content := range item.Content {
+			if re.MatchString(content) {
+				item.Content[i] = re.ReplaceAllString(content, "")
+			}
+		}
+	}
+}
+
+// findTitle returns the trimmed text of the document's <title> element
+func findTitle(doc *html.Node) string {
+	var title string
+	var f func(*html.Node) bool
+	f = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "title" {
+			title = TextContent(n)
+			return true
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if f(c) {
+				return true
+			}
+		}
+		return false
+	}
+	f(doc)
+	return strings.TrimSpace(title)
+}
+
+// TextContent extracts all text content from a node and its children
+func TextContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(TextContent(c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,240 @@
+package crawler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// fetcher downloads and parses pages with retries and per-host rate limits
+type fetcher struct {
+	session       *session
+	maxRetries    int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	limiter       *hostLimiter
+	robots        *robotsCache
+	metrics       *metricsRecorder
+	embedded      bool
+	logf          func(format string, args ...interface{})
+}
+
+// statusError is returned for non-200 responses
+type statusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status code %d", e.code)
+}
+
+// pageInfo describes a fetched page for change detection
+type pageInfo struct {
+	hash         string
+	etag         string
+	lastModified string
+	// unmodified is set when the server answered a conditional GET with 304
+	unmodified bool
+	// finalURL (after redirects), status and fetchedAt feed enrich steps
+	finalURL  string
+	status    int
+	fetchedAt time.Time
+}
+
+// notModified reports whether the page is the same as in prev
+func (i pageInfo) notModified(prev *PageState) bool {
+	return i.unmodified || (i.hash != "" && i.hash == prev.Hash)
+}
+
+// retryable reports whether a request that failed with err is worth retrying
+// and counts against the host's circuit breaker
+func retryable(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return true // network and parse errors
+	}
+	return se.code == http.StatusRequestTimeout || se.code == http.StatusTooManyRequests || se.code >= 500
+}
+
+func newFetcher(config FileConfig, logf func(string, ...interface{})) (*fetcher, error) {
+	f := &fetcher{
+		maxRetries:    config.AdvancedConfig.MaxRetries,
+		retryDelay:    config.RetryDelayDuration(),
+		maxRetryDelay: time.Duration(config.AdvancedConfig.MaxRetryDelay) * time.Second,
+		limiter:       newHostLimiter(config.AdvancedConfig, config.RateLimitDuration(), logf),
+		metrics:       newMetricsRecorder(),
+		embedded:      config.JSON.Embedded,
+		logf:          logf,
+	}
+	if f.maxRetries < 1 {
+		f.maxRetries = 1
+	}
+	if f.maxRetryDelay <= 0 {
+		f.maxRetryDelay = defaultMaxRetryDelay
+	}
+	var err error
+	if f.session, err = newSession(config, robotsUserAgent(config.Robots)); err != nil {
+		return nil, err
+	}
+	f.robots = newRobotsCache(config.Robots, f.session.client, logf)
+	return f, nil
+}
+
+// fetch downloads rawURL and parses it by content type, retrying up to maxRetries times
+// with exponential backoff. URLs disallowed by robots.txt fail with ErrBlockedByRobots.
+// With prev the request is conditional, and a 304 answer returns no document.
+func (f *fetcher) fetch(ctx context.Context, rawURL string, prev *PageState) (*document, pageInfo, error) {
+	var lastErr error
+	var retryAfter time.Duration
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, pageInfo{}, fmt.Errorf("invalid URL %s: %v", rawURL, err)
+	}
+	host := parsed.Host
+
+	for attempt := 0; attempt < f.maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := backoff(f.retryDelay, f.maxRetryDelay, attempt)
+			if retryAfter > delay {
+				delay = retryAfter
+			}
+			f.metrics.retry()
+			f.logf("Retrying %s in %s (attempt %d/%d)...", rawURL, delay.Round(time.Millisecond), attempt+1, f.maxRetries)
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, pageInfo{}, err
+			}
+		}
+
+		if err := f.limiter.wait(ctx, host); err != nil {
+			return nil, pageInfo{}, err
+		}
+
+		// A robots.txt that cannot be fetched is retried like the page
+		err := f.robots.check(ctx, parsed)
+		if err == ErrBlockedByRobots {
+			return nil, pageInfo{}, blockedError(rawURL)
+		}
+		if err == nil {
+			var doc *document
+			var info pageInfo
+			doc, info, err = f.fetchOnce(ctx, rawURL, prev)
+			if err == nil {
+				f.limiter.success(host)
+				return doc, info, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, pageInfo{}, ctx.Err()
+		}
+
+		lastErr = err
+		f.logf("Error fetching %s (attempt %d/%d): %v", rawURL, attempt+1, f.maxRetries, err)
+
+		if !retryable(err) {
+			break
+		}
+		f.limiter.failure(host)
+
+		// Honor Retry-After for the whole host, not just this URL
+		retryAfter = 0
+		var se *statusError
+		if errors.As(err, &se) && se.retryAfter > 0 {
+			retryAfter = se.retryAfter
+			f.limiter.pause(host, time.Now().Add(retryAfter))
+		}
+	}
+
+	return nil, pageInfo{}, fmt.Errorf("failed to fetch %s: %v", rawURL, lastErr)
+}
+
+func (f *fetcher) fetchOnce(ctx context.Context, rawURL string, prev *PageState) (*document, pageInfo, error) {
+	req, err := f.session.newRequest(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, pageInfo{}, err
+	}
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	start := time.Now()
+	resp, err := f.session.client.Do(req)
+	if err != nil {
+		f.metrics.networkError(req.URL.Host)
+		return nil, pageInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		f.metrics.response(req.URL.Host, resp.StatusCode, 0, time.Since(start))
+		return nil, pageInfo{etag: prev.ETag, lastModified: prev.LastModified, unmodified: true, finalURL: resp.Request.URL.String(), status: resp.StatusCode, fetchedAt: start}, nil
+	}
+
+	// Check for non-successful status code
+	if resp.StatusCode != http.StatusOK {
+		f.metrics.response(req.URL.Host, resp.StatusCode, 0, time.Since(start))
+		se := &statusError{code: resp.StatusCode}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			se.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		return nil, pageInfo{}, se
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	f.metrics.response(req.URL.Host, resp.StatusCode, int64(len(body)), time.Since(start))
+	if err != nil {
+		return nil, pageInfo{}, fmt.Errorf("error reading body: %v", err)
+	}
+	sum := sha256.Sum256(body)
+	info := pageInfo{
+		hash:         hex.EncodeToString(sum[:]),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		finalURL:     resp.Request.URL.String(),
+		status:       resp.StatusCode,
+		fetchedAt:    start,
+	}
+
+	doc, err := f.parse(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, pageInfo{}, err
+	}
+	return doc, info, nil
+}
+
+// parse is parseDocument, reading HTML pages as their embedded JSON when
+// JSON.Embedded is set
+func (f *fetcher) parse(contentType string, body []byte) (*document, error) {
+	doc, err := parseDocument(contentType, body)
+	if err != nil || !f.embedded {
+		return doc, err
+	}
+	return embeddedDocument(doc), nil
+}
+
+// sleepContext sleeps for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,189 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Fixture is a saved page and what a config is expected to produce from it,
+// used to check a config against a site's markup without fetching it
+type Fixture struct {
+	// File is the saved page, relative to the fixtures file
+	File string `json:"File"`
+	// URL is the address the page was saved from
+	URL string `json:"URL"`
+	// Detail treats the page as a detail page of a two-phase crawl
+	Detail bool `json:"Detail,omitempty"`
+	// Links are the links the crawl would follow from the page
+	Links []string `json:"Links,omitempty"`
+	// Records are the records (see FileConfig.Record) of the page's items;
+	// item timestamps are left zero
+	Records []json.RawMessage `json:"Records,omitempty"`
+}
+
+// LoadFixtures reads a fixtures file, a JSON list of fixtures
+func LoadFixtures(path string) ([]Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixtures: %v", err)
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(StripJSONComments(data), &fixtures); err != nil {
+		return nil, fmt.Errorf("error parsing fixtures: %v", err)
+	}
+	return fixtures, nil
+}
+
+// SaveFixtures writes fixtures to path
+func SaveFixtures(path string, fixtures []Fixture) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(fixtures); err != nil {
+		return fmt.Errorf("error marshalling fixtures: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing fixtures: %v", err)
+	}
+	return nil
+}
+
+// RunFixture applies config to the saved page of f, found relative to dir,
+// and returns f with the links and records the config produced
+func RunFixture(ctx context.Context, config FileConfig, dir string, f Fixture) (Fixture, error) {
+	c, err := New(config)
+	if err != nil {
+		return f, err
+	}
+	file := f.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	report, err := c.Preview(ctx, file, PreviewOptions{Detail: f.Detail, PageURL: f.URL})
+	if err != nil {
+		return f, err
+	}
+
+	got := f
+	got.Links = report.Links
+	got.Records = nil
+	for _, item := range report.Items {
+		item.Timestamp = time.Time{}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(config.Record(item)); err != nil {
+			return f, fmt.Errorf("error marshalling record: %v", err)
+		}
+		got.Records = append(got.Records, json.RawMessage(bytes.TrimSpace(buf.Bytes())))
+	}
+	return got, nil
+}
+
+// DiffFixture lists the differences between the expected fixture want and
+// the result got of RunFixture
+func DiffFixture(want, got Fixture) []string {
+	var diffs []string
+	if !slices.Equal(want.Links, got.Links) {
+		diffs = append(diffs, diffStrings("Links", want.Links, got.Links)...)
+	}
+
+	if len(want.Records) != len(got.Records) {
+		diffs = append(diffs, fmt.Sprintf("Records: got %d, want %d", len(got.Records), len(want.Records)))
+	}
+	for i := 0; i < len(want.Records) && i < len(got.Records); i++ {
+		var w, g interface{}
+		if err := json.Unmarshal(want.Records[i], &w); err != nil {
+			diffs = append(diffs, fmt.Sprintf("Records[%d]: invalid expected record: %v", i, err))
+			continue
+		}
+		json.Unmarshal(got.Records[i], &g)
+		diffs = append(diffs, diffValues(fmt.Sprintf("Records[%d]", i), w, g)...)
+	}
+	return diffs
+}
+
+// diffStrings reports the strings missing from and added to a list
+func diffStrings(path string, want, got []string) []string {
+	count := make(map[string]int)
+	for _, s := range got {
+		count[s]++
+	}
+	for _, s := range want {
+		count[s]--
+	}
+	var diffs []string
+	for s, n := range count {
+		switch {
+		case n > 0:
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected %q", path, s))
+		case n < 0:
+			diffs = append(diffs, fmt.Sprintf("%s: missing %q", path, s))
+		}
+	}
+	sort.Strings(diffs)
+	if len(diffs) == 0 {
+		diffs = append(diffs, fmt.Sprintf("%s: same links in a different order", path))
+	}
+	return diffs
+}
+
+// diffValues reports where two decoded JSON values differ, descending into
+// objects so each differing member is reported on its own
+func diffValues(path string, want, got interface{}) []string {
+	if reflect.DeepEqual(want, got) {
+		return nil
+	}
+	w, wok := want.(map[string]interface{})
+	g, gok := got.(map[string]interface{})
+	if !wok || !gok {
+		return []string{fmt.Sprintf("%s: got %s, want %s", path, compactJSON(got), compactJSON(want))}
+	}
+
+	keys := make(map[string]bool)
+	for k := range w {
+		keys[k] = true
+	}
+	for k := range g {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var diffs []string
+	for _, k := range names {
+		wv, inWant := w[k]
+		gv, inGot := g[k]
+		switch {
+		case !inWant:
+			diffs = append(diffs, fmt.Sprintf("%s.%s: unexpected %s", path, k, compactJSON(gv)))
+		case !inGot:
+			diffs = append(diffs, fmt.Sprintf("%s.%s: missing, want %s", path, k, compactJSON(wv)))
+		default:
+			diffs = append(diffs, diffValues(path+"."+k, wv, gv)...)
+		}
+	}
+	return diffs
+}
+
+func compactJSON(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(bytes.TrimSpace(buf.Bytes()))
+}
@@ -0,0 +1,295 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// IncrementalConfig enables change detection between runs of the same crawl.
+// Detail pages (and the last level of a FollowLinks crawl) that are unchanged
+// since the previous run are not extracted again; their previous items are
+// written instead. Listing pages are always crawled so new links are found.
+type IncrementalConfig struct {
+	Enabled bool `json:"Enabled"`
+	// StateFile keeps the hash, validators and items of every page (default OutputFile + ".state")
+	StateFile string `json:"StateFile,omitempty"`
+	// DiffFile receives the items added, removed and modified since the previous run (default OutputFile + ".diff.json")
+	DiffFile string `json:"DiffFile,omitempty"`
+}
+
+// StatePath returns Incremental.StateFile, defaulting to OutputFile + ".state"
+func (c FileConfig) StatePath() string {
+	if c.Incremental.StateFile != "" {
+		return c.Incremental.StateFile
+	}
+	return c.OutputFile + ".state"
+}
+
+// DiffPath returns Incremental.DiffFile, defaulting to OutputFile + ".diff.json"
+func (c FileConfig) DiffPath() string {
+	if c.Incremental.DiffFile != "" {
+		return c.Incremental.DiffFile
+	}
+	return c.OutputFile + ".diff.json"
+}
+
+// PageState is what the previous run knew about one page
+type PageState struct {
+	Hash         string      `json:"hash,omitempty"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"lastModified,omitempty"`
+	CheckedAt    time.Time   `json:"checkedAt"`
+	Items        []CrawlItem `json:"items,omitempty"`
+}
+
+// CrawlState is the incremental state file written after each completed run
+type CrawlState struct {
+	BaseURL string                `json:"baseUrl"`
+	SavedAt time.Time             `json:"savedAt"`
+	Pages   map[string]*PageState `json:"pages"`
+}
+
+// ItemChange is a modified item in a DiffReport
+type ItemChange struct {
+	URL    string    `json:"url"`
+	Before CrawlItem `json:"before"`
+	After  CrawlItem `json:"after"`
+}
+
+// DiffReport lists the items that changed since the previous run
+type DiffReport struct {
+	GeneratedAt time.Time    `json:"generatedAt"`
+	PreviousRun time.Time    `json:"previousRun"`
+	Added       []CrawlItem  `json:"added"`
+	Removed     []CrawlItem  `json:"removed"`
+	Modified    []ItemChange `json:"modified"`
+	Unchanged   int          `json:"unchanged"`
+}
+
+// LoadState reads an incremental state file
+func LoadState(path string) (*CrawlState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state: %v", err)
+	}
+
+	var state CrawlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing state: %v", err)
+	}
+	if state.Pages == nil {
+		state.Pages = make(map[string]*PageState)
+	}
+	return &state, nil
+}
+
+// writeJSONFile writes v as indented JSON, atomically replacing path
+func writeJSONFile(path string, v interface{}) error {
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating directory: %v", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling data to JSON: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing JSON to file: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadPreviousState reads the state of the previous run, if any
+func (c *Crawler) loadPreviousState() {
+	if c.state == nil {
+		c.state = make(map[string]*PageState)
+	}
+	c.prevState = &CrawlState{Pages: make(map[string]*PageState)}
+
+	if _, err := os.Stat(c.config.StatePath()); os.IsNotExist(err) {
+		c.logf("No previous state found, every page will be crawled")
+		return
+	}
+	prev, err := LoadState(c.config.StatePath())
+	if err != nil {
+		c.logf("Ignoring incremental state: %v", err)
+		return
+	}
+	c.prevState = prev
+	c.logf("Loaded state of %d pages from the run of %s", len(prev.Pages), prev.SavedAt.Format(time.RFC1123))
+}
+
+// fetchContent fetches a page whose items can be reused when it is unchanged
+// since the previous run. It returns false when the caller has nothing more
+// to do: the page failed, or it was unchanged and its items were written.
+func (c *Crawler) fetchContent(ctx context.Context, pageURL string) (*document, bool) {
+	if !c.config.Incremental.Enabled {
+		return c.fetchPage(ctx, pageURL)
+	}
+
+	c.mu.Lock()
+	prev := c.prevState.Pages[pageURL]
+	c.mu.Unlock()
+
+	doc, info, ok := c.fetchPageInfo(ctx, pageURL, prev)
+	if !ok {
+		return nil, false
+	}
+
+	if prev != nil && info.notModified(prev) {
+		c.mu.Lock()
+		c.summary.PagesUnchanged++
+		c.state[pageURL] = &PageState{Hash: prev.Hash, ETag: prev.ETag, LastModified: prev.LastModified, CheckedAt: time.Now()}
+		c.mu.Unlock()
+
+		// The items were post-processed when they were first found
+		c.completePage(pageURL, func() {
+			for _, item := range prev.Items {
+				c.saveItem(pageURL, item)
+			}
+		})
+		c.logf("Unchanged: %s", pageURL)
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.state[pageURL] = &PageState{Hash: info.hash, ETag: info.etag, LastModified: info.lastModified, CheckedAt: time.Now()}
+	c.mu.Unlock()
+	return doc, true
+}
+
+// recordItem keeps item in the new state under the page it was found on.
+// c.mu must be held.
+func (c *Crawler) recordItem(pageURL string, item CrawlItem) {
+	if c.state == nil {
+		return
+	}
+	page, ok := c.state[pageURL]
+	if !ok {
+		page = &PageState{CheckedAt: time.Now()}
+		c.state[pageURL] = page
+	}
+	page.Items = append(page.Items, item)
+}
+
+// keepPreviousState carries the previous state of a page that could not be
+// crawled this time, so its items are not reported as removed
+func (c *Crawler) keepPreviousState(pageURL string) {
+	if c.state == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.prevState.Pages[pageURL]; ok {
+		if _, done := c.state[pageURL]; !done {
+			c.state[pageURL] = prev
+		}
+	}
+}
+
+// finishIncremental writes the diff against the previous run and replaces
+// the state file
+func (c *Crawler) finishIncremental() {
+	c.mu.Lock()
+	state := &CrawlState{BaseURL: c.config.BaseURL, SavedAt: time.Now(), Pages: c.state}
+	prev := c.prevState
+	c.mu.Unlock()
+
+	report := diffStates(prev, state)
+	if err := writeJSONFile(c.config.DiffPath(), report); err != nil {
+		c.logf("Error saving diff report: %v", err)
+	} else {
+		c.logf("Changes since last run: %d added, %d removed, %d modified, %d unchanged (see %s)",
+			len(report.Added), len(report.Removed), len(report.Modified), report.Unchanged, c.config.DiffPath())
+	}
+
+	if err := writeJSONFile(c.config.StatePath(), state); err != nil {
+		c.logf("Error saving incremental state: %v", err)
+	}
+}
+
+// diffStates compares the items of two runs. Pages with exactly one item on
+// both sides are matched by URL, others by URL and title.
+func diffStates(prev, cur *CrawlState) DiffReport {
+	report := DiffReport{
+		GeneratedAt: cur.SavedAt,
+		PreviousRun: prev.SavedAt,
+		Added:       make([]CrawlItem, 0),
+		Removed:     make([]CrawlItem, 0),
+		Modified:    make([]ItemChange, 0),
+	}
+
+	urls := make(map[string]bool)
+	for u := range prev.Pages {
+		urls[u] = true
+	}
+	for u := range cur.Pages {
+		urls[u] = true
+	}
+	sorted := make([]string, 0, len(urls))
+	for u := range urls {
+		sorted = append(sorted, u)
+	}
+	sort.Strings(sorted)
+
+	for _, u := range sorted {
+		var before, after []CrawlItem
+		if p := prev.Pages[u]; p != nil {
+			before = p.Items
+		}
+		if p := cur.Pages[u]; p != nil {
+			after = p.Items
+		}
+
+		if len(before) == 1 && len(after) == 1 {
+			report.compare(u, before[0], after[0])
+			continue
+		}
+
+		old := make(map[string]CrawlItem)
+		for _, item := range before {
+			old[item.Title] = item
+		}
+		for _, item := range after {
+			if b, ok := old[item.Title]; ok {
+				report.compare(u, b, item)
+				delete(old, item.Title)
+			} else {
+				report.Added = append(report.Added, item)
+			}
+		}
+		for _, item := range before {
+			if _, ok := old[item.Title]; ok {
+				report.Removed = append(report.Removed, item)
+			}
+		}
+	}
+	return report
+}
+
+// compare records a matched pair of items as modified or unchanged
+func (r *DiffReport) compare(u string, before, after CrawlItem) {
+	if sameItem(before, after) {
+		r.Unchanged++
+	} else {
+		r.Modified = append(r.Modified, ItemChange{URL: u, Before: before, After: after})
+	}
+}
+
+// sameItem compares two items ignoring when they were crawled
+func sameItem(a, b CrawlItem) bool {
+	a.Timestamp, b.Timestamp = time.Time{}, time.Time{}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -0,0 +1,517 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a crawl config run on a cron schedule by the scheduler daemon
+type Job struct {
+	Name string `json:"Name"`
+	// Config is the crawl config file; a relative path is relative to the jobs file,
+	// and relative output paths in the config are relative to the config file
+	Config string `json:"Config"`
+	// Schedule is a cron expression, see ParseSchedule
+	Schedule string `json:"Schedule"`
+	// Resume continues from the checkpoint left by an interrupted run
+	Resume   bool `json:"Resume,omitempty"`
+	Disabled bool `json:"Disabled,omitempty"`
+}
+
+// JobsFile is the list of jobs run by the scheduler daemon
+type JobsFile struct {
+	// HistoryFile receives one JSON line per run (default history.jsonl next to the jobs file)
+	HistoryFile string `json:"HistoryFile,omitempty"`
+	Jobs        []Job  `json:"Jobs"`
+
+	path string
+}
+
+// RunRecord is one run of a job in the run history
+type RunRecord struct {
+	ID             string    `json:"id"`
+	Job            string    `json:"job"`
+	Config         string    `json:"config"`
+	Trigger        string    `json:"trigger"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	PagesSucceeded int       `json:"pagesSucceeded"`
+	PagesFailed    int       `json:"pagesFailed"`
+	Items          int       `json:"items"`
+	ItemsRejected  int       `json:"itemsRejected,omitempty"`
+	ItemsDuplicate int       `json:"itemsDuplicate,omitempty"`
+	ItemsFiltered  int       `json:"itemsFiltered,omitempty"`
+	Output         string    `json:"output,omitempty"`
+	Report         string    `json:"report,omitempty"`
+}
+
+// DefaultJobsPath returns the jobs file used when none is given
+func DefaultJobsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".crawler", "jobs.json"), nil
+}
+
+// LoadJobs reads and checks a jobs file. A missing file has no jobs.
+func LoadJobs(path string) (*JobsFile, error) {
+	jobs := &JobsFile{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return jobs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading jobs file: %v", err)
+	}
+	if err := json.Unmarshal(StripJSONComments(data), jobs); err != nil {
+		return nil, fmt.Errorf("error parsing jobs file: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, job := range jobs.Jobs {
+		if err := job.check(); err != nil {
+			return nil, fmt.Errorf("%s: Jobs[%d]: %v", path, i, err)
+		}
+		if seen[strings.ToLower(job.Name)] {
+			return nil, fmt.Errorf("%s: Jobs[%d]: duplicate job name %q", path, i, job.Name)
+		}
+		seen[strings.ToLower(job.Name)] = true
+	}
+	return jobs, nil
+}
+
+// check validates the settings of a job, but not its crawl config
+func (j Job) check() error {
+	if strings.TrimSpace(j.Name) == "" {
+		return fmt.Errorf("Name is required")
+	}
+	if j.Config == "" {
+		return fmt.Errorf("Config is required")
+	}
+	if _, err := ParseSchedule(j.Schedule); err != nil {
+		return fmt.Errorf("Schedule: %v", err)
+	}
+	return nil
+}
+
+// Save writes the jobs file
+func (f *JobsFile) Save() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return fmt.Errorf("error marshalling jobs: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing jobs file: %v", err)
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// Find returns the job named name (case-insensitive)
+func (f *JobsFile) Find(name string) (Job, bool) {
+	for _, job := range f.Jobs {
+		if strings.EqualFold(job.Name, name) {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Put adds job, replacing a job of the same name
+func (f *JobsFile) Put(job Job) error {
+	if err := job.check(); err != nil {
+		return err
+	}
+	for i := range f.Jobs {
+		if strings.EqualFold(f.Jobs[i].Name, job.Name) {
+			f.Jobs[i] = job
+			return nil
+		}
+	}
+	f.Jobs = append(f.Jobs, job)
+	return nil
+}
+
+// Remove deletes the job named name, reporting whether it existed
+func (f *JobsFile) Remove(name string) bool {
+	for i := range f.Jobs {
+		if strings.EqualFold(f.Jobs[i].Name, name) {
+			f.Jobs = append(f.Jobs[:i], f.Jobs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryPath returns HistoryFile, defaulting to history.jsonl next to the jobs file
+func (f *JobsFile) HistoryPath() string {
+	if f.HistoryFile == "" {
+		return filepath.Join(filepath.Dir(f.path), "history.jsonl")
+	}
+	return f.resolve(f.HistoryFile)
+}
+
+// ConfigPath returns the crawl config file of job
+func (f *JobsFile) ConfigPath(job Job) string {
+	return f.resolve(job.Config)
+}
+
+// resolve makes a path relative to the jobs file absolute
+func (f *JobsFile) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(f.path), path)
+}
+
+// RunJob runs job once and appends the run to the history. Log messages of
+// the crawl are passed to logf, which may be nil.
+func (f *JobsFile) RunJob(ctx context.Context, job Job, trigger string, logf func(string)) RunRecord {
+	record := RunRecord{
+		Job:       job.Name,
+		Config:    f.ConfigPath(job),
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+	slug := strings.TrimSuffix(templateFileName(job.Name), ".json")
+	// Milliseconds keep a manual run apart from a scheduled one
+	record.ID = slug + "-" + record.StartedAt.Format("20060102-150405.000")
+
+	err := runJobCrawl(ctx, record.Config, job.Resume, &record, logf)
+	record.FinishedAt = time.Now()
+	record.Status = runStatus(err, record.PagesSucceeded, record.PagesFailed)
+	if record.Status == "failed" || err != nil {
+		record.Error = runError(err, record.PagesFailed)
+	}
+
+	if err := AppendHistory(f.HistoryPath(), record); err != nil && logf != nil {
+		logf(err.Error())
+	}
+	return record
+}
+
+// loadJobConfig loads the crawl config of a job. The daemon may run in any
+// directory, so relative output paths are made relative to the config file.
+func loadJobConfig(configPath string) (FileConfig, error) {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return config, err
+	}
+	dir := filepath.Dir(configPath)
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	resolve(&config.OutputFile)
+	resolve(&config.CheckpointFile)
+	resolve(&config.Incremental.StateFile)
+	resolve(&config.Incremental.DiffFile)
+	resolve(&config.Metrics.ReportFile)
+	if config.OutputType() != OutputMongoDB {
+		resolve(&config.Output.Target)
+	}
+	return config, nil
+}
+
+// jobFiles lists what a run of config writes to, for CheckOutputs
+func jobFiles(config FileConfig) []string {
+	output := config.OutputTarget()
+	if config.OutputType() == OutputMongoDB {
+		output += " " + config.Output.Database + "." + config.Output.Collection
+	} else {
+		output = filepath.Clean(output)
+	}
+	return []string{
+		output,
+		filepath.Clean(config.CheckpointPath()),
+		filepath.Clean(config.StatePath()),
+		filepath.Clean(config.DiffPath()),
+		filepath.Clean(config.ReportPath()),
+	}
+}
+
+// CheckOutputs returns an error if two jobs write the same output,
+// checkpoint, state or report file, since their runs would overwrite each
+// other. Jobs whose config cannot be loaded are left to fail when they run.
+func (f *JobsFile) CheckOutputs() error {
+	owners := make(map[string]string)
+	for _, job := range f.Jobs {
+		config, err := loadJobConfig(f.ConfigPath(job))
+		if err != nil {
+			continue
+		}
+		for _, file := range jobFiles(config) {
+			if other, ok := owners[file]; ok && other != job.Name {
+				return fmt.Errorf("jobs %q and %q both write %s", other, job.Name, file)
+			}
+			owners[file] = job.Name
+		}
+	}
+	return nil
+}
+
+// runJobCrawl runs the crawl of configPath, filling in the counters of record
+func runJobCrawl(ctx context.Context, configPath string, resume bool, record *RunRecord, logf func(string)) error {
+	config, err := loadJobConfig(configPath)
+	if err != nil {
+		return err
+	}
+	c, err := New(config)
+	if err != nil {
+		return err
+	}
+	record.Output = c.outputName()
+	record.Report = config.ReportPath()
+
+	if resume {
+		if _, statErr := os.Stat(config.CheckpointPath()); statErr == nil {
+			if err := c.Resume(); err != nil {
+				return err
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		summary, err := c.Run(ctx)
+		record.PagesSucceeded = summary.PagesSucceeded
+		record.PagesFailed = summary.PagesFailed
+		record.Items = summary.Items
+		record.ItemsRejected = summary.ItemsRejected
+		record.ItemsDuplicate = summary.ItemsDuplicate
+		record.ItemsFiltered = summary.ItemsFiltered
+		done <- err
+	}()
+	for ev := range c.Events() {
+		if ev.Type == EventLog && logf != nil {
+			logf(ev.Message)
+		}
+	}
+	return <-done
+}
+
+// AppendHistory adds record to the history file
+func AppendHistory(path string, record RunRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling run record: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening run history: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing run history: %v", err)
+	}
+	return nil
+}
+
+// LoadHistory reads the run history, oldest run first. A missing file has
+// no runs.
+func LoadHistory(path string) ([]RunRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening run history: %v", err)
+	}
+	defer file.Close()
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, fmt.Errorf("%s:%d: error parsing run record: %v", path, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("error reading run history: %v", err)
+	}
+	return records, nil
+}
+
+// Scheduler runs the jobs of a jobs file on their schedules. The jobs file
+// is read again whenever it changes, so jobs can be added while it runs.
+type Scheduler struct {
+	path string
+	logf func(string)
+
+	// Verbose passes the log messages of each crawl to logf, prefixed with the job name
+	Verbose bool
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+// NewScheduler returns a scheduler for the jobs file at path. Start, end and
+// errors of runs are reported to logf.
+func NewScheduler(path string, logf func(string)) *Scheduler {
+	return &Scheduler{path: path, logf: logf, running: make(map[string]bool)}
+}
+
+// scheduledJob is a job with the time of its next run
+type scheduledJob struct {
+	job      Job
+	schedule *Schedule
+	next     time.Time
+}
+
+// Run starts jobs when they are due until ctx is cancelled, then waits for
+// running jobs, which are cancelled and keep their checkpoints. A job that is
+// still running when it is due again is skipped.
+func (s *Scheduler) Run(ctx context.Context) error {
+	jobs, err := LoadJobs(s.path)
+	if err != nil {
+		return err
+	}
+	if err := jobs.CheckOutputs(); err != nil {
+		return err
+	}
+	modTime := fileModTime(s.path)
+	scheduled := s.schedule(jobs, nil)
+
+	for {
+		wait := time.Minute
+		for _, sj := range scheduled {
+			if d := time.Until(sj.next); d < wait {
+				wait = d
+			}
+		}
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.logf("Stopping; waiting for running jobs...")
+			s.wg.Wait()
+			return nil
+		case <-timer.C:
+		}
+
+		if mt := fileModTime(s.path); !mt.Equal(modTime) {
+			reloaded, err := LoadJobs(s.path)
+			if err == nil {
+				err = reloaded.CheckOutputs()
+			}
+			if err != nil {
+				s.logf(fmt.Sprintf("Error reloading jobs, keeping the previous ones: %v", err))
+			} else {
+				s.logf(fmt.Sprintf("Reloaded %s", s.path))
+				jobs, scheduled = reloaded, s.schedule(reloaded, scheduled)
+			}
+			modTime = mt
+		}
+
+		now := time.Now()
+		for _, sj := range scheduled {
+			if sj.next.IsZero() || sj.next.After(now) {
+				continue
+			}
+			sj.next = sj.schedule.Next(now)
+			s.start(ctx, jobs, sj.job)
+		}
+	}
+}
+
+// schedule computes the next run of each enabled job, keeping the next run
+// of jobs whose schedule didn't change since previous
+func (s *Scheduler) schedule(jobs *JobsFile, previous []*scheduledJob) []*scheduledJob {
+	var scheduled []*scheduledJob
+	now := time.Now()
+	for _, job := range jobs.Jobs {
+		if job.Disabled {
+			continue
+		}
+		schedule, _ := ParseSchedule(job.Schedule) // checked by LoadJobs
+		sj := &scheduledJob{job: job, schedule: schedule, next: schedule.Next(now)}
+		kept := false
+		for _, p := range previous {
+			if p.job.Name == job.Name && p.job.Schedule == job.Schedule {
+				sj.next, kept = p.next, true
+			}
+		}
+		switch {
+		case sj.next.IsZero():
+			s.logf(fmt.Sprintf("Job %s: schedule %q never runs", job.Name, job.Schedule))
+		case !kept:
+			s.logf(fmt.Sprintf("Job %s: next run at %s", job.Name, sj.next.Format(time.DateTime)))
+		}
+		scheduled = append(scheduled, sj)
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].job.Name < scheduled[j].job.Name })
+	return scheduled
+}
+
+// start runs job in the background unless it is already running
+func (s *Scheduler) start(ctx context.Context, jobs *JobsFile, job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(job.Name)
+	if s.running[key] {
+		s.logf(fmt.Sprintf("Job %s: skipped, the previous run is still going", job.Name))
+		return
+	}
+	s.running[key] = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+		}()
+
+		s.logf(fmt.Sprintf("Job %s: started", job.Name))
+		var logf func(string)
+		if s.Verbose {
+			logf = func(msg string) { s.logf(fmt.Sprintf("[%s] %s", job.Name, msg)) }
+		}
+		record := jobs.RunJob(ctx, job, "schedule", logf)
+		msg := fmt.Sprintf("Job %s: %s in %s, %d items", job.Name, record.Status,
+			record.FinishedAt.Sub(record.StartedAt).Round(time.Second), record.Items)
+		if record.Error != "" {
+			msg += ": " + record.Error
+		}
+		s.logf(msg)
+	}()
+}
+
+// fileModTime returns the modification time of path, or the zero time
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,218 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a compiled JSONPath-style expression. Supported syntax:
+//
+//	$            the value the path is evaluated against (optional prefix)
+//	.name        object member (also ['name'] or ["name"])
+//	[0], [-1]    array element, negative indexes count from the end
+//	[*], .*      every member or element
+//	..name       name at any depth below (also ..*)
+//
+// A path without a leading $ or . starts with a member name, so "data.items"
+// is the same as "$.data.items".
+type JSONPath struct {
+	expr  string
+	steps []pathStep
+}
+
+// pathStep is one segment of a JSONPath
+type pathStep struct {
+	name      string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+// CompileJSONPath parses a JSONPath expression
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	p := &JSONPath{expr: expr}
+	s := strings.TrimSpace(expr)
+	if strings.HasPrefix(s, "$") {
+		s = s[1:]
+	} else if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+
+	for len(s) > 0 {
+		var step pathStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case s[0] == '.':
+			if !step.recursive {
+				s = s[1:]
+			}
+			if strings.HasPrefix(s, "*") {
+				step.wildcard = true
+				s = s[1:]
+				p.steps = append(p.steps, step)
+				continue
+			}
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: empty member name", expr)
+			}
+			step.name = s[:end]
+			s = s[end:]
+			p.steps = append(p.steps, step)
+			continue
+		}
+
+		if len(s) == 0 || s[0] != '[' {
+			return nil, fmt.Errorf("invalid JSONPath %q: unexpected %q", expr, s)
+		}
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid JSONPath %q: missing ]", expr)
+		}
+		inner := strings.TrimSpace(s[1:end])
+		s = s[end+1:]
+
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			step.name = inner[1 : len(inner)-1]
+		default:
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSONPath %q: bad index %q", expr, inner)
+			}
+			step.index = i
+			step.isIndex = true
+		}
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+// String returns the source expression
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Eval returns every value the path selects in v
+func (p *JSONPath) Eval(v interface{}) []interface{} {
+	current := []interface{}{v}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, c := range current {
+			if step.recursive {
+				walkJSON(c, func(d interface{}) {
+					next = step.apply(d, next)
+				})
+			} else {
+				next = step.apply(c, next)
+			}
+		}
+		current = next
+		if len(current) == 0 {
+			break
+		}
+	}
+	return current
+}
+
+// First returns the first value the path selects in v
+func (p *JSONPath) First(v interface{}) (interface{}, bool) {
+	values := p.Eval(v)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+// apply appends the children of v selected by the step to out
+func (s pathStep) apply(v interface{}, out []interface{}) []interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			// Object members in key order so results are stable
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				out = append(out, t[k])
+			}
+		} else if !s.isIndex {
+			if child, ok := t[s.name]; ok {
+				out = append(out, child)
+			}
+		}
+	case []interface{}:
+		switch {
+		case s.wildcard:
+			out = append(out, t...)
+		case s.isIndex:
+			i := s.index
+			if i < 0 {
+				i += len(t)
+			}
+			if i >= 0 && i < len(t) {
+				out = append(out, t[i])
+			}
+		}
+	}
+	return out
+}
+
+// walkJSON calls fn on v and every value nested inside it
+func walkJSON(v interface{}, fn func(interface{})) {
+	fn(v)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkJSON(t[k], fn)
+		}
+	case []interface{}:
+		for _, child := range t {
+			walkJSON(child, fn)
+		}
+	}
+}
+
+// jsonText returns a JSON value as text: strings as they are, numbers and
+// booleans formatted, objects and arrays encoded. ok is false for null.
+func jsonText(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
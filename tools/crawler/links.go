@@ -0,0 +1,328 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+)
+
+// Link following scopes for LinkConfig.Scope
+const (
+	ScopeSameDomain = "same-domain"
+	ScopeAllowlist  = "allowlist"
+	ScopeAny        = "any"
+)
+
+const defaultLinkSelector = "a[href]"
+
+// linkScope decides which discovered links are followed
+type linkScope struct {
+	scope   string
+	domains []string
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newLinkScope(config FileConfig) (*linkScope, error) {
+	links := config.LinkConfig
+	s := &linkScope{scope: links.Scope}
+	if s.scope == "" {
+		s.scope = ScopeSameDomain
+	}
+
+	switch s.scope {
+	case ScopeSameDomain:
+		seed, err := url.Parse(config.StartURLs()[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid BaseURL: %v", err)
+		}
+		s.domains = []string{strings.TrimPrefix(strings.ToLower(seed.Hostname()), "www.")}
+	case ScopeAllowlist:
+		for _, d := range links.AllowedDomains {
+			s.domains = append(s.domains, strings.TrimPrefix(strings.ToLower(d), "www."))
+		}
+	}
+
+	for _, pattern := range links.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %v", pattern, err)
+		}
+		s.include = append(s.include, re)
+	}
+	for _, pattern := range links.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %v", pattern, err)
+		}
+		s.exclude = append(s.exclude, re)
+	}
+	return s, nil
+}
+
+// allowed reports whether the normalized URL u may be followed
+func (s *linkScope) allowed(u *url.URL) bool {
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	switch s.scope {
+	case ScopeSameDomain:
+		if host != s.domains[0] {
+			return false
+		}
+	case ScopeAllowlist:
+		ok := false
+		for _, d := range s.domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	raw := u.String()
+	if len(s.include) > 0 {
+		ok := false
+		for _, re := range s.include {
+			if re.MatchString(raw) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, re := range s.exclude {
+		if re.MatchString(raw) {
+			return false
+		}
+	}
+	return true
+}
+
+// trackingParams are query parameters that only serve analytics and never
+// change the page; parameters starting with utm_ are dropped as well
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "gbraid": true, "wbraid": true,
+	"msclkid": true, "yclid": true, "twclid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "_ga": true, "_gl": true,
+	"_hsenc": true, "_hsmi": true, "mkt_tok": true,
+}
+
+// normalizeURL resolves href against base and returns it in a canonical form:
+// lower-case scheme and host, no default port, no dot segments, no fragment,
+// no tracking parameters and the remaining query parameters sorted by name,
+// so the same page found through different links is only crawled once.
+// Non-HTTP links such as mailto: and javascript: are rejected.
+func normalizeURL(base *url.URL, href string) (*url.URL, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return nil, false
+	}
+
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, false
+	}
+	u := ref
+	if base != nil {
+		u = base.ResolveReference(ref)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, false
+	}
+
+	host := strings.ToLower(u.Host)
+	if u.Scheme == "http" {
+		host = strings.TrimSuffix(host, ":80")
+	} else {
+		host = strings.TrimSuffix(host, ":443")
+	}
+	u.Host = host
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.RawQuery = canonicalQuery(u.RawQuery)
+	u.ForceQuery = false
+	return u, true
+}
+
+// canonicalQuery drops tracking parameters from a raw query and sorts the
+// rest by name. Values are kept as written; repeated parameters keep their
+// order.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	type param struct {
+		name, raw string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		lower := strings.ToLower(name)
+		if trackingParams[lower] || strings.HasPrefix(lower, "utm_") {
+			continue
+		}
+		params = append(params, param{name, raw})
+	}
+	sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+	return strings.Join(raws, "&")
+}
+
+// resolveURL is normalizeURL against the URL of a page
+func resolveURL(pageURL, href string) (*url.URL, bool) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, false
+	}
+	return normalizeURL(base, href)
+}
+
+// resolveLinks returns the normalized values of attr on nodes, resolved
+// against pageURL
+func resolveLinks(pageURL string, nodes []*html.Node, attr string) []*url.URL {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+
+	var links []*url.URL
+	for _, n := range nodes {
+		if u, ok := normalizeURL(base, getAttr(n, attr)); ok {
+			links = append(links, u)
+		}
+	}
+	return links
+}
+
+// enqueue adds u to the frontier at depth unless it has been seen before
+func (c *Crawler) enqueue(u string, depth int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Seen covers both the frontier and the links of a two-phase crawl
+	if c.seen[u] {
+		return false
+	}
+	c.seen[u] = true
+	c.frontier = append(c.frontier, u)
+	c.depths[u] = depth
+	return true
+}
+
+// level returns the unvisited frontier URLs at depth
+func (c *Crawler) level(depth int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var urls []string
+	for _, u := range c.frontier {
+		if !c.visited[u] && c.depths[u] == depth {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// followLinksCrawl crawls breadth-first from the listing pages, one depth
+// level at a time, following in-scope links until MaxDepth. In two-phase
+// mode the listing pages only contribute their Selector links and items are
+// extracted from the detail pages below them.
+func (c *Crawler) followLinksCrawl(ctx context.Context) {
+	maxDepth := c.config.AdvancedConfig.MaxDepth
+	if c.config.TwoPhaseCrawl && maxDepth < 1 {
+		maxDepth = 1
+	}
+
+	for depth := 0; depth <= maxDepth && ctx.Err() == nil; depth++ {
+		handle := func(pageURL string, doc *document) int {
+			if c.config.TwoPhaseCrawl && depth == 0 && (doc.kind == docHTML || doc.kind == docJSON) {
+				links, matches := c.listingLinks(pageURL, doc)
+				c.followLinks(pageURL, links, depth+1, false)
+				return matches
+			}
+
+			found := 1
+			if c.config.TwoPhaseCrawl {
+				c.addDetail(pageURL, doc, depth)
+			} else {
+				found = c.extractItems(ctx, pageURL, doc, depth)
+			}
+			if depth < maxDepth {
+				c.followLinks(pageURL, c.pageLinks(pageURL, doc), depth+1, true)
+			}
+			return found
+		}
+
+		// Listing pages may be paginated one after another
+		if depth == 0 {
+			c.logf("\nDepth 0: crawling listing pages...")
+			c.crawlListing(ctx, 1/float64(maxDepth+1), handle)
+			continue
+		}
+
+		pages := c.level(depth)
+		if len(pages) == 0 {
+			continue
+		}
+		c.logf("\nDepth %d: crawling %d pages...", depth, len(pages))
+		var done int32
+
+		// Leaf pages yield items but no links, so they can be skipped when unchanged
+		fetch := c.fetchPage
+		if depth == maxDepth {
+			fetch = c.fetchContent
+		}
+
+		c.runPool(ctx, len(pages), func(i int) {
+			doc, ok := fetch(ctx, pages[i])
+			if !ok {
+				return
+			}
+			c.completePage(pages[i], func() { handle(pages[i], doc) })
+			c.progress((float64(depth) + float64(atomic.AddInt32(&done, 1))/float64(len(pages))) / float64(maxDepth+1))
+		})
+	}
+}
+
+// followLinks enqueues the links found on pageURL at depth. Links picked by
+// the listing Selector are trusted; others must pass the link scope.
+func (c *Crawler) followLinks(pageURL string, links []*url.URL, depth int, scoped bool) {
+	added := 0
+	for _, u := range links {
+		if scoped && !c.scope.allowed(u) {
+			continue
+		}
+		if c.enqueue(u.String(), depth) {
+			added++
+		}
+	}
+	if added > 0 {
+		c.logf("Found %d new links on page %s", added, pageURL)
+	}
+}
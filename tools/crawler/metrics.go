@@ -0,0 +1,375 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many recent requests per host the percentiles cover
+const latencyWindow = 1024
+
+// MetricsConfig controls live metrics and the run report
+type MetricsConfig struct {
+	// Listen is a local address (e.g. "127.0.0.1:9090") serving /metrics in
+	// Prometheus text format and /metrics.json while the crawl runs; empty
+	// disables the endpoint
+	Listen string `json:"Listen,omitempty"`
+	// ReportFile receives the final metrics (default OutputFile + ".report.json")
+	ReportFile string `json:"ReportFile,omitempty"`
+}
+
+// ReportPath returns Metrics.ReportFile, defaulting to OutputFile + ".report.json"
+func (c FileConfig) ReportPath() string {
+	if c.Metrics.ReportFile != "" {
+		return c.Metrics.ReportFile
+	}
+	return c.OutputFile + ".report.json"
+}
+
+// HostMetrics are the request counts and latency percentiles of one host
+type HostMetrics struct {
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	P50      float64 `json:"latencyP50Ms"`
+	P90      float64 `json:"latencyP90Ms"`
+	P99      float64 `json:"latencyP99Ms"`
+	Max      float64 `json:"latencyMaxMs"`
+}
+
+// Metrics is a snapshot of a crawl's counters. Requests counts every HTTP
+// response, including retries and errors; the Pages counters count pages once.
+type Metrics struct {
+	StartedAt      time.Time              `json:"startedAt"`
+	Elapsed        float64                `json:"elapsedSeconds"`
+	Requests       int64                  `json:"requests"`
+	Bytes          int64                  `json:"bytes"`
+	Retries        int64                  `json:"retries"`
+	NetworkErrors  int64                  `json:"networkErrors"`
+	StatusCodes    map[string]int64       `json:"statusCodes"`
+	PagesSucceeded int                    `json:"pagesSucceeded"`
+	PagesFailed    int                    `json:"pagesFailed"`
+	PagesBlocked   int                    `json:"pagesBlocked"`
+	PagesUnchanged int                    `json:"pagesUnchanged"`
+	Items          int                    `json:"items"`
+	ItemsRejected  int                    `json:"itemsRejected"`
+	ItemsDuplicate int                    `json:"itemsDuplicate"`
+	ItemsFiltered  int                    `json:"itemsFiltered"`
+	QueueDepth     int                    `json:"queueDepth"`
+	Hosts          map[string]HostMetrics `json:"hosts"`
+}
+
+// RunReport is written next to the output when a crawl ends
+type RunReport struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Output     string    `json:"output"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Metrics
+}
+
+// hostStats keeps the most recent latencies of a host in a ring buffer
+type hostStats struct {
+	requests  int64
+	errors    int64
+	latencies []time.Duration
+	next      int
+}
+
+func (h *hostStats) observe(d time.Duration) {
+	if len(h.latencies) < latencyWindow {
+		h.latencies = append(h.latencies, d)
+		return
+	}
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % latencyWindow
+}
+
+// metricsRecorder collects the fetcher's counters; it is safe for concurrent use
+type metricsRecorder struct {
+	mu            sync.Mutex
+	startedAt     time.Time
+	requests      int64
+	bytes         int64
+	retries       int64
+	networkErrors int64
+	statusCodes   map[int]int64
+	hosts         map[string]*hostStats
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{
+		startedAt:   time.Now(),
+		statusCodes: make(map[int]int64),
+		hosts:       make(map[string]*hostStats),
+	}
+}
+
+func (m *metricsRecorder) host(name string) *hostStats {
+	h, ok := m.hosts[name]
+	if !ok {
+		h = &hostStats{}
+		m.hosts[name] = h
+	}
+	return h
+}
+
+// response records an HTTP response of size bytes that took d
+func (m *metricsRecorder) response(host string, status int, size int64, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	m.bytes += size
+	m.statusCodes[status]++
+	h := m.host(host)
+	h.requests++
+	if status >= 400 {
+		h.errors++
+	}
+	h.observe(d)
+}
+
+// networkError records a request that got no response
+func (m *metricsRecorder) networkError(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.networkErrors++
+	m.host(host).errors++
+}
+
+func (m *metricsRecorder) retry() {
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
+
+// snapshot returns the fetcher's counters; the crawl counters are filled in by the caller
+func (m *metricsRecorder) snapshot() Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := Metrics{
+		StartedAt:     m.startedAt,
+		Elapsed:       time.Since(m.startedAt).Seconds(),
+		Requests:      m.requests,
+		Bytes:         m.bytes,
+		Retries:       m.retries,
+		NetworkErrors: m.networkErrors,
+		StatusCodes:   make(map[string]int64),
+		Hosts:         make(map[string]HostMetrics),
+	}
+	for code, n := range m.statusCodes {
+		s.StatusCodes[strconv.Itoa(code)] = n
+	}
+	for name, h := range m.hosts {
+		sorted := append([]time.Duration(nil), h.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.Hosts[name] = HostMetrics{
+			Requests: h.requests,
+			Errors:   h.errors,
+			P50:      percentile(sorted, 0.5),
+			P90:      percentile(sorted, 0.9),
+			P99:      percentile(sorted, 0.99),
+			Max:      percentile(sorted, 1),
+		}
+	}
+	return s
+}
+
+// percentile returns the q-th quantile of sorted latencies in milliseconds
+func percentile(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+// Metrics returns the live counters of the crawl
+func (c *Crawler) Metrics() Metrics {
+	m := c.fetcher.metrics.snapshot()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.PagesSucceeded = c.summary.PagesSucceeded
+	m.PagesFailed = c.summary.PagesFailed
+	m.PagesBlocked = c.summary.PagesBlocked
+	m.PagesUnchanged = c.summary.PagesUnchanged
+	m.Items = c.summary.Items
+	m.ItemsRejected = c.summary.ItemsRejected
+	m.ItemsDuplicate = c.summary.ItemsDuplicate
+	m.ItemsFiltered = c.summary.ItemsFiltered
+	m.QueueDepth = c.queueDepth()
+	return m
+}
+
+// queueDepth counts the URLs waiting to be crawled, including pages that
+// failed and will be retried by a resumed run. c.mu must be held.
+func (c *Crawler) queueDepth() int {
+	n := 0
+	for _, u := range c.frontier {
+		if !c.visited[u] {
+			n++
+		}
+	}
+	// Detail links found while the listing is still being crawled
+	if c.phase == 1 {
+		n += len(c.links)
+	}
+	return n
+}
+
+// serveMetrics starts the metrics endpoint; the returned function stops it
+func (c *Crawler) serveMetrics(addr string) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting metrics endpoint: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w, c.Metrics())
+	})
+	mux.HandleFunc("/metrics.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(c.Metrics())
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.logf("Metrics endpoint stopped: %v", err)
+		}
+	}()
+	c.logf("Serving metrics on http://%s/metrics", ln.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// writeReport writes the final metrics of the run to ReportPath
+func (c *Crawler) writeReport(runErr error) {
+	metrics := c.Metrics()
+	report := RunReport{
+		Status:     runStatus(runErr, metrics.PagesSucceeded, metrics.PagesFailed),
+		Output:     c.outputName(),
+		FinishedAt: time.Now(),
+		Metrics:    metrics,
+	}
+	if report.Status == "failed" {
+		report.Error = runError(runErr, metrics.PagesFailed)
+	}
+
+	if err := writeJSONFile(c.config.ReportPath(), report); err != nil {
+		c.logf("Error saving run report: %v", err)
+		return
+	}
+	c.logf("Run report saved to %s", c.config.ReportPath())
+}
+
+// runStatus describes how a run that returned err ended: completed,
+// partial when some pages failed, cancelled, or failed when it returned an
+// error or no page could be crawled
+func runStatus(err error, pagesSucceeded, pagesFailed int) string {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return "cancelled"
+	case err != nil || (pagesFailed > 0 && pagesSucceeded == 0):
+		return "failed"
+	case pagesFailed > 0:
+		return "partial"
+	}
+	return "completed"
+}
+
+// runError is the error of a failed run: err, or the failed pages when Run
+// itself succeeded
+func runError(err error, pagesFailed int) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("all %d pages failed", pagesFailed)
+}
+
+// writePrometheus writes m in the Prometheus text exposition format
+func writePrometheus(w io.Writer, m Metrics) {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("crawler_requests_total", "counter", "HTTP responses received, including retries.")
+	fmt.Fprintf(w, "crawler_requests_total %d\n", m.Requests)
+	metric("crawler_response_bytes_total", "counter", "Bytes of response bodies read.")
+	fmt.Fprintf(w, "crawler_response_bytes_total %d\n", m.Bytes)
+	metric("crawler_retries_total", "counter", "Requests retried after a failure.")
+	fmt.Fprintf(w, "crawler_retries_total %d\n", m.Retries)
+	metric("crawler_network_errors_total", "counter", "Requests that got no response.")
+	fmt.Fprintf(w, "crawler_network_errors_total %d\n", m.NetworkErrors)
+
+	metric("crawler_responses_total", "counter", "HTTP responses by status code.")
+	codes := make([]string, 0, len(m.StatusCodes))
+	for code := range m.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "crawler_responses_total{code=%q} %d\n", code, m.StatusCodes[code])
+	}
+
+	metric("crawler_pages_total", "counter", "Pages crawled by result.")
+	fmt.Fprintf(w, "crawler_pages_total{result=\"succeeded\"} %d\n", m.PagesSucceeded)
+	fmt.Fprintf(w, "crawler_pages_total{result=\"failed\"} %d\n", m.PagesFailed)
+	fmt.Fprintf(w, "crawler_pages_total{result=\"blocked\"} %d\n", m.PagesBlocked)
+	fmt.Fprintf(w, "crawler_pages_total{result=\"unchanged\"} %d\n", m.PagesUnchanged)
+	metric("crawler_items_total", "counter", "Items saved.")
+	fmt.Fprintf(w, "crawler_items_total %d\n", m.Items)
+	metric("crawler_items_rejected_total", "counter", "Items rejected by the schema.")
+	fmt.Fprintf(w, "crawler_items_rejected_total %d\n", m.ItemsRejected)
+	metric("crawler_items_duplicate_total", "counter", "Items skipped as duplicates.")
+	fmt.Fprintf(w, "crawler_items_duplicate_total %d\n", m.ItemsDuplicate)
+	metric("crawler_items_filtered_total", "counter", "Items dropped by PostProcess filters.")
+	fmt.Fprintf(w, "crawler_items_filtered_total %d\n", m.ItemsFiltered)
+	metric("crawler_queue_depth", "gauge", "URLs waiting to be crawled.")
+	fmt.Fprintf(w, "crawler_queue_depth %d\n", m.QueueDepth)
+
+	hosts := make([]string, 0, len(m.Hosts))
+	for host := range m.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	metric("crawler_host_latency_seconds", "summary", "Response latency of recent requests by host.")
+	for _, host := range hosts {
+		h := m.Hosts[host]
+		for _, q := range []struct {
+			quantile string
+			ms       float64
+		}{{"0.5", h.P50}, {"0.9", h.P90}, {"0.99", h.P99}, {"1", h.Max}} {
+			fmt.Fprintf(w, "crawler_host_latency_seconds{host=%q,quantile=%q} %g\n", host, q.quantile, q.ms/1000)
+		}
+		fmt.Fprintf(w, "crawler_host_latency_seconds_count{host=%q} %d\n", host, h.Requests)
+	}
+	metric("crawler_host_errors_total", "counter", "Failed requests by host.")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "crawler_host_errors_total{host=%q} %d\n", host, m.Hosts[host].Errors)
+	}
+	metric("crawler_elapsed_seconds", "gauge", "Time since the crawl started.")
+	fmt.Fprintf(w, "crawler_elapsed_seconds %g\n", m.Elapsed)
+}
@@ -0,0 +1,354 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Pagination modes for PaginationConfig.Mode
+const (
+	PaginateNumeric  = "numeric"
+	PaginateNextLink = "next-link"
+	PaginateCursor   = "cursor"
+	PaginateOffset   = "offset"
+)
+
+// PaginationConfig selects how listing pages are found. "numeric" (default)
+// substitutes StartPage..EndPage for PagePattern in BaseURL. The other modes
+// start at BaseURL and walk the listing one page at a time: "next-link"
+// follows NextSelector, "cursor" sets CursorParam to the value read from
+// CursorSelector (or its CursorAttribute), and "offset" adds Limit to
+// OffsetParam. They stop at the first page without Selector matches, when no
+// next page is found, or after MaxPages pages. JSON responses read the next
+// page URL from NextPath and the cursor from CursorPath instead, as do HTML
+// pages without NextSelector or CursorSelector, from their embedded JSON.
+type PaginationConfig struct {
+	Mode            string `json:"Mode,omitempty"`
+	NextSelector    string `json:"NextSelector,omitempty"`
+	NextPath        string `json:"NextPath,omitempty"`
+	CursorParam     string `json:"CursorParam,omitempty"`
+	CursorSelector  string `json:"CursorSelector,omitempty"`
+	CursorPath      string `json:"CursorPath,omitempty"`
+	CursorAttribute string `json:"CursorAttribute,omitempty"`
+	OffsetParam     string `json:"OffsetParam,omitempty"`
+	LimitParam      string `json:"LimitParam,omitempty"`
+	Limit           int    `json:"Limit,omitempty"`
+	StartOffset     int    `json:"StartOffset,omitempty"`
+	MaxPages        int    `json:"MaxPages,omitempty"`
+}
+
+// PaginationMode returns Pagination.Mode, defaulting to numeric
+func (c FileConfig) PaginationMode() string {
+	if c.Pagination.Mode == "" {
+		return PaginateNumeric
+	}
+	return c.Pagination.Mode
+}
+
+// StartURLs returns the listing pages the crawl starts from: every page of a
+// numeric range, or the first page of the sequential modes, followed by the
+// Sitemaps
+func (c FileConfig) StartURLs() []string {
+	if c.BaseURL == "" {
+		return c.Sitemaps
+	}
+	return append(c.listingURLs(), c.Sitemaps...)
+}
+
+// listingURLs returns the first listing pages of BaseURL
+func (c FileConfig) listingURLs() []string {
+	switch c.PaginationMode() {
+	case PaginateNumeric:
+		var urls []string
+		for page := c.StartPage; page <= c.EndPage; page++ {
+			urls = append(urls, c.PageURL(page))
+		}
+		return urls
+	case PaginateOffset:
+		p := c.Pagination
+		u, err := setQuery(c.BaseURL, p.OffsetParam, strconv.Itoa(p.StartOffset))
+		if err == nil && p.LimitParam != "" {
+			u, err = setQuery(u, p.LimitParam, strconv.Itoa(p.Limit))
+		}
+		if err != nil {
+			return []string{c.BaseURL}
+		}
+		return []string{u}
+	}
+	return []string{c.BaseURL}
+}
+
+// validatePagination checks the settings of the selected pagination mode
+func validatePagination(config FileConfig) error {
+	p := config.Pagination
+	if p.MaxPages < 0 {
+		return fmt.Errorf("Pagination.MaxPages must be at least 0")
+	}
+
+	switch config.PaginationMode() {
+	case PaginateNumeric:
+		if config.PagePattern == "" {
+			return fmt.Errorf("PagePattern is required")
+		}
+
+		if !strings.Contains(config.BaseURL, config.PagePattern) {
+			return fmt.Errorf("BaseURL must contain the PagePattern: %s", config.PagePattern)
+		}
+
+		if config.StartPage < 1 {
+			return fmt.Errorf("StartPage must be at least 1")
+		}
+
+		if config.EndPage < config.StartPage {
+			return fmt.Errorf("EndPage must be greater than or equal to StartPage")
+		}
+	case PaginateNextLink:
+		if p.NextSelector == "" && p.NextPath == "" {
+			return fmt.Errorf("Pagination.NextSelector or NextPath is required in %s mode", PaginateNextLink)
+		}
+	case PaginateCursor:
+		if p.CursorParam == "" || (p.CursorSelector == "" && p.CursorPath == "") {
+			return fmt.Errorf("Pagination.CursorParam and CursorSelector or CursorPath are required in %s mode", PaginateCursor)
+		}
+	case PaginateOffset:
+		if p.OffsetParam == "" {
+			return fmt.Errorf("Pagination.OffsetParam is required in %s mode", PaginateOffset)
+		}
+		if p.Limit < 1 {
+			return fmt.Errorf("Pagination.Limit must be at least 1 in %s mode", PaginateOffset)
+		}
+		if p.StartOffset < 0 {
+			return fmt.Errorf("Pagination.StartOffset must be at least 0")
+		}
+	default:
+		return fmt.Errorf("Pagination.Mode must be %q, %q, %q or %q", PaginateNumeric, PaginateNextLink, PaginateCursor, PaginateOffset)
+	}
+
+	if _, err := newPaginator(config); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(config.StartURLs()[0])
+	if err != nil {
+		return fmt.Errorf("invalid BaseURL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("BaseURL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// paginator finds the next listing page in the sequential modes
+type paginator struct {
+	config     PaginationConfig
+	mode       string
+	next       *Selector
+	cursor     *Selector
+	nextPath   *JSONPath
+	cursorPath *JSONPath
+}
+
+func newPaginator(config FileConfig) (*paginator, error) {
+	p := &paginator{config: config.Pagination, mode: config.PaginationMode()}
+
+	var err error
+	switch p.mode {
+	case PaginateNextLink:
+		if p.config.NextSelector != "" {
+			if p.next, err = CompileSelector(p.config.NextSelector); err != nil {
+				return nil, fmt.Errorf("Pagination.NextSelector: %v", err)
+			}
+		}
+		if p.config.NextPath != "" {
+			if p.nextPath, err = CompileJSONPath(p.config.NextPath); err != nil {
+				return nil, fmt.Errorf("Pagination.NextPath: %v", err)
+			}
+		}
+	case PaginateCursor:
+		if p.config.CursorSelector != "" {
+			if p.cursor, err = CompileSelector(p.config.CursorSelector); err != nil {
+				return nil, fmt.Errorf("Pagination.CursorSelector: %v", err)
+			}
+		}
+		if p.config.CursorPath != "" {
+			if p.cursorPath, err = CompileJSONPath(p.config.CursorPath); err != nil {
+				return nil, fmt.Errorf("Pagination.CursorPath: %v", err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// sequential reports whether each listing page is found from the previous one
+func (p *paginator) sequential() bool {
+	return p.mode != PaginateNumeric
+}
+
+// nextPage returns the listing page after pageURL, or false when pagination ends
+func (p *paginator) nextPage(pageURL string, doc *document) (string, bool) {
+	switch p.mode {
+	case PaginateNextLink:
+		var href string
+		switch {
+		case doc.kind == docJSON && p.nextPath != nil:
+			href = jsonString(p.nextPath, doc.json)
+		case doc.kind == docHTML && p.next == nil && p.nextPath != nil:
+			href = jsonString(p.nextPath, embeddedJSON(doc.html))
+		case doc.kind == docHTML && p.next != nil:
+			n := p.next.MatchFirst(doc.html)
+			if n == nil {
+				return "", false
+			}
+			href = getAttr(n, "href")
+		}
+		u, ok := resolveURL(pageURL, href)
+		if !ok {
+			return "", false
+		}
+		return u.String(), true
+
+	case PaginateCursor:
+		var value string
+		switch {
+		case doc.kind == docJSON && p.cursorPath != nil:
+			value = jsonString(p.cursorPath, doc.json)
+		case doc.kind == docHTML && p.cursor == nil && p.cursorPath != nil:
+			value = jsonString(p.cursorPath, embeddedJSON(doc.html))
+		case doc.kind == docHTML && p.cursor != nil:
+			n := p.cursor.MatchFirst(doc.html)
+			if n == nil {
+				return "", false
+			}
+			if p.config.CursorAttribute != "" {
+				value = getAttr(n, p.config.CursorAttribute)
+			} else {
+				value = TextContent(n)
+			}
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return "", false
+		}
+		next, err := setQuery(pageURL, p.config.CursorParam, value)
+		return next, err == nil
+
+	case PaginateOffset:
+		if doc.kind == docFeed || doc.kind == docSitemap {
+			return "", false
+		}
+		u, err := url.Parse(pageURL)
+		if err != nil {
+			return "", false
+		}
+		offset, _ := strconv.Atoi(u.Query().Get(p.config.OffsetParam))
+		next, err := setQuery(pageURL, p.config.OffsetParam, strconv.Itoa(offset+p.config.Limit))
+		return next, err == nil
+	}
+	return "", false
+}
+
+// setQuery returns rawURL with the query parameter key set to value
+func setQuery(rawURL, key, value string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// crawlListing calls fn on each listing page of the frontier and marks it
+// visited. fn returns the number of Selector matches on the page. Numeric
+// listings are crawled in parallel; the sequential modes fetch one page at a
+// time, queueing the next page before the current one is marked visited so a
+// checkpoint always knows where to continue. Listing pages added while the
+// listing is crawled (by sitemaps) are crawled in further rounds, each one
+// starting its own sequence of pages. Progress is reported up to scale.
+func (c *Crawler) crawlListing(ctx context.Context, scale float64, fn func(pageURL string, doc *document) int) {
+	attempted := make(map[string]bool)
+	for ctx.Err() == nil {
+		var pages []string
+		for _, u := range c.level(0) {
+			if !attempted[u] {
+				attempted[u] = true
+				pages = append(pages, u)
+			}
+		}
+		if len(pages) == 0 {
+			return
+		}
+
+		if !c.paginator.sequential() {
+			var done int32
+			c.runPool(ctx, len(pages), func(i int) {
+				doc, ok := c.fetchPage(ctx, pages[i])
+				if !ok {
+					return
+				}
+				c.completePage(pages[i], func() { fn(pages[i], doc) })
+				c.progress(float64(atomic.AddInt32(&done, 1)) / float64(len(pages)) * scale)
+			})
+			continue
+		}
+
+		for _, start := range pages {
+			for _, u := range c.paginate(ctx, start, scale, fn) {
+				attempted[u] = true
+			}
+		}
+	}
+}
+
+// paginate crawls the sequence of listing pages beginning at start and
+// returns the pages it attempted
+func (c *Crawler) paginate(ctx context.Context, start string, scale float64, fn func(pageURL string, doc *document) int) []string {
+	pages := []string{start}
+	maxPages := c.config.Pagination.MaxPages
+	for i := 0; i < len(pages) && ctx.Err() == nil; i++ {
+		if maxPages > 0 && i >= maxPages {
+			c.logf("Stopping pagination after %d pages (MaxPages)", maxPages)
+			return pages[:i]
+		}
+
+		pageURL := pages[i]
+		if c.isVisited(pageURL) {
+			continue
+		}
+		doc, ok := c.fetchPage(ctx, pageURL)
+		if !ok {
+			c.logf("Stopping pagination: %s could not be crawled", pageURL)
+			return pages[:i+1]
+		}
+
+		found := 0
+		c.completePage(pageURL, func() {
+			if found = fn(pageURL, doc); found == 0 {
+				return
+			}
+			if next, ok := c.paginator.nextPage(pageURL, doc); !ok {
+				if doc.kind == docHTML || doc.kind == docJSON {
+					c.logf("Pagination finished: no next page after %s", pageURL)
+				}
+			} else if c.enqueue(next, 0) {
+				pages = append(pages, next)
+			} else {
+				c.logf("Pagination finished: %s was already crawled", next)
+			}
+		})
+		if found == 0 {
+			c.logf("Stopping pagination: no results on %s", pageURL)
+			return pages[:i+1]
+		}
+
+		if maxPages > 0 {
+			c.progress(float64(i+1) / float64(maxPages) * scale)
+		}
+	}
+	return pages
+}
@@ -0,0 +1,505 @@
+package crawler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Step types for ProcessStep.Type
+const (
+	StepFilter = "filter"
+	StepRename = "rename"
+	StepSplit  = "split"
+	StepJoin   = "join"
+	StepMap    = "map"
+	StepEnrich = "enrich"
+)
+
+// defaultSplitPattern separates the elements of a split step without Pattern
+const defaultSplitPattern = `,\s*`
+
+// ProcessStep is one step of the post-processing pipeline that every item
+// passes through, in order, before dedup and output. Fields are named like
+// dedup keys: "url", "title", "description", "content", "links",
+// "attributes.<name>" or "data.<key>[.<key>...]".
+//
+//   - filter keeps the items whose Field (any element of a list) matches
+//     Pattern, or drops them with Exclude; a missing field is ""
+//   - rename moves Field to To
+//   - split cuts the text of Field into a list at every match of Pattern
+//     (default ",\s*"), trimming the elements and dropping empty ones
+//   - join joins the list Field into text with Separator (default ", ")
+//   - map replaces Field (each element of a list) by its entry in Table;
+//     values without one are kept, or replaced by Default when it is set
+//   - enrich sets To (default data.page) to the finalUrl (after redirects),
+//     HTTP status and fetchedAt time of the item's page
+//
+// Split, join and map write to To when it is set and replace Field otherwise.
+// Steps that read a missing field do nothing.
+type ProcessStep struct {
+	Type      string            `json:"Type"`
+	Field     string            `json:"Field,omitempty"`
+	To        string            `json:"To,omitempty"`
+	Pattern   string            `json:"Pattern,omitempty"`
+	Exclude   bool              `json:"Exclude,omitempty"`
+	Separator string            `json:"Separator,omitempty"`
+	Table     map[string]string `json:"Table,omitempty"`
+	Default   *string           `json:"Default,omitempty"`
+}
+
+// processStep is a compiled ProcessStep
+type processStep struct {
+	ProcessStep
+	field  itemField
+	target itemField
+	re     *regexp.Regexp
+}
+
+// pageMeta is what enrich steps know about the page of an item
+type pageMeta struct {
+	finalURL  string
+	status    int
+	fetchedAt time.Time
+}
+
+// compilePipeline compiles and validates PostProcess
+func compilePipeline(steps []ProcessStep) ([]processStep, error) {
+	var compiled []processStep
+	for i, s := range steps {
+		step, err := compileStep(s)
+		if err != nil {
+			return nil, fmt.Errorf("PostProcess[%d]: %v", i, err)
+		}
+		compiled = append(compiled, step)
+	}
+	return compiled, nil
+}
+
+func compileStep(s ProcessStep) (processStep, error) {
+	step := processStep{ProcessStep: s}
+	s.Type = strings.ToLower(s.Type)
+	step.Type = s.Type
+
+	if s.Type != StepEnrich {
+		if s.Field == "" {
+			return step, fmt.Errorf("Field is required")
+		}
+		field, err := parseItemField(s.Field)
+		if err != nil {
+			return step, fmt.Errorf("Field: %v", err)
+		}
+		step.field, step.target = field, field
+	}
+	if s.To != "" {
+		target, err := parseItemField(s.To)
+		if err != nil {
+			return step, fmt.Errorf("To: %v", err)
+		}
+		step.target = target
+	}
+
+	switch s.Type {
+	case StepFilter:
+		if s.Pattern == "" {
+			return step, fmt.Errorf("Pattern is required")
+		}
+		if s.To != "" {
+			return step, fmt.Errorf("filter steps take no To")
+		}
+	case StepRename:
+		if s.To == "" {
+			return step, fmt.Errorf("To is required")
+		}
+		if step.target == step.field {
+			return step, fmt.Errorf("To must differ from Field")
+		}
+	case StepSplit:
+		if s.Pattern == "" {
+			step.Pattern = defaultSplitPattern
+		}
+		if step.target.text() {
+			return step, fmt.Errorf("%s holds text and cannot take a list; use To", step.target)
+		}
+	case StepJoin:
+		if s.Separator == "" {
+			step.Separator = ", "
+		}
+		if step.target.list() {
+			return step, fmt.Errorf("%s holds a list and cannot take joined text; use To", step.target)
+		}
+	case StepMap:
+		if len(s.Table) == 0 {
+			return step, fmt.Errorf("Table is required")
+		}
+	case StepEnrich:
+		if s.Field != "" {
+			return step, fmt.Errorf("enrich steps take no Field")
+		}
+		if s.To == "" {
+			step.target = itemField{kind: "data", key: "page"}
+		}
+		if step.target.kind != "data" {
+			return step, fmt.Errorf("To must be a data field")
+		}
+	default:
+		return step, fmt.Errorf("Type must be %q, %q, %q, %q, %q or %q", StepFilter, StepRename, StepSplit, StepJoin, StepMap, StepEnrich)
+	}
+
+	if step.Type == StepFilter || step.Type == StepSplit {
+		re, err := regexp.Compile(step.Pattern)
+		if err != nil {
+			return step, fmt.Errorf("invalid pattern: %v", err)
+		}
+		step.re = re
+	}
+	return step, nil
+}
+
+// needsPageMeta reports whether any step enriches items with page metadata
+func needsPageMeta(steps []processStep) bool {
+	for _, s := range steps {
+		if s.Type == StepEnrich {
+			return true
+		}
+	}
+	return false
+}
+
+// runPipeline passes item through steps. It reports false when a filter
+// dropped the item. meta may be nil, e.g. outside a crawl, in which case
+// enrich steps do nothing.
+func runPipeline(steps []processStep, item *CrawlItem, meta *pageMeta) bool {
+	for _, s := range steps {
+		if s.Type == StepEnrich {
+			if meta != nil {
+				s.target.set(item, map[string]interface{}{
+					"finalUrl":  meta.finalURL,
+					"status":    int64(meta.status),
+					"fetchedAt": meta.fetchedAt,
+				})
+			}
+			continue
+		}
+
+		v, ok := s.field.get(item)
+		if s.Type == StepFilter {
+			if s.matches(v) == s.Exclude {
+				return false
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		switch s.Type {
+		case StepRename:
+			s.field.remove(item)
+			s.target.set(item, v)
+		case StepSplit:
+			var parts []interface{}
+			for _, text := range valueTexts(v) {
+				for _, part := range s.re.Split(text, -1) {
+					if part = strings.TrimSpace(part); part != "" {
+						parts = append(parts, part)
+					}
+				}
+			}
+			s.target.set(item, parts)
+		case StepJoin:
+			s.target.set(item, strings.Join(valueTexts(v), s.Separator))
+		case StepMap:
+			if list, isList := v.([]interface{}); isList {
+				mapped := make([]interface{}, len(list))
+				for i, elem := range list {
+					mapped[i] = s.lookup(elem)
+				}
+				s.target.set(item, mapped)
+			} else {
+				s.target.set(item, s.lookup(v))
+			}
+		}
+	}
+	return true
+}
+
+// matches reports whether the filter pattern matches v or one of its elements
+func (s processStep) matches(v interface{}) bool {
+	texts := valueTexts(v)
+	if len(texts) == 0 {
+		texts = []string{""}
+	}
+	for _, text := range texts {
+		if s.re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup maps one value through the table of a map step
+func (s processStep) lookup(v interface{}) interface{} {
+	if mapped, ok := s.Table[strings.TrimSpace(valueText(v))]; ok {
+		return mapped
+	}
+	if s.Default != nil {
+		return *s.Default
+	}
+	return v
+}
+
+// itemField addresses one value of a CrawlItem
+type itemField struct {
+	// kind is url, title, description, content, links, attributes or data
+	kind string
+	// key is the attribute name or the dotted data path
+	key string
+}
+
+func parseItemField(s string) (itemField, error) {
+	kind, key, _ := strings.Cut(s, ".")
+	kind = strings.ToLower(kind)
+	switch kind {
+	case "url", "title", "description", "content", "links":
+		if key != "" {
+			return itemField{}, fmt.Errorf("%s has no members", kind)
+		}
+	case "attributes", "data":
+		if key == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, "..") {
+			return itemField{}, fmt.Errorf("%q needs a name after %s.", s, kind)
+		}
+		if kind == "attributes" && strings.Contains(key, ".") {
+			return itemField{}, fmt.Errorf("attribute names cannot be nested")
+		}
+	default:
+		return itemField{}, fmt.Errorf("unknown field %q; expected url, title, description, content, links, attributes.<name> or data.<key>", s)
+	}
+	return itemField{kind: kind, key: key}, nil
+}
+
+func (f itemField) String() string {
+	if f.key == "" {
+		return f.kind
+	}
+	return f.kind + "." + f.key
+}
+
+// text reports whether the field only holds text
+func (f itemField) text() bool {
+	switch f.kind {
+	case "url", "title", "description", "attributes":
+		return true
+	}
+	return false
+}
+
+// list reports whether the field only holds a list
+func (f itemField) list() bool {
+	return f.kind == "content" || f.kind == "links"
+}
+
+// get returns the value of the field; ok is false when it is missing or empty
+func (f itemField) get(item *CrawlItem) (interface{}, bool) {
+	switch f.kind {
+	case "url":
+		return item.URL, item.URL != ""
+	case "title":
+		return item.Title, item.Title != ""
+	case "description":
+		return item.Description, item.Description != ""
+	case "content":
+		return stringsToValues(item.Content), len(item.Content) > 0
+	case "links":
+		return stringsToValues(item.Links), len(item.Links) > 0
+	case "attributes":
+		v, ok := item.Attributes[f.key]
+		return v, ok
+	}
+	v, ok := lookupPath(item.Data, f.key)
+	return v, ok && v != nil
+}
+
+// set stores v in the field, converting it to text or a list of texts for
+// the fields of those types
+func (f itemField) set(item *CrawlItem, v interface{}) {
+	switch f.kind {
+	case "url":
+		item.URL = valueText(v)
+	case "title":
+		item.Title = valueText(v)
+	case "description":
+		item.Description = valueText(v)
+	case "content":
+		item.Content = valueTexts(v)
+	case "links":
+		item.Links = valueTexts(v)
+	case "attributes":
+		if item.Attributes == nil {
+			item.Attributes = make(map[string]string)
+		}
+		item.Attributes[f.key] = valueText(v)
+	case "data":
+		if item.Data == nil {
+			item.Data = make(map[string]interface{})
+		}
+		keys := strings.Split(f.key, ".")
+		obj := item.Data
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := obj[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				obj[key] = child
+			}
+			obj = child
+		}
+		obj[keys[len(keys)-1]] = v
+	}
+}
+
+// remove clears the field
+func (f itemField) remove(item *CrawlItem) {
+	switch f.kind {
+	case "url", "title", "description", "content", "links":
+		f.set(item, nil)
+	case "attributes":
+		delete(item.Attributes, f.key)
+	case "data":
+		keys := strings.Split(f.key, ".")
+		obj := item.Data
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := obj[key].(map[string]interface{})
+			if !ok {
+				return
+			}
+			obj = child
+		}
+		delete(obj, keys[len(keys)-1])
+	}
+}
+
+func stringsToValues(list []string) []interface{} {
+	values := make([]interface{}, len(list))
+	for i, s := range list {
+		values[i] = s
+	}
+	return values
+}
+
+// valueText formats a value as text; lists are joined with ", "
+func valueText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	switch t := v.(type) {
+	case []interface{}:
+		return strings.Join(valueTexts(t), ", ")
+	case time.Time:
+		return t.Format(time.RFC3339)
+	}
+	s, _ := jsonText(v)
+	return s
+}
+
+// valueTexts formats the elements of a list, or a single value, as texts
+func valueTexts(v interface{}) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		texts := make([]string, 0, len(v))
+		for _, elem := range v {
+			texts = append(texts, valueText(elem))
+		}
+		return texts
+	}
+	return []string{valueText(v)}
+}
+
+// outputSchema returns the schema of the items after post-processing: the
+// top-level data fields renamed, added or retyped by the pipeline. Data
+// records and CSV columns follow it.
+func outputSchema(config FileConfig) []FieldConfig {
+	fields := append([]FieldConfig(nil), config.Schema...)
+	find := func(name string) int {
+		for i, f := range fields {
+			if f.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+	// put replaces the field of the same name or adds f at the end
+	put := func(f FieldConfig) {
+		if i := find(f.Name); i >= 0 {
+			fields[i] = f
+		} else {
+			fields = append(fields, f)
+		}
+	}
+	// topLevel returns the name of a top-level data field
+	topLevel := func(s string) (string, bool) {
+		f, err := parseItemField(s)
+		if err != nil || f.kind != "data" || strings.Contains(f.key, ".") {
+			return "", false
+		}
+		return f.key, true
+	}
+
+	for _, s := range config.PostProcess {
+		field, fromData := topLevel(s.Field)
+		to, toData := field, fromData
+		if s.To != "" {
+			to, toData = topLevel(s.To)
+		}
+		var source FieldConfig
+		if i := find(field); fromData && i >= 0 {
+			source = fields[i]
+		}
+
+		switch strings.ToLower(s.Type) {
+		case StepRename:
+			i := find(field)
+			switch {
+			case fromData && toData && i >= 0 && find(to) < 0:
+				fields[i].Name = to
+			case toData:
+				source.Name = to
+				if source.Type == "" {
+					source.Type = FieldString
+				}
+				put(source)
+				fallthrough
+			case fromData && i >= 0:
+				if i := find(field); i >= 0 && field != to {
+					fields = append(fields[:i], fields[i+1:]...)
+				}
+			}
+		case StepSplit:
+			if toData {
+				put(FieldConfig{Name: to, Type: FieldString, List: true})
+			}
+		case StepJoin:
+			if toData {
+				put(FieldConfig{Name: to, Type: FieldString})
+			}
+		case StepMap:
+			if toData {
+				put(FieldConfig{Name: to, Type: FieldString, List: source.List})
+			}
+		case StepEnrich:
+			if s.To == "" {
+				to, toData = "page", true
+			}
+			if toData {
+				put(FieldConfig{Name: to, Type: FieldObject, Fields: []FieldConfig{
+					{Name: "finalUrl", Type: FieldString},
+					{Name: "status", Type: FieldInt},
+					{Name: "fetchedAt", Type: FieldDate},
+				}})
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,470 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// Matches, items and links beyond previewMatches are counted but not
+// printed by WriteText, and longer texts are cut to previewText characters
+const (
+	previewMatches = 20
+	previewText    = 80
+)
+
+// PreviewMatch is one element (or JSON value) matched by a selector
+type PreviewMatch struct {
+	Element string `json:"element"`
+	Text    string `json:"text,omitempty"`
+	Value   string `json:"value,omitempty"`
+}
+
+// SelectorPreview lists what one selector of the config matched
+type SelectorPreview struct {
+	// Setting is the config path of the selector, e.g. TwoPhaseCrawlConfig.Attributes[0]
+	Setting  string         `json:"setting"`
+	Selector string         `json:"selector"`
+	Matches  []PreviewMatch `json:"matches"`
+	// FirstOnly is set for selectors that only use their first match
+	FirstOnly bool `json:"firstOnly,omitempty"`
+}
+
+// PreviewReport shows how the config applies to a single page
+type PreviewReport struct {
+	URL       string            `json:"url"`
+	Kind      string            `json:"kind"`
+	Title     string            `json:"title,omitempty"`
+	Selectors []SelectorPreview `json:"selectors"`
+	Items     []CrawlItem       `json:"items"`
+	Links     []string          `json:"links,omitempty"`
+	NextPage  string            `json:"nextPage,omitempty"`
+	Messages  []string          `json:"messages,omitempty"`
+}
+
+// previewSink keeps the items of a preview in memory
+type previewSink struct {
+	items []CrawlItem
+}
+
+func (s *previewSink) Write(item CrawlItem) error {
+	s.items = append(s.items, item)
+	return nil
+}
+
+func (s *previewSink) Close() error {
+	return nil
+}
+
+// PreviewOptions controls how Preview treats the page
+type PreviewOptions struct {
+	// Detail treats the page as a detail page of a two-phase crawl
+	Detail bool
+	// PageURL is the address of a saved page, used to resolve its links;
+	// the first start page when empty
+	PageURL string
+}
+
+// Preview applies the config to one page instead of running the crawl.
+// source is a URL to fetch or a saved HTML, JSON or XML file. Like Run,
+// Preview closes the Events channel before returning; log messages are
+// returned in the report.
+func (c *Crawler) Preview(ctx context.Context, source string, opts PreviewOptions) (*PreviewReport, error) {
+	report := &PreviewReport{URL: source}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range c.events {
+			if ev.Type == EventLog && strings.TrimSpace(ev.Message) != "" {
+				report.Messages = append(report.Messages, strings.TrimSpace(ev.Message))
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(c.events)
+
+	doc, err := c.loadPreview(ctx, source, opts.PageURL, report)
+	if err != nil {
+		return nil, err
+	}
+	report.Kind = doc.kind
+	detail := opts.Detail
+
+	sink := &previewSink{}
+	c.sink = sink
+	c.phase = 1
+	pageURL := report.URL
+
+	switch doc.kind {
+	case docHTML:
+		report.Title = findTitle(doc.html)
+		report.Selectors = c.previewHTML(pageURL, doc.html, detail)
+	case docJSON:
+		report.Selectors = c.previewJSON(doc, detail)
+	case docSitemap:
+		report.Links = append(append(report.Links, doc.sitemap.sitemaps...), doc.sitemap.pages...)
+	}
+	if doc.html != nil {
+		if p, ok := embeddedPreview(doc.html); ok {
+			report.Selectors = append(report.Selectors, p)
+		}
+	}
+
+	switch {
+	case doc.kind == docSitemap:
+	case detail:
+		c.addDetail(pageURL, doc, 1)
+	case c.config.TwoPhaseCrawl && doc.kind != docFeed:
+		links, _ := c.listingLinks(pageURL, doc)
+		for _, u := range links {
+			report.Links = append(report.Links, u.String())
+		}
+	default:
+		c.extractItems(ctx, pageURL, doc, 0)
+	}
+	if c.config.FollowLinks && !(c.config.TwoPhaseCrawl && !detail) {
+		for _, u := range c.pageLinks(pageURL, doc) {
+			if c.scope.allowed(u) {
+				report.Links = append(report.Links, u.String())
+			}
+		}
+	}
+
+	if !detail && c.paginator.sequential() {
+		if next, ok := c.paginator.nextPage(pageURL, doc); ok {
+			report.NextPage = next
+		}
+	}
+
+	report.Items = sink.items
+	if report.Items == nil {
+		report.Items = make([]CrawlItem, 0)
+	}
+	return report, nil
+}
+
+// loadPreview fetches the page or reads the saved file
+func (c *Crawler) loadPreview(ctx context.Context, source, pageURL string, report *PreviewReport) (*document, error) {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if c.config.HTTP.Login != nil {
+			if err := c.fetcher.session.doLogin(ctx); err != nil {
+				return nil, err
+			}
+		}
+		doc, info, err := c.fetcher.fetch(ctx, source, nil)
+		if err == nil {
+			c.recordPageMeta(source, info)
+		}
+		return doc, err
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", source, err)
+	}
+	report.URL = pageURL
+	if start := c.config.StartURLs(); pageURL == "" && len(start) > 0 {
+		report.URL = start[0]
+	}
+
+	contentType := "text/html"
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".json":
+		contentType = "application/json"
+	case ".xml", ".rss", ".atom":
+		contentType = "application/xml"
+	case ".gz":
+		contentType = "application/gzip"
+	}
+	return c.fetcher.parse(contentType, data)
+}
+
+// previewHTML lists the matches of every selector of the config on an HTML page
+func (c *Crawler) previewHTML(pageURL string, doc *html.Node, detail bool) []SelectorPreview {
+	var previews []SelectorPreview
+	add := func(setting, selector string, sel *Selector, firstOnly bool, value func(n *html.Node) string) {
+		if sel == nil {
+			return
+		}
+		p := SelectorPreview{Setting: setting, Selector: selector, Matches: make([]PreviewMatch, 0), FirstOnly: firstOnly}
+		for _, n := range sel.MatchAll(doc) {
+			m := PreviewMatch{Element: describeNode(n), Text: strings.TrimSpace(whitespace.ReplaceAllString(TextContent(n), " "))}
+			if value != nil {
+				m.Value = value(n)
+			}
+			p.Matches = append(p.Matches, m)
+		}
+		previews = append(previews, p)
+	}
+
+	if !detail {
+		var value func(n *html.Node) string
+		if attr := c.config.AttributeSelector; attr != "" {
+			value = func(n *html.Node) string { return getAttr(n, attr) }
+		}
+		add("Selector", c.config.Selector, c.selector, false, value)
+	}
+	add("ContentSelector", c.config.ContentSelector, c.contentSelector, false, nil)
+
+	if detail || !c.config.TwoPhaseCrawl {
+		for i, attr := range c.config.TwoPhaseCrawlConfig.Attributes {
+			attr := attr
+			add(fmt.Sprintf("TwoPhaseCrawlConfig.Attributes[%d]", i), attr.Selector, c.attrSelectors[i], true, func(n *html.Node) string {
+				if attr.GetElementContent {
+					return strings.TrimSpace(TextContent(n))
+				}
+				return getAttr(n, attr.ElementAttribute)
+			})
+		}
+	}
+
+	if detail {
+		var embedded map[string]interface{}
+		for i, f := range c.schema {
+			f := f
+			base, _ := url.Parse(pageURL)
+			if f.selector == nil && f.path != nil {
+				if embedded == nil {
+					embedded = embeddedJSON(doc)
+				}
+				previews = append(previews, jsonPreview(fmt.Sprintf("Schema[%d] (%s)", i, f.Name), f.path, f.path.Eval(embedded), !f.List))
+				continue
+			}
+			add(fmt.Sprintf("Schema[%d] (%s)", i, f.Name), f.Selector, f.selector, !f.List, func(n *html.Node) string {
+				v, err := f.value(n, base)
+				if err != nil {
+					return "error: " + err.Error()
+				}
+				s, _ := jsonText(v)
+				return s
+			})
+		}
+	}
+
+	if c.config.FollowLinks {
+		add("LinkConfig.Selector", c.linkSelector.String(), c.linkSelector, false, func(n *html.Node) string {
+			if u, ok := resolveURL(pageURL, getAttr(n, "href")); ok {
+				return u.String()
+			}
+			return ""
+		})
+	}
+
+	if !detail {
+		p := c.paginator
+		add("Pagination.NextSelector", p.config.NextSelector, p.next, true, func(n *html.Node) string { return getAttr(n, "href") })
+		add("Pagination.CursorSelector", p.config.CursorSelector, p.cursor, true, func(n *html.Node) string {
+			if p.config.CursorAttribute != "" {
+				return getAttr(n, p.config.CursorAttribute)
+			}
+			return strings.TrimSpace(TextContent(n))
+		})
+	}
+	return previews
+}
+
+// previewJSON lists the values matched by the JSON paths of the config
+func (c *Crawler) previewJSON(doc *document, detail bool) []SelectorPreview {
+	var previews []SelectorPreview
+	add := func(setting string, path *JSONPath, values []interface{}, firstOnly bool) {
+		if path != nil {
+			previews = append(previews, jsonPreview(setting, path, values, firstOnly))
+		}
+	}
+
+	if detail {
+		// A detail response is a single item
+		add("JSON.URLPath", c.json.url, evalPath(c.json.url, doc.json), true)
+		add("JSON.TitlePath", c.json.title, evalPath(c.json.title, doc.json), true)
+		for i, f := range c.schema {
+			add(fmt.Sprintf("Schema[%d] (%s)", i, f.Name), f.path, evalPath(f.path, doc.json), !f.List)
+		}
+		return previews
+	}
+
+	items := c.jsonItems(doc)
+	add("JSON.ItemsPath", c.json.items, items, false)
+	for _, f := range []struct {
+		setting string
+		path    *JSONPath
+	}{{"JSON.URLPath", c.json.url}, {"JSON.TitlePath", c.json.title}} {
+		if f.path == nil {
+			continue
+		}
+		var values []interface{}
+		for _, item := range items {
+			if v, ok := f.path.First(item); ok {
+				values = append(values, v)
+			}
+		}
+		add(f.setting, f.path, values, false)
+	}
+
+	p := c.paginator
+	add("Pagination.NextPath", p.nextPath, evalPath(p.nextPath, doc.json), true)
+	add("Pagination.CursorPath", p.cursorPath, evalPath(p.cursorPath, doc.json), true)
+	return previews
+}
+
+// evalPath is path.Eval for a path that may be unset
+func evalPath(path *JSONPath, v interface{}) []interface{} {
+	if path == nil {
+		return nil
+	}
+	return path.Eval(v)
+}
+
+// jsonPreview lists the values matched by a JSON path
+func jsonPreview(setting string, path *JSONPath, values []interface{}, firstOnly bool) SelectorPreview {
+	p := SelectorPreview{Setting: setting, Selector: path.String(), Matches: make([]PreviewMatch, 0), FirstOnly: firstOnly}
+	for i, v := range values {
+		text, _ := jsonText(v)
+		p.Matches = append(p.Matches, PreviewMatch{Element: fmt.Sprintf("[%d]", i), Text: text})
+	}
+	return p
+}
+
+// embeddedPreview lists the JSON embedded in an HTML page by key, so Paths
+// can be written against it; ok is false when the page has none
+func embeddedPreview(doc *html.Node) (SelectorPreview, bool) {
+	embedded := embeddedJSON(doc)
+	keys := make([]string, 0, len(embedded))
+	for key := range embedded {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	p := SelectorPreview{Setting: "Embedded JSON", Matches: make([]PreviewMatch, 0, len(keys))}
+	for _, key := range keys {
+		text, _ := jsonText(embedded[key])
+		p.Matches = append(p.Matches, PreviewMatch{Element: key, Text: text})
+	}
+	return p, len(keys) > 0
+}
+
+// describeNode returns a short CSS-like description of an element, e.g. a#home.nav
+func describeNode(n *html.Node) string {
+	var b strings.Builder
+	b.WriteString(n.Data)
+	if id := getAttr(n, "id"); id != "" {
+		b.WriteString("#" + id)
+	}
+	for _, class := range strings.Fields(getAttr(n, "class")) {
+		b.WriteString("." + class)
+	}
+	return b.String()
+}
+
+// WriteText writes the report in a human-readable form, listing at most
+// previewMatches matches, items and links each
+func (r *PreviewReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "URL:   %s\nKind:  %s\n", r.URL, r.Kind)
+	if r.Title != "" {
+		fmt.Fprintf(w, "Title: %s\n", r.Title)
+	}
+
+	for _, s := range r.Selectors {
+		fmt.Fprintf(w, "\n%s", s.Setting)
+		if s.Selector != "" {
+			fmt.Fprintf(w, " %q", s.Selector)
+		}
+		fmt.Fprintf(w, ": %d matches", len(s.Matches))
+		if s.FirstOnly && len(s.Matches) > 1 {
+			fmt.Fprint(w, " (only the first is used)")
+		}
+		fmt.Fprintln(w)
+		for i, m := range s.Matches {
+			if i == previewMatches {
+				fmt.Fprintf(w, "  ... %d more\n", len(s.Matches)-i)
+				break
+			}
+			fmt.Fprintf(w, "  %2d. %s", i+1, m.Element)
+			if m.Text != "" {
+				fmt.Fprintf(w, "  %q", shortenText(m.Text))
+			}
+			if m.Value != "" {
+				fmt.Fprintf(w, "  -> %s", shortenText(m.Value))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	fmt.Fprintf(w, "\nItems: %d\n", len(r.Items))
+	for i, item := range r.Items {
+		if i == previewMatches {
+			fmt.Fprintf(w, "  ... %d more\n", len(r.Items)-i)
+			break
+		}
+		fmt.Fprintf(w, "  %2d. %s\n", i+1, shortenText(item.Title))
+		if item.URL != "" {
+			fmt.Fprintf(w, "      url: %s\n", item.URL)
+		}
+		if item.Description != "" {
+			fmt.Fprintf(w, "      description: %s\n", shortenText(item.Description))
+		}
+		printFields(w, "      ", item.Attributes)
+		for _, content := range item.Content {
+			fmt.Fprintf(w, "      content: %s\n", shortenText(content))
+		}
+		if len(item.Data) > 0 {
+			data := make(map[string]string, len(item.Data))
+			for k, v := range item.Data {
+				b, _ := json.Marshal(v)
+				data[k] = string(b)
+			}
+			fmt.Fprintln(w, "      data:")
+			printFields(w, "        ", data)
+		}
+	}
+
+	if len(r.Links) > 0 {
+		fmt.Fprintf(w, "\nLinks: %d\n", len(r.Links))
+		for i, link := range r.Links {
+			if i == previewMatches {
+				fmt.Fprintf(w, "  ... %d more\n", len(r.Links)-i)
+				break
+			}
+			fmt.Fprintf(w, "  %s\n", link)
+		}
+	}
+	if r.NextPage != "" {
+		fmt.Fprintf(w, "\nNext page: %s\n", r.NextPage)
+	}
+	if len(r.Messages) > 0 {
+		fmt.Fprintln(w, "\nMessages:")
+		for _, m := range r.Messages {
+			fmt.Fprintf(w, "  %s\n", m)
+		}
+	}
+}
+
+// printFields prints a map as sorted key: value lines
+func printFields(w io.Writer, indent string, fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s: %s\n", indent, k, shortenText(fields[k]))
+	}
+}
+
+// shortenText truncates s to previewText characters
+func shortenText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > previewText {
+		return string(r[:previewText]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProcessFile runs the PostProcess pipeline of config over the results in
+// input, a JSON array or JSON Lines file (by extension) of items, or of data
+// records when Output.Record is "data", and writes the items it keeps to
+// output in the configured output format. Enrich steps need the fetched page
+// and do nothing here.
+func ProcessFile(ctx context.Context, config FileConfig, input, output string) (kept, dropped int, err error) {
+	steps, err := compilePipeline(config.PostProcess)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch config.OutputType() {
+	case OutputJSON, OutputJSONL, OutputCSV:
+		config.OutputFile, config.Output.Target = output, ""
+	case OutputSQLite:
+		config.Output.Target = output
+	default:
+		return 0, 0, fmt.Errorf("cannot write processed results to %s output", config.OutputType())
+	}
+
+	items, err := readResults(input, strings.ToLower(config.Output.Record) == RecordData)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sink, err := OpenSink(ctx, config, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			sink.Close()
+			return kept, dropped, ctx.Err()
+		}
+		if !runPipeline(steps, &item, nil) {
+			dropped++
+			continue
+		}
+		if err := sink.Write(item); err != nil {
+			sink.Close()
+			return kept, dropped, err
+		}
+		kept++
+	}
+	return kept, dropped, sink.Close()
+}
+
+// readResults reads the items of a JSON or JSON Lines output file; with
+// records each element is a data record and becomes the Data of an item
+func readResults(path string, records bool) ([]CrawlItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening results: %v", err)
+	}
+	defer f.Close()
+
+	decode := func(data []byte) (CrawlItem, error) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var item CrawlItem
+		if records {
+			err := dec.Decode(&item.Data)
+			return item, err
+		}
+		err := dec.Decode(&item)
+		return item, err
+	}
+
+	var items []CrawlItem
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			item, err := decode(scanner.Bytes())
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s line %d: %v", path, line, err)
+			}
+			items = append(items, item)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading results: %v", err)
+		}
+	case ".json":
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading results: %v", err)
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		for i, elem := range elems {
+			item, err := decode(elem)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s item %d: %v", path, i, err)
+			}
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("cannot read results from %s; expected a .json or .jsonl file", path)
+	}
+	return items, nil
+}
@@ -0,0 +1,181 @@
+package crawler
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetryDelay    = 60 * time.Second
+	defaultFailureThreshold = 5
+	defaultFailureCooldown  = 30 * time.Second
+	maxRetryAfter           = 10 * time.Minute
+)
+
+// hostLimiter keeps a token bucket and a circuit breaker per host, so a slow
+// or failing domain doesn't hold back the others in a multi-domain crawl
+type hostLimiter struct {
+	interval  time.Duration
+	burst     int
+	threshold int
+	cooldown  time.Duration
+	logf      func(format string, args ...interface{})
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState is the token bucket and breaker state of one host
+type hostState struct {
+	tokens      float64
+	last        time.Time
+	failures    int
+	pausedUntil time.Time
+}
+
+func newHostLimiter(config AdvancedConfig, interval time.Duration, logf func(string, ...interface{})) *hostLimiter {
+	l := &hostLimiter{
+		interval:  interval,
+		burst:     config.Burst,
+		threshold: config.FailureThreshold,
+		cooldown:  time.Duration(config.FailureCooldown) * time.Second,
+		logf:      logf,
+		hosts:     make(map[string]*hostState),
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	if l.threshold < 1 {
+		l.threshold = defaultFailureThreshold
+	}
+	if l.cooldown <= 0 {
+		l.cooldown = defaultFailureCooldown
+	}
+	return l
+}
+
+// state returns the host's state, creating a full bucket on first use.
+// l.mu must be held.
+func (l *hostLimiter) state(host string) *hostState {
+	s, ok := l.hosts[host]
+	if !ok {
+		s = &hostState{tokens: float64(l.burst), last: time.Now()}
+		l.hosts[host] = s
+	}
+	return s
+}
+
+// wait blocks until host is not paused and a token is available
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	for {
+		l.mu.Lock()
+		s := l.state(host)
+		now := time.Now()
+
+		// Honor a pause set by Retry-After or an open circuit first
+		if pause := s.pausedUntil.Sub(now); pause > 0 {
+			l.mu.Unlock()
+			if err := sleepContext(ctx, pause); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if l.interval <= 0 {
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+
+		// Refill, then take a token; a negative balance is a queued reservation
+		s.tokens += float64(now.Sub(s.last)) / float64(l.interval)
+		if s.tokens > float64(l.burst) {
+			s.tokens = float64(l.burst)
+		}
+		s.last = now
+		s.tokens--
+		delay := time.Duration(-s.tokens * float64(l.interval))
+		l.mu.Unlock()
+
+		return sleepContext(ctx, delay)
+	}
+}
+
+// pause stops all requests to host until the given time
+func (l *hostLimiter) pause(host string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.state(host)
+	if until.After(s.pausedUntil) {
+		s.pausedUntil = until
+	}
+}
+
+// success closes the host's circuit
+func (l *hostLimiter) success(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state(host).failures = 0
+}
+
+// failure records a failed request and opens the circuit, pausing the host
+// for the cooldown, once threshold consecutive failures are reached
+func (l *hostLimiter) failure(host string) {
+	l.mu.Lock()
+	s := l.state(host)
+	s.failures++
+	open := s.failures >= l.threshold
+	if open {
+		s.failures = 0
+		s.pausedUntil = time.Now().Add(l.cooldown)
+	}
+	l.mu.Unlock()
+
+	if open {
+		l.logf("Circuit open for %s after %d consecutive failures, pausing for %s", host, l.threshold, l.cooldown)
+	}
+}
+
+// backoff returns the delay before retry number attempt (1-based): base
+// doubled per attempt and capped at max, with equal jitter so concurrent
+// workers don't retry in lockstep
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
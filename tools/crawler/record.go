@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Record returns what the json and jsonl outputs write for item: the item
+// itself, or its data record when Output.Record is "data"
+func (c FileConfig) Record(item CrawlItem) interface{} {
+	if strings.ToLower(c.Output.Record) != RecordData {
+		return item
+	}
+	return dataRecord{fields: outputSchema(c), data: item.Data}
+}
+
+// dataRecord is a schema object marshalled with every field, in schema order,
+// followed by the values outside the schema (added by PostProcess steps) in
+// name order
+type dataRecord struct {
+	fields []FieldConfig
+	data   map[string]interface{}
+}
+
+func (r dataRecord) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	known := make(map[string]bool, len(r.fields))
+	write := func(key string, v interface{}) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+		return nil
+	}
+	for _, f := range r.fields {
+		known[f.Name] = true
+		if err := write(f.Name, recordValue(f, r.data[f.Name])); err != nil {
+			return nil, err
+		}
+	}
+	var extra []string
+	for k := range r.data {
+		if !known[k] {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	for _, k := range extra {
+		if err := write(k, r.data[k]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// recordValue returns the value of field f in a data record, replacing a
+// missing value by the zero value of its type
+func recordValue(f FieldConfig, v interface{}) interface{} {
+	if f.List {
+		list, _ := v.([]interface{})
+		if len(list) == 0 {
+			return nil
+		}
+		if f.Type != FieldObject {
+			return list
+		}
+		objects := make([]interface{}, len(list))
+		for i, elem := range list {
+			obj, _ := elem.(map[string]interface{})
+			objects[i] = dataRecord{fields: f.Fields, data: obj}
+		}
+		return objects
+	}
+
+	if v != nil {
+		if obj, ok := v.(map[string]interface{}); ok && f.Type == FieldObject {
+			return dataRecord{fields: f.Fields, data: obj}
+		}
+		return v
+	}
+	switch f.Type {
+	case FieldString, "":
+		return ""
+	case FieldInt, FieldFloat:
+		return 0
+	}
+	return nil
+}
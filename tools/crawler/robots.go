@@ -0,0 +1,348 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserAgent is sent with every request and matched against robots.txt
+// groups unless Robots.UserAgent overrides it
+const DefaultUserAgent = "GenericWebCrawler/1.0"
+
+// ErrBlockedByRobots is returned by the fetcher for URLs disallowed by robots.txt
+var ErrBlockedByRobots = errors.New("blocked by robots.txt")
+
+// RobotsConfig controls robots.txt compliance
+type RobotsConfig struct {
+	// Ignore disables robots.txt checks for every host
+	Ignore bool `json:"Ignore,omitempty"`
+	// IgnoreHosts lists hosts (e.g. sites we own) whose robots.txt is not enforced
+	IgnoreHosts []string `json:"IgnoreHosts,omitempty"`
+	// UserAgent is the product token matched against User-agent lines
+	UserAgent string `json:"UserAgent,omitempty"`
+}
+
+// robotsRules holds the rules of the robots.txt group that applies to us
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsRule is one Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsGroup is a set of rules shared by one or more User-agent lines
+type robotsGroup struct {
+	agents []string
+	robotsRules
+}
+
+// allowed reports whether path (including any query string) may be fetched.
+// The longest matching rule wins and Allow wins ties.
+func (r *robotsRules) allowed(path string) bool {
+	best := -1
+	allow := true
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if l := len(rule.pattern); l > best || (l == best && rule.allow) {
+			best = l
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+// parseRobots parses a robots.txt body and returns the rules for userAgent
+func parseRobots(r io.Reader, userAgent string) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	lastWasAgent := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if current == nil || !lastWasAgent {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, value)
+			lastWasAgent = true
+		case "allow", "disallow":
+			lastWasAgent = false
+			if current == nil || (key == "disallow" && value == "") {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				pattern: value,
+				re:      compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			lastWasAgent = false
+			if current == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				current.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		default:
+			lastWasAgent = false
+		}
+	}
+
+	// Combine the groups naming our product token, falling back to the *
+	// groups, as RFC 9309 does
+	token := productToken(userAgent)
+	var matched, wildcard []*robotsGroup
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if agent == "*" {
+				wildcard = append(wildcard, g)
+				break
+			}
+			if token != "" && productToken(agent) == token {
+				matched = append(matched, g)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		matched = wildcard
+	}
+
+	rules := &robotsRules{}
+	for _, g := range matched {
+		rules.rules = append(rules.rules, g.rules...)
+		rules.crawlDelay = max(rules.crawlDelay, g.crawlDelay)
+	}
+	return rules
+}
+
+// productToken returns the product token a user agent starts with,
+// lowercased: "GenericWebCrawler/1.0" and "genericwebcrawler" both give
+// "genericwebcrawler". Only letters, "_" and "-" belong to a token.
+func productToken(userAgent string) string {
+	token := strings.ToLower(strings.TrimSpace(userAgent))
+	if i := strings.IndexFunc(token, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r == '_' || r == '-')
+	}); i >= 0 {
+		token = token[:i]
+	}
+	return token
+}
+
+// compileRobotsPattern turns a robots.txt path pattern with * and $ into a regexp
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// robotsCache fetches robots.txt once per host and enforces Crawl-delay
+type robotsCache struct {
+	config    RobotsConfig
+	userAgent string
+	client    *http.Client
+	logf      func(format string, args ...interface{})
+
+	mu        sync.Mutex
+	hosts     map[string]*robotsEntry
+	nextFetch map[string]time.Time
+}
+
+// robotsEntry is a per-host cache slot; ready is closed once rules or err is
+// set. Entries that failed are dropped from the cache, so the next request
+// fetches robots.txt again.
+type robotsEntry struct {
+	ready chan struct{}
+	rules *robotsRules
+	err   error
+}
+
+// robotsUserAgent returns Robots.UserAgent, defaulting to DefaultUserAgent
+func robotsUserAgent(config RobotsConfig) string {
+	if config.UserAgent != "" {
+		return config.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+func newRobotsCache(config RobotsConfig, client *http.Client, logf func(string, ...interface{})) *robotsCache {
+	return &robotsCache{
+		config:    config,
+		userAgent: robotsUserAgent(config),
+		client:    client,
+		logf:      logf,
+		hosts:     make(map[string]*robotsEntry),
+		nextFetch: make(map[string]time.Time),
+	}
+}
+
+// ignored reports whether robots.txt is disabled for host
+func (rc *robotsCache) ignored(host string) bool {
+	if rc.config.Ignore {
+		return true
+	}
+	for _, h := range rc.config.IgnoreHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns ErrBlockedByRobots if u is disallowed, and otherwise waits
+// for the host's Crawl-delay before letting the request through. While the
+// host's robots.txt cannot be fetched nothing is allowed, and the error is
+// returned so that the fetcher retries later.
+func (rc *robotsCache) check(ctx context.Context, u *url.URL) error {
+	if rc.ignored(u.Hostname()) {
+		return nil
+	}
+
+	rules, err := rc.rulesFor(ctx, u)
+	if err != nil {
+		return err
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !rules.allowed(path) {
+		return ErrBlockedByRobots
+	}
+
+	if rules.crawlDelay <= 0 {
+		return nil
+	}
+
+	// Reserve the next slot for this host and sleep until it arrives
+	rc.mu.Lock()
+	now := time.Now()
+	slot := rc.nextFetch[u.Host]
+	if slot.Before(now) {
+		slot = now
+	}
+	rc.nextFetch[u.Host] = slot.Add(rules.crawlDelay)
+	rc.mu.Unlock()
+
+	return sleepContext(ctx, time.Until(slot))
+}
+
+// rulesFor returns the cached rules for u's host, fetching robots.txt on first use
+func (rc *robotsCache) rulesFor(ctx context.Context, u *url.URL) (*robotsRules, error) {
+	key := u.Scheme + "://" + u.Host
+
+	rc.mu.Lock()
+	entry, ok := rc.hosts[key]
+	if !ok {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		rc.hosts[key] = entry
+	}
+	rc.mu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return entry.rules, entry.err
+	}
+
+	entry.rules, entry.err = rc.fetch(ctx, key)
+	if entry.err != nil {
+		rc.mu.Lock()
+		delete(rc.hosts, key)
+		rc.mu.Unlock()
+	}
+	close(entry.ready)
+	return entry.rules, entry.err
+}
+
+// fetch downloads and parses robots.txt. A missing file (a 4xx answer other
+// than 429) allows everything. Network errors, 429 and 5xx answers mean the
+// site is unreachable and are returned as errors, as is a cancelled ctx, so
+// that none of them is cached.
+func (rc *robotsCache) fetch(ctx context.Context, origin string) (*robotsRules, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return &robotsRules{}, nil
+	}
+	req.Header.Set("User-Agent", rc.userAgent)
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		rc.logf("Could not fetch %s/robots.txt, waiting before crawling the site: %v", origin, err)
+		return nil, fmt.Errorf("%s/robots.txt: %v", origin, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		se := &statusError{code: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		rc.logf("%s/robots.txt answered %d, waiting before crawling the site", origin, resp.StatusCode)
+		return nil, fmt.Errorf("%s/robots.txt: %w", origin, se)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &robotsRules{}, nil
+	}
+
+	rules := parseRobots(io.LimitReader(resp.Body, 512*1024), rc.userAgent)
+	if ctx.Err() != nil {
+		// The body may have been cut short
+		return nil, ctx.Err()
+	}
+	if rules.crawlDelay > 0 {
+		rc.logf("%s/robots.txt: Crawl-delay %s", origin, rules.crawlDelay)
+	}
+	return rules, nil
+}
+
+// blockedError wraps ErrBlockedByRobots with the offending URL
+func blockedError(u string) error {
+	return fmt.Errorf("%s: %w", u, ErrBlockedByRobots)
+}
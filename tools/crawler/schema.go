@@ -0,0 +1,498 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Field types for FieldConfig.Type
+const (
+	FieldString = "string"
+	FieldInt    = "int"
+	FieldFloat  = "float"
+	FieldDate   = "date"
+	FieldObject = "object"
+)
+
+// Sources for FieldConfig.Source
+const (
+	SourceURL  = "url"
+	SourceNone = "none"
+)
+
+// Transform types for TransformConfig.Type
+const (
+	TransformTrim        = "trim"
+	TransformRegex       = "regex"
+	TransformReplace     = "replace"
+	TransformAbsoluteURL = "absolute-url"
+)
+
+// defaultDateFormats are tried in order for date fields without a Format
+var defaultDateFormats = []string{time.RFC3339, "2006-01-02", "02/01/2006", "2006/01/02", "January 2, 2006", "2 January 2006"}
+
+// FieldConfig declares one field of the extraction schema. Selector is
+// matched inside the enclosing element (the page for top-level fields of a
+// detail page, the Selector match for listing items, the parent for nested
+// fields); an empty Selector uses that element itself. The value is the
+// element's text, or Attribute when set, passed through Transforms and then
+// coerced to Type. Objects take their value from the nested Fields instead.
+// In JSON responses Path is used instead of Selector, evaluated against the
+// enclosing value the same way; fields without a Path are missing there. On
+// HTML pages a field with only a Path reads the JSON embedded in the
+// enclosing element (see embeddedJSON), e.g. Path
+// "__NEXT_DATA__.props.pageProps.doctor.name"; its nested fields then read
+// JSON as well. Source "url" takes the URL of the item's page instead of
+// matching anything, e.g. to keep it in data records (see Output.Record).
+// Source "none" is never filled; it keeps a field of an older record shape
+// that the pages don't provide, written as null (or the zero value of Type).
+type FieldConfig struct {
+	Name      string `json:"Name"`
+	Selector  string `json:"Selector,omitempty"`
+	Path      string `json:"Path,omitempty"`
+	Attribute string `json:"Attribute,omitempty"`
+	Source    string `json:"Source,omitempty"`
+	// Type is "string" (default), "int", "float", "date" or "object"
+	Type string `json:"Type,omitempty"`
+	// Format is the Go time layout of a date field
+	Format string `json:"Format,omitempty"`
+	// List collects a value from every matching element instead of the first
+	List       bool              `json:"List,omitempty"`
+	Fields     []FieldConfig     `json:"Fields,omitempty"`
+	Transforms []TransformConfig `json:"Transforms,omitempty"`
+	// Required rejects the item (or, inside a list, the list element) when
+	// the field is missing or cannot be coerced
+	Required bool `json:"Required,omitempty"`
+}
+
+// TransformConfig is one step of a field's post-processing. "trim" strips
+// surrounding whitespace and collapses inner runs; "regex" keeps capture
+// Group of Pattern (the field is missing if it doesn't match); "replace"
+// replaces every match of Pattern with Replacement ($1 expands groups);
+// "absolute-url" resolves the value against the page URL.
+type TransformConfig struct {
+	Type        string `json:"Type"`
+	Pattern     string `json:"Pattern,omitempty"`
+	Group       int    `json:"Group,omitempty"`
+	Replacement string `json:"Replacement,omitempty"`
+}
+
+// schemaField is a compiled FieldConfig
+type schemaField struct {
+	FieldConfig
+	selector   *Selector
+	path       *JSONPath
+	transforms []transform
+	fields     []*schemaField
+}
+
+// transform is a compiled TransformConfig
+type transform struct {
+	TransformConfig
+	re *regexp.Regexp
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// compileSchema compiles and validates a list of fields; path prefixes error
+// messages. Every invalid field is reported, joined in the error.
+func compileSchema(fields []FieldConfig, path string) ([]*schemaField, error) {
+	names := make(map[string]bool)
+	var compiled []*schemaField
+	var errs []error
+
+	for i, fc := range fields {
+		where := fmt.Sprintf("%s[%d]", path, i)
+		if names[fc.Name] && fc.Name != "" {
+			errs = append(errs, fmt.Errorf("%s: duplicate field name %q", where, fc.Name))
+			continue
+		}
+		names[fc.Name] = true
+
+		f, err := compileField(fc, where)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		compiled = append(compiled, f)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return compiled, nil
+}
+
+// compileField compiles the field at where, stopping at its first problem
+func compileField(fc FieldConfig, where string) (*schemaField, error) {
+	if fc.Name == "" {
+		return nil, fmt.Errorf("%s.Name is required", where)
+	}
+	if strings.Contains(fc.Name, ".") {
+		return nil, fmt.Errorf("%s.Name must not contain '.'", where)
+	}
+
+	f := &schemaField{FieldConfig: fc}
+	if f.Type == "" {
+		f.Type = FieldString
+	}
+
+	if fc.Selector != "" {
+		sel, err := CompileSelector(fc.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%s.Selector: %v", where, err)
+		}
+		f.selector = sel
+	}
+
+	if fc.Path != "" {
+		path, err := CompileJSONPath(fc.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s.Path: %v", where, err)
+		}
+		f.path = path
+	}
+
+	switch fc.Source {
+	case "":
+	case SourceURL, SourceNone:
+		if fc.Selector != "" || fc.Path != "" || fc.Attribute != "" || f.Type == FieldObject {
+			return nil, fmt.Errorf("%s: fields with Source %q take no Selector, Path, Attribute or Fields", where, fc.Source)
+		}
+		if fc.Source == SourceNone && fc.Required {
+			return nil, fmt.Errorf("%s: fields with Source %q cannot be Required", where, SourceNone)
+		}
+	default:
+		return nil, fmt.Errorf("%s.Source must be empty, %q or %q", where, SourceURL, SourceNone)
+	}
+
+	switch f.Type {
+	case FieldObject:
+		if len(fc.Fields) == 0 {
+			return nil, fmt.Errorf("%s.Fields is required for object fields", where)
+		}
+		if len(fc.Transforms) > 0 || fc.Attribute != "" {
+			return nil, fmt.Errorf("%s: object fields take no Attribute or Transforms", where)
+		}
+		children, err := compileSchema(fc.Fields, where+".Fields")
+		if err != nil {
+			return nil, err
+		}
+		f.fields = children
+	case FieldString, FieldInt, FieldFloat, FieldDate:
+		if len(fc.Fields) > 0 {
+			return nil, fmt.Errorf("%s.Fields is only allowed for object fields", where)
+		}
+	default:
+		return nil, fmt.Errorf("%s.Type must be %q, %q, %q, %q or %q", where, FieldString, FieldInt, FieldFloat, FieldDate, FieldObject)
+	}
+
+	for j, tc := range fc.Transforms {
+		t, err := compileTransform(tc)
+		if err != nil {
+			return nil, fmt.Errorf("%s.Transforms[%d]: %v", where, j, err)
+		}
+		f.transforms = append(f.transforms, t)
+	}
+
+	return f, nil
+}
+
+func compileTransform(tc TransformConfig) (transform, error) {
+	t := transform{TransformConfig: tc}
+	switch tc.Type {
+	case TransformTrim, TransformAbsoluteURL:
+	case TransformRegex, TransformReplace:
+		if tc.Pattern == "" {
+			return t, fmt.Errorf("Pattern is required")
+		}
+		re, err := regexp.Compile(tc.Pattern)
+		if err != nil {
+			return t, fmt.Errorf("invalid pattern: %v", err)
+		}
+		if tc.Type == TransformRegex {
+			if tc.Group < 0 || tc.Group > re.NumSubexp() {
+				return t, fmt.Errorf("Group %d does not exist in %q", tc.Group, tc.Pattern)
+			}
+			if tc.Group == 0 && re.NumSubexp() > 0 {
+				t.Group = 1
+			}
+		}
+		t.re = re
+	default:
+		return t, fmt.Errorf("Type must be %q, %q, %q or %q", TransformTrim, TransformRegex, TransformReplace, TransformAbsoluteURL)
+	}
+	return t, nil
+}
+
+// extractObject evaluates fields inside scope. It fails when a required
+// field is missing.
+func extractObject(fields []*schemaField, scope *html.Node, base *url.URL) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	var embedded map[string]interface{}
+	for _, f := range fields {
+		var v interface{}
+		var err error
+		if f.selector == nil && f.path != nil {
+			// Embedded JSON is only collected when a field needs it
+			if embedded == nil {
+				embedded = embeddedJSON(scope)
+			}
+			v, err = f.extractJSON(embedded, base)
+		} else {
+			v, err = f.extract(scope, base)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			obj[f.Name] = v
+		}
+	}
+	return obj, nil
+}
+
+// extractJSONObject is extractObject for a value of a JSON response
+func extractJSONObject(fields []*schemaField, scope interface{}, base *url.URL) (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	for _, f := range fields {
+		v, err := f.extractJSON(scope, base)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			obj[f.Name] = v
+		}
+	}
+	return obj, nil
+}
+
+// extract returns the field's value inside scope, or nil when it is missing
+func (f *schemaField) extract(scope *html.Node, base *url.URL) (interface{}, error) {
+	switch f.Source {
+	case SourceURL:
+		return f.pageURL(base)
+	case SourceNone:
+		return nil, nil
+	}
+
+	var nodes []*html.Node
+	switch {
+	case f.selector == nil:
+		nodes = []*html.Node{scope}
+	case f.List:
+		nodes = f.selector.MatchAll(scope)
+	default:
+		if n := f.selector.MatchFirst(scope); n != nil {
+			nodes = []*html.Node{n}
+		}
+	}
+	return pickValue(f, nodes, func(n *html.Node) (interface{}, error) {
+		return f.value(n, base)
+	})
+}
+
+// extractJSON returns the field's value inside a JSON scope, or nil when it is missing
+func (f *schemaField) extractJSON(scope interface{}, base *url.URL) (interface{}, error) {
+	switch f.Source {
+	case SourceURL:
+		return f.pageURL(base)
+	case SourceNone:
+		return nil, nil
+	}
+
+	var values []interface{}
+	switch {
+	case f.path != nil:
+		values = f.path.Eval(scope)
+	case f.selector == nil:
+		values = []interface{}{scope}
+	}
+	if !f.List && len(values) > 1 {
+		values = values[:1]
+	}
+	return pickValue(f, values, func(v interface{}) (interface{}, error) {
+		return f.jsonValue(v, base)
+	})
+}
+
+// pageURL returns the value of a field with Source "url"
+func (f *schemaField) pageURL(base *url.URL) (interface{}, error) {
+	var urls []string
+	if base != nil {
+		urls = append(urls, base.String())
+	}
+	return pickValue(f, urls, func(s string) (interface{}, error) {
+		return f.convert(s, base)
+	})
+}
+
+// pickValue evaluates the field on its matches: every match for lists, the
+// first otherwise
+func pickValue[T any](f *schemaField, matches []T, value func(T) (interface{}, error)) (interface{}, error) {
+	if f.List {
+		// Elements that are missing the value or a required nested field are skipped
+		values := make([]interface{}, 0, len(matches))
+		for _, m := range matches {
+			if v, err := value(m); err == nil && v != nil {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 && f.Required {
+			return nil, fmt.Errorf("required field %s is missing", f.Name)
+		}
+		return values, nil
+	}
+
+	var v interface{}
+	var err error
+	if len(matches) > 0 {
+		v, err = value(matches[0])
+	}
+
+	if f.Required {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if v == nil {
+			return nil, fmt.Errorf("required field %s is missing", f.Name)
+		}
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// value extracts, transforms and coerces the value of one element
+func (f *schemaField) value(n *html.Node, base *url.URL) (interface{}, error) {
+	if f.Type == FieldObject {
+		return extractObject(f.fields, n, base)
+	}
+
+	if f.Attribute != "" {
+		return f.convert(getAttr(n, f.Attribute), base)
+	}
+	return f.convert(TextContent(n), base)
+}
+
+// jsonValue transforms and coerces one JSON value
+func (f *schemaField) jsonValue(v interface{}, base *url.URL) (interface{}, error) {
+	if f.Type == FieldObject {
+		return extractJSONObject(f.fields, v, base)
+	}
+
+	s, ok := jsonText(v)
+	if !ok {
+		return nil, nil
+	}
+	return f.convert(s, base)
+}
+
+// convert passes s through the transforms and coerces it to the field's type
+func (f *schemaField) convert(s string, base *url.URL) (interface{}, error) {
+	for _, t := range f.transforms {
+		var ok bool
+		if s, ok = t.apply(s, base); !ok {
+			return nil, nil
+		}
+	}
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	return f.coerce(s)
+}
+
+// apply runs the transform on s; ok is false when the value should be dropped
+func (t transform) apply(s string, base *url.URL) (string, bool) {
+	switch t.Type {
+	case TransformTrim:
+		return strings.TrimSpace(whitespace.ReplaceAllString(s, " ")), true
+	case TransformRegex:
+		m := t.re.FindStringSubmatch(s)
+		if m == nil {
+			return "", false
+		}
+		return m[t.Group], true
+	case TransformReplace:
+		return t.re.ReplaceAllString(s, t.Replacement), true
+	case TransformAbsoluteURL:
+		ref, err := url.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return "", false
+		}
+		if base != nil {
+			ref = base.ResolveReference(ref)
+		}
+		return ref.String(), true
+	}
+	return s, true
+}
+
+// coerce converts s to the field's type
+func (f *schemaField) coerce(s string) (interface{}, error) {
+	switch f.Type {
+	case FieldInt:
+		v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %q", s)
+		}
+		return v, nil
+	case FieldFloat:
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", s)
+		}
+		return v, nil
+	case FieldDate:
+		s = strings.TrimSpace(s)
+		formats := defaultDateFormats
+		if f.Format != "" {
+			formats = []string{f.Format}
+		}
+		for _, layout := range formats {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid date %q", s)
+	}
+	return s, nil
+}
+
+// schemaColumns returns the dotted paths of the schema's scalar values, with
+// lists and objects inside lists as single columns
+func schemaColumns(fields []FieldConfig, prefix string) []string {
+	var columns []string
+	for _, f := range fields {
+		path := prefix + f.Name
+		if f.Type == FieldObject && !f.List {
+			columns = append(columns, schemaColumns(f.Fields, path+".")...)
+		} else {
+			columns = append(columns, path)
+		}
+	}
+	return columns
+}
+
+// lookupPath returns the value at a dotted path in a schema object
+func lookupPath(obj map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
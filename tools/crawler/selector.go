@@ -0,0 +1,541 @@
+package crawler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector is a compiled CSS selector group that can be matched against
+// *html.Node trees. It supports type and universal selectors, .class, #id,
+// attribute selectors ([a], [a=v], [a~=v], [a|=v], [a^=v], [a$=v], [a*=v]),
+// the :nth-child family of pseudo-classes and the descendant, child (>),
+// adjacent sibling (+) and general sibling (~) combinators. Comma separated
+// groups match if any group matches.
+type Selector struct {
+	raw    string
+	groups []complexSelector
+}
+
+// complexSelector is a chain of compound selectors joined by combinators.
+// combinators[i] joins compounds[i] and compounds[i+1].
+type complexSelector struct {
+	compounds   []compoundSelector
+	combinators []byte
+}
+
+// compoundSelector is a sequence of simple selectors that all apply to one element
+type compoundSelector struct {
+	tag     string
+	ids     []string
+	classes []string
+	attrs   []attrSelector
+	pseudos []nthSelector
+}
+
+// attrSelector matches an attribute by name and optionally by value
+type attrSelector struct {
+	key string
+	op  string
+	val string
+}
+
+// nthSelector matches elements whose 1-based position is a*n+b for some n >= 0
+type nthSelector struct {
+	a, b    int
+	fromEnd bool
+}
+
+// CompileSelector parses a CSS selector string
+func CompileSelector(selector string) (*Selector, error) {
+	p := &selectorParser{src: selector}
+	groups, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+	return &Selector{raw: selector, groups: groups}, nil
+}
+
+// MustCompileSelector is like CompileSelector but panics on error
+func MustCompileSelector(selector string) *Selector {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// String returns the selector source
+func (s *Selector) String() string {
+	return s.raw
+}
+
+// Match reports whether the element n matches the selector
+func (s *Selector) Match(n *html.Node) bool {
+	if n == nil || n.Type != html.ElementNode {
+		return false
+	}
+	for _, g := range s.groups {
+		if g.match(n, len(g.compounds)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAll returns every element in the tree rooted at root (root included)
+// that matches the selector, in document order
+func (s *Selector) MatchAll(root *html.Node) []*html.Node {
+	var matches []*html.Node
+	var traverse func(*html.Node)
+	traverse = func(n *html.Node) {
+		if s.Match(n) {
+			matches = append(matches, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	if root != nil {
+		traverse(root)
+	}
+	return matches
+}
+
+// MatchFirst returns the first matching element in document order, or nil
+func (s *Selector) MatchFirst(root *html.Node) *html.Node {
+	if root == nil {
+		return nil
+	}
+	if s.Match(root) {
+		return root
+	}
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if m := s.MatchFirst(c); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// match checks compounds[i] against n and then walks the combinators leftwards
+func (c complexSelector) match(n *html.Node, i int) bool {
+	if !c.compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	switch c.combinators[i-1] {
+	case ' ':
+		for p := n.Parent; p != nil; p = p.Parent {
+			if p.Type == html.ElementNode && c.match(p, i-1) {
+				return true
+			}
+		}
+	case '>':
+		if p := n.Parent; p != nil && p.Type == html.ElementNode {
+			return c.match(p, i-1)
+		}
+	case '+':
+		if p := prevElementSibling(n); p != nil {
+			return c.match(p, i-1)
+		}
+	case '~':
+		for p := prevElementSibling(n); p != nil; p = prevElementSibling(p) {
+			if c.match(p, i-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c compoundSelector) match(n *html.Node) bool {
+	if c.tag != "" && c.tag != "*" && !strings.EqualFold(n.Data, c.tag) {
+		return false
+	}
+	for _, id := range c.ids {
+		if getAttr(n, "id") != id {
+			return false
+		}
+	}
+	for _, class := range c.classes {
+		if !hasClass(n, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.match(n) {
+			return false
+		}
+	}
+	for _, p := range c.pseudos {
+		if !p.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) match(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if !strings.EqualFold(attr.Key, a.key) {
+			continue
+		}
+		switch a.op {
+		case "":
+			return true
+		case "=":
+			return attr.Val == a.val
+		case "~=":
+			for _, f := range strings.Fields(attr.Val) {
+				if f == a.val {
+					return true
+				}
+			}
+			return false
+		case "|=":
+			return attr.Val == a.val || strings.HasPrefix(attr.Val, a.val+"-")
+		case "^=":
+			return a.val != "" && strings.HasPrefix(attr.Val, a.val)
+		case "$=":
+			return a.val != "" && strings.HasSuffix(attr.Val, a.val)
+		case "*=":
+			return a.val != "" && strings.Contains(attr.Val, a.val)
+		}
+	}
+	return false
+}
+
+func (p nthSelector) match(n *html.Node) bool {
+	pos := 1
+	if p.fromEnd {
+		for s := n.NextSibling; s != nil; s = s.NextSibling {
+			if s.Type == html.ElementNode {
+				pos++
+			}
+		}
+	} else {
+		for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+			if s.Type == html.ElementNode {
+				pos++
+			}
+		}
+	}
+
+	if p.a == 0 {
+		return pos == p.b
+	}
+	diff := pos - p.b
+	return diff%p.a == 0 && diff/p.a >= 0
+}
+
+// prevElementSibling returns the closest preceding sibling that is an element
+func prevElementSibling(n *html.Node) *html.Node {
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+// selectorParser is a small recursive-descent parser for CSS selector groups
+type selectorParser struct {
+	src string
+	pos int
+}
+
+func (p *selectorParser) parse() ([]complexSelector, error) {
+	var groups []complexSelector
+	for {
+		p.skipSpace()
+		g, err := p.parseComplex()
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return groups, nil
+		}
+		if p.src[p.pos] != ',' {
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.src[p.pos], p.pos)
+		}
+		p.pos++
+	}
+}
+
+func (p *selectorParser) parseComplex() (complexSelector, error) {
+	var c complexSelector
+
+	first, err := p.parseCompound()
+	if err != nil {
+		return c, err
+	}
+	c.compounds = append(c.compounds, first)
+
+	for {
+		hadSpace := p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] == ',' {
+			return c, nil
+		}
+
+		comb := byte(' ')
+		switch p.src[p.pos] {
+		case '>', '+', '~':
+			comb = p.src[p.pos]
+			p.pos++
+			p.skipSpace()
+		default:
+			if !hadSpace {
+				return c, fmt.Errorf("unexpected %q at offset %d", p.src[p.pos], p.pos)
+			}
+		}
+
+		next, err := p.parseCompound()
+		if err != nil {
+			return c, err
+		}
+		c.combinators = append(c.combinators, comb)
+		c.compounds = append(c.compounds, next)
+	}
+}
+
+func (p *selectorParser) parseCompound() (compoundSelector, error) {
+	var c compoundSelector
+	start := p.pos
+
+	if p.pos < len(p.src) && p.src[p.pos] == '*' {
+		c.tag = "*"
+		p.pos++
+	} else if p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		c.tag = p.parseIdent()
+	}
+
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '#':
+			p.pos++
+			id := p.parseIdent()
+			if id == "" {
+				return c, fmt.Errorf("expected id after '#' at offset %d", p.pos)
+			}
+			c.ids = append(c.ids, id)
+		case '.':
+			p.pos++
+			class := p.parseIdent()
+			if class == "" {
+				return c, fmt.Errorf("expected class name after '.' at offset %d", p.pos)
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			attr, err := p.parseAttr()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attr)
+		case ':':
+			nth, err := p.parsePseudo()
+			if err != nil {
+				return c, err
+			}
+			c.pseudos = append(c.pseudos, nth)
+		default:
+			if p.pos == start {
+				return c, fmt.Errorf("expected selector at offset %d", p.pos)
+			}
+			return c, nil
+		}
+	}
+
+	if p.pos == start {
+		return c, fmt.Errorf("expected selector at offset %d", p.pos)
+	}
+	return c, nil
+}
+
+func (p *selectorParser) parseAttr() (attrSelector, error) {
+	var a attrSelector
+	p.pos++ // '['
+	p.skipSpace()
+
+	a.key = p.parseIdent()
+	if a.key == "" {
+		return a, fmt.Errorf("expected attribute name at offset %d", p.pos)
+	}
+	p.skipSpace()
+
+	if p.pos >= len(p.src) {
+		return a, fmt.Errorf("unterminated attribute selector")
+	}
+	if p.src[p.pos] == ']' {
+		p.pos++
+		return a, nil
+	}
+
+	switch {
+	case p.src[p.pos] == '=':
+		a.op = "="
+		p.pos++
+	case p.pos+1 < len(p.src) && p.src[p.pos+1] == '=' && strings.IndexByte("~|^$*", p.src[p.pos]) >= 0:
+		a.op = p.src[p.pos : p.pos+2]
+		p.pos += 2
+	default:
+		return a, fmt.Errorf("unexpected %q in attribute selector at offset %d", p.src[p.pos], p.pos)
+	}
+	p.skipSpace()
+
+	if p.pos >= len(p.src) {
+		return a, fmt.Errorf("unterminated attribute selector")
+	}
+	if quote := p.src[p.pos]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(p.src[p.pos+1:], quote)
+		if end < 0 {
+			return a, fmt.Errorf("unterminated string at offset %d", p.pos)
+		}
+		a.val = p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else {
+		a.val = p.parseIdent()
+	}
+	p.skipSpace()
+
+	if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+		return a, fmt.Errorf("expected ']' at offset %d", p.pos)
+	}
+	p.pos++
+	return a, nil
+}
+
+func (p *selectorParser) parsePseudo() (nthSelector, error) {
+	p.pos++ // ':'
+	name := strings.ToLower(p.parseIdent())
+
+	switch name {
+	case "first-child":
+		return nthSelector{a: 0, b: 1}, nil
+	case "last-child":
+		return nthSelector{a: 0, b: 1, fromEnd: true}, nil
+	case "nth-child", "nth-last-child":
+		if p.pos >= len(p.src) || p.src[p.pos] != '(' {
+			return nthSelector{}, fmt.Errorf("expected '(' after :%s", name)
+		}
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return nthSelector{}, fmt.Errorf("unterminated :%s", name)
+		}
+		nth, err := parseNth(p.src[p.pos+1 : p.pos+end])
+		if err != nil {
+			return nthSelector{}, err
+		}
+		nth.fromEnd = name == "nth-last-child"
+		p.pos += end + 1
+		return nth, nil
+	default:
+		return nthSelector{}, fmt.Errorf("unsupported pseudo-class :%s", name)
+	}
+}
+
+// parseNth parses the an+b micro-syntax used by :nth-child
+func parseNth(expr string) (nthSelector, error) {
+	expr = strings.ToLower(strings.ReplaceAll(expr, " ", ""))
+	switch expr {
+	case "odd":
+		return nthSelector{a: 2, b: 1}, nil
+	case "even":
+		return nthSelector{a: 2, b: 0}, nil
+	case "":
+		return nthSelector{}, fmt.Errorf("empty :nth-child expression")
+	}
+
+	idx := strings.IndexByte(expr, 'n')
+	if idx < 0 {
+		b, err := strconv.Atoi(expr)
+		if err != nil {
+			return nthSelector{}, fmt.Errorf("invalid :nth-child expression %q", expr)
+		}
+		return nthSelector{b: b}, nil
+	}
+
+	var nth nthSelector
+	switch coef := expr[:idx]; coef {
+	case "", "+":
+		nth.a = 1
+	case "-":
+		nth.a = -1
+	default:
+		a, err := strconv.Atoi(coef)
+		if err != nil {
+			return nthSelector{}, fmt.Errorf("invalid :nth-child expression %q", expr)
+		}
+		nth.a = a
+	}
+
+	if rest := expr[idx+1:]; rest != "" {
+		b, err := strconv.Atoi(rest)
+		if err != nil {
+			return nthSelector{}, fmt.Errorf("invalid :nth-child expression %q", expr)
+		}
+		nth.b = b
+	}
+	return nth, nil
+}
+
+func (p *selectorParser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) {
+			p.pos += 2
+			continue
+		}
+		if !isIdentChar(c) {
+			break
+		}
+		p.pos++
+	}
+	return strings.ReplaceAll(p.src[start:p.pos], "\\", "")
+}
+
+func (p *selectorParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte(" \t\n\r\f", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func isIdentChar(c byte) bool {
+	return c == '-' || c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// getAttr returns the value of the named attribute, or "" if absent
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// hasClass checks if an HTML element has a specific class
+func hasClass(n *html.Node, className string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == "class" {
+			for _, class := range strings.Fields(attr.Val) {
+				if class == className {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
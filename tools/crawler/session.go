@@ -0,0 +1,421 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Auth types for AuthConfig.Type
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+// HTTPConfig controls the requests the crawler sends. Headers are added to
+// every request. UserAgents and Proxies are rotated round-robin, one per
+// request; proxies may be http://, https:// or socks5:// URLs. Cookies set by
+// the sites (including by Login) are kept for the whole crawl.
+type HTTPConfig struct {
+	Headers    map[string]string `json:"Headers,omitempty"`
+	UserAgents []string          `json:"UserAgents,omitempty"`
+	Proxies    []string          `json:"Proxies,omitempty"`
+	// Timeout is the per-request timeout in seconds (default 30)
+	Timeout int          `json:"Timeout,omitempty"`
+	Auth    AuthConfig   `json:"Auth,omitempty"`
+	Login   *LoginConfig `json:"Login,omitempty"`
+}
+
+// AuthConfig adds HTTP authentication to the requests sent to the host of
+// the first start page. Password and Token may reference environment
+// variables ($NAME), so secrets don't have to be stored in the config file.
+type AuthConfig struct {
+	// Type is "basic" or "bearer"; empty disables authentication
+	Type     string `json:"Type,omitempty"`
+	Username string `json:"Username,omitempty"`
+	Password string `json:"Password,omitempty"`
+	Token    string `json:"Token,omitempty"`
+}
+
+// LoginConfig is a form login performed once before the crawl. The login page
+// at URL is fetched, the form matching FormSelector (default "form") is filled
+// with its own inputs (so CSRF tokens are kept) overridden by Fields, and
+// submitted to the form's action. Field values may reference environment
+// variables ($NAME). When SuccessSelector is set it must match the page
+// returned after submitting, otherwise the crawl is aborted.
+type LoginConfig struct {
+	URL             string            `json:"URL"`
+	FormSelector    string            `json:"FormSelector,omitempty"`
+	Fields          map[string]string `json:"Fields"`
+	SuccessSelector string            `json:"SuccessSelector,omitempty"`
+}
+
+// validateHTTPConfig checks the headers, proxies, authentication and login settings
+func validateHTTPConfig(config HTTPConfig) error {
+	if config.Timeout < 0 {
+		return fmt.Errorf("HTTP.Timeout must be at least 0")
+	}
+
+	for name := range config.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("HTTP.Headers: invalid header name %q", name)
+		}
+	}
+
+	for i, ua := range config.UserAgents {
+		if strings.TrimSpace(ua) == "" {
+			return fmt.Errorf("HTTP.UserAgents[%d] is empty", i)
+		}
+	}
+
+	for i, p := range config.Proxies {
+		if _, err := parseProxy(p); err != nil {
+			return fmt.Errorf("HTTP.Proxies[%d]: %v", i, err)
+		}
+	}
+
+	switch config.Auth.Type {
+	case "":
+	case AuthBasic:
+		if config.Auth.Username == "" {
+			return fmt.Errorf("HTTP.Auth.Username is required for %s authentication", AuthBasic)
+		}
+	case AuthBearer:
+		if config.Auth.Token == "" {
+			return fmt.Errorf("HTTP.Auth.Token is required for %s authentication", AuthBearer)
+		}
+	default:
+		return fmt.Errorf("HTTP.Auth.Type must be %q or %q", AuthBasic, AuthBearer)
+	}
+
+	if login := config.Login; login != nil {
+		if login.URL == "" {
+			return fmt.Errorf("HTTP.Login.URL is required")
+		}
+		if u, err := url.Parse(login.URL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("HTTP.Login.URL must be an absolute URL")
+		}
+		if len(login.Fields) == 0 {
+			return fmt.Errorf("HTTP.Login.Fields is required")
+		}
+		if login.FormSelector != "" {
+			if _, err := CompileSelector(login.FormSelector); err != nil {
+				return fmt.Errorf("HTTP.Login.FormSelector: %v", err)
+			}
+		}
+		if login.SuccessSelector != "" {
+			if _, err := CompileSelector(login.SuccessSelector); err != nil {
+				return fmt.Errorf("HTTP.Login.SuccessSelector: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseProxy parses a proxy URL and checks its scheme
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q in %s", u.Scheme, raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %s has no host", raw)
+	}
+	return u, nil
+}
+
+// rotation hands out the values of a list in turn; it is safe for concurrent use
+type rotation struct {
+	values []string
+	next   uint32
+}
+
+func (r *rotation) pick() string {
+	if len(r.values) == 0 {
+		return ""
+	}
+	i := atomic.AddUint32(&r.next, 1) - 1
+	return r.values[i%uint32(len(r.values))]
+}
+
+// session holds the HTTP client, cookie jar and per-request settings
+type session struct {
+	client     *http.Client
+	headers    map[string]string
+	userAgents *rotation
+	userAgent  string
+	authHost   string
+	auth       AuthConfig
+	login      *LoginConfig
+}
+
+func newSession(config FileConfig, userAgent string) (*session, error) {
+	httpConfig := config.HTTP
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, fmt.Errorf("error creating cookie jar: %v", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(httpConfig.Proxies) > 0 {
+		var proxies []*url.URL
+		for _, p := range httpConfig.Proxies {
+			u, err := parseProxy(p)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, u)
+		}
+		var next uint32
+		transport.Proxy = func(*http.Request) (*url.URL, error) {
+			i := atomic.AddUint32(&next, 1) - 1
+			return proxies[i%uint32(len(proxies))], nil
+		}
+	}
+
+	timeout := defaultHTTPTimeout
+	if httpConfig.Timeout > 0 {
+		timeout = time.Duration(httpConfig.Timeout) * time.Second
+	}
+
+	s := &session{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			Jar:       jar,
+		},
+		headers:    httpConfig.Headers,
+		userAgents: &rotation{values: httpConfig.UserAgents},
+		userAgent:  userAgent,
+		auth:       httpConfig.Auth,
+		login:      httpConfig.Login,
+	}
+	s.auth.Password = os.ExpandEnv(s.auth.Password)
+	s.auth.Token = os.ExpandEnv(s.auth.Token)
+	if start := config.StartURLs(); len(start) > 0 {
+		if base, err := url.Parse(start[0]); err == nil {
+			s.authHost = base.Host
+		}
+	}
+	return s, nil
+}
+
+// newRequest builds a request with the configured headers, user agent and
+// authentication
+func (s *session) newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	if ua := s.userAgents.pick(); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	} else if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+
+	// Credentials are only sent to the site being crawled
+	if req.URL.Host == s.authHost {
+		switch s.auth.Type {
+		case AuthBasic:
+			req.SetBasicAuth(s.auth.Username, s.auth.Password)
+		case AuthBearer:
+			req.Header.Set("Authorization", "Bearer "+s.auth.Token)
+		}
+	}
+	return req, nil
+}
+
+// get fetches rawURL and returns the final URL and parsed page
+func (s *session) get(ctx context.Context, rawURL string) (*url.URL, *html.Node, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.do(req)
+}
+
+func (s *session) do(req *http.Request) (*url.URL, *html.Node, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, nil, &statusError{code: resp.StatusCode}
+	}
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing HTML: %v", err)
+	}
+	return resp.Request.URL, doc, nil
+}
+
+// doLogin submits the login form; its cookies stay in the jar for the crawl
+func (s *session) doLogin(ctx context.Context) error {
+	login := s.login
+
+	pageURL, doc, err := s.get(ctx, login.URL)
+	if err != nil {
+		return fmt.Errorf("error fetching login page: %v", err)
+	}
+
+	formSelector := login.FormSelector
+	if formSelector == "" {
+		formSelector = "form"
+	}
+	sel, err := CompileSelector(formSelector)
+	if err != nil {
+		return err
+	}
+	form := sel.MatchFirst(doc)
+	if form == nil {
+		return fmt.Errorf("no login form matching %q on %s", formSelector, login.URL)
+	}
+
+	values := formValues(form)
+	for name, value := range login.Fields {
+		values.Set(name, os.ExpandEnv(value))
+	}
+
+	action := pageURL
+	if a := strings.TrimSpace(getAttr(form, "action")); a != "" {
+		ref, err := url.Parse(a)
+		if err != nil {
+			return fmt.Errorf("invalid login form action %q: %v", a, err)
+		}
+		action = pageURL.ResolveReference(ref)
+	}
+
+	var req *http.Request
+	if strings.EqualFold(getAttr(form, "method"), http.MethodGet) {
+		u := *action
+		u.RawQuery = values.Encode()
+		req, err = s.newRequest(ctx, http.MethodGet, u.String(), nil)
+	} else {
+		req, err = s.newRequest(ctx, http.MethodPost, action.String(), bytes.NewBufferString(values.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, result, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("error submitting login form: %v", err)
+	}
+
+	if login.SuccessSelector != "" {
+		check, err := CompileSelector(login.SuccessSelector)
+		if err != nil {
+			return err
+		}
+		if check.MatchFirst(result) == nil {
+			return fmt.Errorf("login failed: %q not found after submitting the form", login.SuccessSelector)
+		}
+	}
+	return nil
+}
+
+// formValues collects the default values of a form's inputs, selects and textareas
+func formValues(form *html.Node) url.Values {
+	values := url.Values{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			name := getAttr(n, "name")
+			switch {
+			case name == "":
+			case n.Data == "input":
+				switch strings.ToLower(getAttr(n, "type")) {
+				case "submit", "button", "image", "reset", "file":
+				case "checkbox", "radio":
+					if hasAttr(n, "checked") {
+						value := getAttr(n, "value")
+						if value == "" {
+							value = "on"
+						}
+						values.Add(name, value)
+					}
+				default:
+					values.Add(name, getAttr(n, "value"))
+				}
+			case n.Data == "textarea":
+				values.Add(name, TextContent(n))
+			case n.Data == "select":
+				if v, ok := selectedOption(n); ok {
+					values.Add(name, v)
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(form)
+	return values
+}
+
+// selectedOption returns the value of a select's selected (or first) option
+func selectedOption(sel *html.Node) (string, bool) {
+	var first, selected *html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "option" {
+			if first == nil {
+				first = n
+			}
+			if selected == nil && hasAttr(n, "selected") {
+				selected = n
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(sel)
+	if selected == nil {
+		selected = first
+	}
+	if selected == nil {
+		return "", false
+	}
+	if hasAttr(selected, "value") {
+		return getAttr(selected, "value"), true
+	}
+	return strings.TrimSpace(TextContent(selected)), true
+}
+
+// hasAttr reports whether n has the attribute key, even if it is empty
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,523 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Record shapes for OutputConfig.Record
+const (
+	RecordItem = "item"
+	RecordData = "data"
+)
+
+// Output types for OutputConfig.Type
+const (
+	OutputJSON    = "json"
+	OutputJSONL   = "jsonl"
+	OutputCSV     = "csv"
+	OutputSQLite  = "sqlite"
+	OutputMongoDB = "mongodb"
+)
+
+// OutputConfig selects where crawl results are written. Target defaults to
+// OutputFile for the file types and SQLite; for MongoDB it is the connection
+// string. File outputs are replaced by a new crawl and continued by a resumed
+// one, from the last item saved in the checkpoint. Database outputs always
+// append, so items found after the last checkpoint are written again when
+// the crawl is resumed.
+type OutputConfig struct {
+	// Type is "json" (default), "jsonl", "csv", "sqlite" or "mongodb"
+	Type   string `json:"Type,omitempty"`
+	Target string `json:"Target,omitempty"`
+	// Table is the SQLite table (default "crawl_items")
+	Table string `json:"Table,omitempty"`
+	// Database and Collection name the MongoDB collection (default "crawler" and "crawl_items")
+	Database   string `json:"Database,omitempty"`
+	Collection string `json:"Collection,omitempty"`
+	// Record is "item" (default) to write whole items or "data" to write only
+	// the schema values of each item, for the json and jsonl types. Data
+	// records list every schema field in order: missing strings are "",
+	// missing numbers 0, and missing dates, objects and lists (or empty
+	// lists) null, as a Go struct of the same fields would be marshalled.
+	Record string `json:"Record,omitempty"`
+}
+
+// Sink receives crawl results as they are found. The crawler never calls
+// Write concurrently and calls Close once when the run ends.
+type Sink interface {
+	Write(item CrawlItem) error
+	Close() error
+}
+
+// offsetSink is a file output that a resumed crawl can cut back to the
+// checkpoint, so that items written after it are not written twice
+type offsetSink interface {
+	// Offset is the size of the output after the last written item
+	Offset() int64
+}
+
+// OutputType returns Output.Type, defaulting to json
+func (c FileConfig) OutputType() string {
+	if c.Output.Type == "" {
+		return OutputJSON
+	}
+	return strings.ToLower(c.Output.Type)
+}
+
+// OutputTarget returns Output.Target, defaulting to OutputFile
+func (c FileConfig) OutputTarget() string {
+	if c.Output.Target != "" {
+		return c.Output.Target
+	}
+	return c.OutputFile
+}
+
+// OpenSink opens the output configured in config. With appendMode an
+// existing output is continued instead of replaced, as when resuming.
+func OpenSink(ctx context.Context, config FileConfig, appendMode bool) (Sink, error) {
+	return openSink(ctx, config, appendMode, 0)
+}
+
+// openSink is OpenSink continuing a file output from offset, as recorded by
+// a checkpoint. With offset 0 the file is continued after its last complete
+// item, dropping what a crash left half-written.
+func openSink(ctx context.Context, config FileConfig, appendMode bool, offset int64) (Sink, error) {
+	target := config.OutputTarget()
+	if !appendMode {
+		offset = 0
+	}
+	switch config.OutputType() {
+	case OutputJSON:
+		return openJSONSink(target, appendMode, offset, config.Record)
+	case OutputJSONL:
+		return openJSONLSink(target, appendMode, offset, config.Record)
+	case OutputCSV:
+		return openCSVSink(target, appendMode, offset, config)
+	case OutputSQLite:
+		return openSQLiteSink(target, config.Output.Table)
+	case OutputMongoDB:
+		return openMongoSink(ctx, target, config.Output.Database, config.Output.Collection)
+	}
+	return nil, fmt.Errorf("unknown output type %q", config.Output.Type)
+}
+
+// openOutputFile creates path's directory and opens it for writing, keeping
+// the existing content with appendMode
+func openOutputFile(path string, appendMode bool) (*os.File, error) {
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory: %v", err)
+		}
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if !appendMode {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening output file: %v", err)
+	}
+	return f, nil
+}
+
+// truncateOutput cuts f back to offset and continues writing there
+func truncateOutput(f *os.File, offset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		return fmt.Errorf("the output is %d bytes, shorter than the %d bytes recorded in the checkpoint", info.Size(), offset)
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// jsonSink writes a pretty-printed JSON array, one element at a time. The
+// file is a complete array again once the sink is closed.
+type jsonSink struct {
+	f      *os.File
+	count  int
+	offset int64
+	record func(CrawlItem) interface{}
+}
+
+func openJSONSink(path string, appendMode bool, offset int64, record func(CrawlItem) interface{}) (*jsonSink, error) {
+	f, err := openOutputFile(path, appendMode)
+	if err != nil {
+		return nil, err
+	}
+	s := &jsonSink{f: f, record: record}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if _, err := f.WriteString("["); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error writing JSON to file: %v", err)
+		}
+		s.offset = 1
+		return s, nil
+	}
+
+	// Reopen the array: drop the closing bracket and continue after the last element
+	if err := s.reopen(info.Size(), offset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot continue %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// reopen continues an existing array of size bytes after its element that
+// ends at offset, or after its last complete element when offset is 0
+func (s *jsonSink) reopen(size, offset int64) error {
+	// Find the complete elements: after a crash the array has no closing
+	// bracket and its last element may be cut off
+	dec := json.NewDecoder(bufio.NewReader(io.NewSectionReader(s.f, 0, size)))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return fmt.Errorf("not a JSON array")
+	}
+	end, count := dec.InputOffset(), 0
+	for dec.More() && (offset == 0 || end < offset) {
+		var element json.RawMessage
+		if err := dec.Decode(&element); err != nil {
+			break
+		}
+		end = dec.InputOffset()
+		count++
+	}
+	if offset > 0 && end != offset {
+		return fmt.Errorf("no element ends at offset %d recorded in the checkpoint", offset)
+	}
+
+	if err := truncateOutput(s.f, end); err != nil {
+		return err
+	}
+	s.offset = end
+	s.count = count
+	return nil
+}
+
+func (s *jsonSink) Offset() int64 {
+	return s.offset
+}
+
+func (s *jsonSink) Write(item CrawlItem) error {
+	data, err := json.MarshalIndent(s.record(item), "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling data to JSON: %v", err)
+	}
+
+	sep := "\n  "
+	if s.count > 0 {
+		sep = ",\n  "
+	}
+	n, err := s.f.Write(append([]byte(sep), data...))
+	s.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing JSON to file: %v", err)
+	}
+	s.count++
+	return nil
+}
+
+func (s *jsonSink) Close() error {
+	closing := "\n]\n"
+	if s.count == 0 {
+		closing = "]\n"
+	}
+	if _, err := s.f.WriteString(closing); err != nil {
+		s.f.Close()
+		return fmt.Errorf("error writing JSON to file: %v", err)
+	}
+	return s.f.Close()
+}
+
+// jsonlSink writes one JSON object per line
+type jsonlSink struct {
+	f      *countingWriter
+	enc    *json.Encoder
+	record func(CrawlItem) interface{}
+}
+
+func openJSONLSink(path string, appendMode bool, offset int64, record func(CrawlItem) interface{}) (*jsonlSink, error) {
+	f, err := openOutputFile(path, appendMode)
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 {
+		// A line cut off by a crash is dropped
+		if offset, err = lastLineEnd(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot continue %s: %v", path, err)
+		}
+	}
+	if err := truncateOutput(f, offset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot continue %s: %v", path, err)
+	}
+	w := &countingWriter{File: f, n: offset}
+	return &jsonlSink{f: w, enc: json.NewEncoder(w), record: record}, nil
+}
+
+func (s *jsonlSink) Write(item CrawlItem) error {
+	if err := s.enc.Encode(s.record(item)); err != nil {
+		return fmt.Errorf("error writing JSON line: %v", err)
+	}
+	return nil
+}
+
+func (s *jsonlSink) Offset() int64 {
+	return s.f.n
+}
+
+func (s *jsonlSink) Close() error {
+	return s.f.Close()
+}
+
+// countingWriter tracks the size of a file being appended to
+type countingWriter struct {
+	*os.File
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// lastLineEnd returns the size of f up to and including its last newline
+func lastLineEnd(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// Prefixes of the flattened attribute and schema columns of the CSV output
+const (
+	csvAttributePrefix = "attributes."
+	csvDataPrefix      = "data."
+)
+
+// csvBaseColumns are the CrawlItem fields written before the attribute columns
+var csvBaseColumns = []string{"url", "title", "description", "content", "links", "timestamp"}
+
+// csvSink writes one row per item with each attribute and each scalar schema
+// value in its own column. The header is written with the first item;
+// attributes that were not known by then end up as a JSON object in the
+// trailing "attributes" column.
+type csvSink struct {
+	f       *countingWriter
+	w       *csv.Writer
+	attrs   []string
+	data    []string
+	known   map[string]bool
+	started bool
+}
+
+func openCSVSink(path string, appendMode bool, offset int64, config FileConfig) (*csvSink, error) {
+	f, err := openOutputFile(path, appendMode)
+	if err != nil {
+		return nil, err
+	}
+	s := &csvSink{known: make(map[string]bool)}
+
+	// Continue with the header of an existing file
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("error reading CSV header: %v", err)
+	}
+	if err == nil {
+		for _, col := range header {
+			if strings.HasPrefix(col, csvAttributePrefix) {
+				s.addColumn(strings.TrimPrefix(col, csvAttributePrefix))
+			} else if strings.HasPrefix(col, csvDataPrefix) {
+				s.data = append(s.data, strings.TrimPrefix(col, csvDataPrefix))
+			}
+		}
+		s.started = true
+		if offset == 0 {
+			offset = lastCSVRowEnd(f, r)
+		}
+	} else {
+		for _, attr := range config.TwoPhaseCrawlConfig.Attributes {
+			s.addColumn(attr.JsonAttribute)
+		}
+		s.data = schemaColumns(outputSchema(config), "")
+	}
+
+	if err := truncateOutput(f, offset); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot continue %s: %v", path, err)
+	}
+	s.f = &countingWriter{File: f, n: offset}
+	s.w = csv.NewWriter(s.f)
+	return s, nil
+}
+
+// lastCSVRowEnd returns the end of the last complete row read by r. A row
+// cut off by a crash either does not parse or lacks its line break.
+func lastCSVRowEnd(f *os.File, r *csv.Reader) int64 {
+	end := r.InputOffset()
+	last := make([]byte, 1)
+	for {
+		if _, err := r.Read(); err != nil {
+			return end
+		}
+		offset := r.InputOffset()
+		if _, err := f.ReadAt(last, offset-1); err != nil || last[0] != '\n' {
+			return end
+		}
+		end = offset
+	}
+}
+
+func (s *csvSink) addColumn(name string) {
+	if !s.known[name] {
+		s.known[name] = true
+		s.attrs = append(s.attrs, name)
+	}
+}
+
+func (s *csvSink) Write(item CrawlItem) error {
+	if !s.started {
+		keys := make([]string, 0, len(item.Attributes))
+		for k := range item.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.addColumn(k)
+		}
+
+		header := append([]string(nil), csvBaseColumns...)
+		for _, attr := range s.attrs {
+			header = append(header, csvAttributePrefix+attr)
+		}
+		for _, path := range s.data {
+			header = append(header, csvDataPrefix+path)
+		}
+		header = append(header, "attributes")
+		if err := s.w.Write(header); err != nil {
+			return fmt.Errorf("error writing CSV: %v", err)
+		}
+		s.started = true
+	}
+
+	row := []string{
+		item.URL,
+		item.Title,
+		item.Description,
+		strings.Join(item.Content, "\n"),
+		strings.Join(item.Links, "\n"),
+		item.Timestamp.Format(time.RFC3339),
+	}
+	for _, attr := range s.attrs {
+		row = append(row, item.Attributes[attr])
+	}
+	for _, path := range s.data {
+		cell, err := csvValue(item.Data, path)
+		if err != nil {
+			return err
+		}
+		row = append(row, cell)
+	}
+
+	extra := make(map[string]string)
+	for k, v := range item.Attributes {
+		if !s.known[k] {
+			extra[k] = v
+		}
+	}
+	extraJSON := ""
+	if len(extra) > 0 {
+		data, err := json.Marshal(extra)
+		if err != nil {
+			return fmt.Errorf("error marshalling attributes: %v", err)
+		}
+		extraJSON = string(data)
+	}
+	row = append(row, extraJSON)
+
+	if err := s.w.Write(row); err != nil {
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) Offset() int64 {
+	return s.f.n
+}
+
+func (s *csvSink) Close() error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.f.Close()
+		return fmt.Errorf("error writing CSV: %v", err)
+	}
+	return s.f.Close()
+}
+
+// csvValue formats the schema value at path for a CSV cell; lists and
+// objects are written as JSON
+func csvValue(data map[string]interface{}, path string) (string, error) {
+	v, ok := lookupPath(data, path)
+	if !ok {
+		return "", nil
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case int64, float64:
+		return fmt.Sprint(v), nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling %s: %v", path, err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultMongoDatabase   = "crawler"
+	defaultMongoCollection = "crawl_items"
+	mongoTimeout           = 10 * time.Second
+)
+
+// mongoSink inserts each item as a document into a MongoDB collection
+type mongoSink struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func openMongoSink(ctx context.Context, uri, database, collection string) (*mongoSink, error) {
+	if database == "" {
+		database = defaultMongoDatabase
+	}
+	if collection == "" {
+		collection = defaultMongoCollection
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to MongoDB: %v", err)
+	}
+
+	// Ping the database to verify connection
+	if err := client.Ping(connectCtx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("error connecting to MongoDB: %v", err)
+	}
+
+	return &mongoSink{
+		client:     client,
+		collection: client.Database(database).Collection(collection),
+	}, nil
+}
+
+func (s *mongoSink) Write(item CrawlItem) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+
+	if _, err := s.collection.InsertOne(ctx, item); err != nil {
+		return fmt.Errorf("error inserting into MongoDB: %v", err)
+	}
+	return nil
+}
+
+func (s *mongoSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
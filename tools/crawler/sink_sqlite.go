@@ -0,0 +1,105 @@
+package crawler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const defaultSQLiteTable = "crawl_items"
+
+// sqlIdentifier matches table names that are safe to splice into statements
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqliteSink inserts each item as a row of a local SQLite table. Attributes,
+// content, links and schema data are stored as JSON text.
+type sqliteSink struct {
+	db   *sql.DB
+	stmt *sql.Stmt
+}
+
+func openSQLiteSink(path, table string) (*sqliteSink, error) {
+	if table == "" {
+		table = defaultSQLiteTable
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("invalid SQLite table name %q", table)
+	}
+
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory: %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		title TEXT,
+		description TEXT,
+		attributes TEXT,
+		content TEXT,
+		links TEXT,
+		timestamp TEXT,
+		data TEXT
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating SQLite table: %v", err)
+	}
+
+	stmt, err := db.Prepare(`INSERT INTO ` + table + ` (url, title, description, attributes, content, links, timestamp, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error preparing SQLite insert: %v", err)
+	}
+
+	return &sqliteSink{db: db, stmt: stmt}, nil
+}
+
+func (s *sqliteSink) Write(item CrawlItem) error {
+	attributes, err := json.Marshal(item.Attributes)
+	if err != nil {
+		return fmt.Errorf("error marshalling attributes: %v", err)
+	}
+	content, err := json.Marshal(item.Content)
+	if err != nil {
+		return fmt.Errorf("error marshalling content: %v", err)
+	}
+	links, err := json.Marshal(item.Links)
+	if err != nil {
+		return fmt.Errorf("error marshalling links: %v", err)
+	}
+
+	var data sql.NullString
+	if item.Data != nil {
+		b, err := json.Marshal(item.Data)
+		if err != nil {
+			return fmt.Errorf("error marshalling data: %v", err)
+		}
+		data = sql.NullString{String: string(b), Valid: true}
+	}
+
+	_, err = s.stmt.Exec(item.URL, item.Title, item.Description, string(attributes), string(content), string(links), item.Timestamp.Format(time.RFC3339), data)
+	if err != nil {
+		return fmt.Errorf("error inserting into SQLite: %v", err)
+	}
+	return nil
+}
+
+func (s *sqliteSink) Close() error {
+	s.stmt.Close()
+	return s.db.Close()
+}
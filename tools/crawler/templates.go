@@ -0,0 +1,227 @@
+package crawler
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// builtinTemplates are the templates shipped with the crawler
+//
+//go:embed templates/*.json
+var builtinTemplates embed.FS
+
+// Template is a reusable crawl recipe stored as a JSON file. String settings
+// of Config may contain {name} placeholders for the template's Variables,
+// which Instantiate fills in.
+type Template struct {
+	Name        string             `json:"Name"`
+	Description string             `json:"Description,omitempty"`
+	Variables   []TemplateVariable `json:"Variables,omitempty"`
+	Config      FileConfig         `json:"Config"`
+
+	// Path is the file the template was loaded from; empty for built-in templates
+	Path string `json:"-"`
+}
+
+// TemplateVariable is a placeholder of a template; a variable without a
+// Default must be given a value
+type TemplateVariable struct {
+	Name        string `json:"Name"`
+	Description string `json:"Description,omitempty"`
+	Default     string `json:"Default,omitempty"`
+}
+
+// DefaultTemplateDir returns the directory user templates are kept in
+func DefaultTemplateDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".crawler", "templates"), nil
+}
+
+// LoadTemplates returns the built-in templates and those in dir, sorted by
+// name. A template in dir replaces a built-in one of the same name. A
+// missing dir is not an error.
+func LoadTemplates(dir string) ([]Template, error) {
+	byName := make(map[string]Template)
+
+	entries, err := builtinTemplates.ReadDir("templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		data, err := builtinTemplates.ReadFile(path.Join("templates", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseTemplate(data)
+		if err != nil {
+			return nil, fmt.Errorf("built-in template %s: %v", e.Name(), err)
+		}
+		byName[strings.ToLower(t.Name)] = t
+	}
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			t, err := LoadTemplateFile(file)
+			if err != nil {
+				return nil, err
+			}
+			byName[strings.ToLower(t.Name)] = t
+		}
+	}
+
+	templates := make([]Template, 0, len(byName))
+	for _, t := range byName {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return strings.ToLower(templates[i].Name) < strings.ToLower(templates[j].Name)
+	})
+	return templates, nil
+}
+
+// LoadTemplateFile reads a single template file
+func LoadTemplateFile(file string) (Template, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Template{}, fmt.Errorf("error reading template: %v", err)
+	}
+	t, err := parseTemplate(data)
+	if err != nil {
+		return Template{}, fmt.Errorf("template %s: %v", file, err)
+	}
+	t.Path = file
+	return t, nil
+}
+
+// FindTemplate returns the template named name (case-insensitive) from dir or
+// the built-in templates
+func FindTemplate(dir, name string) (Template, error) {
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		return Template{}, err
+	}
+	for _, t := range templates {
+		if strings.EqualFold(t.Name, name) {
+			return t, nil
+		}
+	}
+	return Template{}, fmt.Errorf("no template named %q", name)
+}
+
+func parseTemplate(data []byte) (Template, error) {
+	var t Template
+	if err := json.Unmarshal(StripJSONComments(data), &t); err != nil {
+		return t, fmt.Errorf("error parsing template: %v", err)
+	}
+	if strings.TrimSpace(t.Name) == "" {
+		return t, fmt.Errorf("Name is required")
+	}
+	for i, v := range t.Variables {
+		if v.Name == "" || strings.ContainsAny(v.Name, "{}") {
+			return t, fmt.Errorf("Variables[%d].Name must be a non-empty name without braces", i)
+		}
+	}
+	return t, nil
+}
+
+// Instantiate returns the template's config with every {variable} replaced
+// by its value from values, or its default. The config is not validated.
+func (t Template) Instantiate(values map[string]string) (FileConfig, error) {
+	declared := make(map[string]bool, len(t.Variables))
+	for _, v := range t.Variables {
+		declared[v.Name] = true
+	}
+	for name := range values {
+		if !declared[name] {
+			return FileConfig{}, fmt.Errorf("template %q has no variable %q", t.Name, name)
+		}
+	}
+
+	data, err := json.Marshal(t.Config)
+	if err != nil {
+		return FileConfig{}, err
+	}
+	text := string(data)
+	for _, v := range t.Variables {
+		value, ok := values[v.Name]
+		if !ok || value == "" {
+			value = v.Default
+		}
+		if value == "" {
+			return FileConfig{}, fmt.Errorf("template %q: variable %q needs a value", t.Name, v.Name)
+		}
+
+		// Escape the value for the JSON string it is placed in
+		quoted, _ := json.Marshal(value)
+		text = strings.ReplaceAll(text, "{"+v.Name+"}", string(quoted[1:len(quoted)-1]))
+	}
+
+	var config FileConfig
+	if err := json.Unmarshal([]byte(text), &config); err != nil {
+		return FileConfig{}, fmt.Errorf("error instantiating template: %v", err)
+	}
+	return config, nil
+}
+
+// SaveTemplate writes t to dir as <name>.json and returns the file path.
+// An existing template of the same name is replaced.
+func SaveTemplate(dir string, t Template) (string, error) {
+	if strings.TrimSpace(t.Name) == "" {
+		return "", fmt.Errorf("template name is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating template directory: %v", err)
+	}
+
+	// Keep URLs readable: no \u0026 for & in query strings
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(t); err != nil {
+		return "", fmt.Errorf("error marshalling template: %v", err)
+	}
+	file := t.Path
+	if file == "" || filepath.Dir(file) != filepath.Clean(dir) {
+		file = filepath.Join(dir, templateFileName(t.Name))
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("error writing template: %v", err)
+	}
+	return file, nil
+}
+
+// templateFileName turns a template name into a file name, e.g.
+// "Tam Anh doctors" into tam-anh-doctors.json
+func templateFileName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "template"
+	}
+	return slug + ".json"
+}
@@ -0,0 +1,362 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigError is a problem found in a config file. Line and Column are 1-based
+// and 0 when the problem has no position, e.g. a required setting is missing.
+type ConfigError struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// configPathPattern matches setting paths such as Schema[0].Fields[1].Name in error messages
+var configPathPattern = regexp.MustCompile(`[A-Z][A-Za-z]*(?:\[\d+\])?(?:\.[A-Za-z]+(?:\[\d+\])?)*`)
+
+// CheckConfigFile reads a config file and returns every problem found in it;
+// the error is only set when the file cannot be read
+func CheckConfigFile(path string) (FileConfig, []ConfigError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FileConfig{}, nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	config, problems := CheckConfig(data)
+	return config, problems, nil
+}
+
+// CheckConfig parses a config file and reports syntax errors, settings of
+// the wrong type, unknown settings (usually typos) and every validation
+// error, each with its position in data when it can be found
+func CheckConfig(data []byte) (FileConfig, []ConfigError) {
+	var config FileConfig
+	clean := StripJSONComments(data)
+
+	var problems []ConfigError
+	locator, err := locateConfigKeys(clean, reflect.TypeOf(config))
+	if err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			line, col := lineColumn(clean, se.Offset)
+			return config, []ConfigError{{Line: line, Column: col, Message: "syntax error: " + se.Error()}}
+		}
+		return config, []ConfigError{{Message: "syntax error: " + err.Error()}}
+	}
+	for _, u := range locator.unknown {
+		line, col := lineColumn(clean, u.offset)
+		problems = append(problems, ConfigError{Line: line, Column: col, Path: u.path, Message: u.message})
+	}
+
+	if err := json.Unmarshal(clean, &config); err != nil {
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) {
+			line, col := lineColumn(clean, te.Offset)
+			problems = append(problems, ConfigError{
+				Line:    line,
+				Column:  col,
+				Path:    te.Field,
+				Message: fmt.Sprintf("%s must be %s, not %s", te.Field, jsonKind(te.Type), te.Value),
+			})
+		} else {
+			problems = append(problems, ConfigError{Message: err.Error()})
+		}
+		return config, sortProblems(problems)
+	}
+
+	for _, err := range validateConfig(config) {
+		for _, err := range splitErrors(err) {
+			problem := ConfigError{Message: err.Error()}
+			if path, offset, ok := locator.find(err.Error()); ok {
+				problem.Path = path
+				problem.Line, problem.Column = lineColumn(clean, offset)
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	return config, sortProblems(problems)
+}
+
+// splitErrors flattens the errors joined in err, such as those of every
+// invalid Schema field
+func splitErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, splitErrors(e)...)
+	}
+	return errs
+}
+
+// sortProblems orders problems by position, those without one last
+func sortProblems(problems []ConfigError) []ConfigError {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line != 0 && (problems[j].Line == 0 || problems[i].Line < problems[j].Line)
+	})
+	return problems
+}
+
+// jsonKind describes a Go type in JSON terms for error messages
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return t.String()
+}
+
+// lineColumn converts a byte offset in data to a 1-based line and column
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// configLocator knows where each setting of a config file is
+type configLocator struct {
+	offsets map[string]int64
+	unknown []unknownKey
+}
+
+// unknownKey is a key that doesn't match any setting
+type unknownKey struct {
+	path    string
+	offset  int64
+	message string
+}
+
+// find returns the position of the first setting named in msg. Settings
+// that are missing from the file are located at their closest parent.
+func (l *configLocator) find(msg string) (string, int64, bool) {
+	for _, candidate := range configPathPattern.FindAllString(msg, -1) {
+		for path := candidate; path != ""; path = parentPath(path) {
+			if offset, ok := l.offsets[path]; ok {
+				return path, offset, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// parentPath strips the last member or index from a setting path
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// locateConfigKeys walks a JSON document and records the offset of every
+// key and list element, checking keys against the fields of typ
+func locateConfigKeys(data []byte, typ reflect.Type) (*configLocator, error) {
+	// Report syntax errors with an offset, which the token stream doesn't
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			return nil, err
+		}
+	}
+
+	type frame struct {
+		path  string
+		typ   reflect.Type
+		array bool
+		index int
+		key   string
+		child reflect.Type
+	}
+	l := &configLocator{offsets: make(map[string]int64)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var stack []*frame
+
+	// next returns the path and type of the value about to be read
+	next := func() (string, reflect.Type) {
+		top := stack[len(stack)-1]
+		if top.array {
+			path := fmt.Sprintf("%s[%d]", top.path, top.index)
+			top.index++
+			l.offsets[path] = dec.InputOffset()
+			return path, elemType(top.typ)
+		}
+		path, t := top.key, top.child
+		top.key = ""
+		return path, t
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return l, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				f := &frame{array: d == '[', typ: typ}
+				if len(stack) > 0 {
+					f.path, f.typ = next()
+				}
+				stack = append(stack, f)
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		if len(stack) == 0 {
+			continue
+		}
+		top := stack[len(stack)-1]
+		if !top.array && top.key == "" {
+			name := tok.(string)
+			top.key = joinPath(top.path, name)
+			l.offsets[top.key] = dec.InputOffset() - int64(len(name)) - 2
+			var known bool
+			top.child, known = memberType(top.typ, name)
+			if !known {
+				l.unknown = append(l.unknown, unknownKey{
+					path:    top.key,
+					offset:  l.offsets[top.key],
+					message: unknownKeyMessage(top.key, name, top.typ),
+				})
+			}
+			continue
+		}
+		next()
+	}
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// elemType returns the element type of a list, or nil when unknown
+func elemType(t reflect.Type) reflect.Type {
+	t = derefType(t)
+	if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		return t.Elem()
+	}
+	return nil
+}
+
+// memberType returns the type of the member name of t. Like encoding/json,
+// names match json tags case-insensitively. known is false for a struct
+// without such a field; maps and unknown types accept any name.
+func memberType(t reflect.Type, name string) (reflect.Type, bool) {
+	t = derefType(t)
+	if t == nil {
+		return nil, true
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if strings.EqualFold(jsonName(f), name) {
+				return f.Type, true
+			}
+		}
+		return nil, false
+	}
+	return nil, true
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// jsonName returns the JSON key of a struct field
+func jsonName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// unknownKeyMessage reports an unknown key, suggesting a close field name
+func unknownKeyMessage(path, name string, t reflect.Type) string {
+	msg := fmt.Sprintf("unknown setting %s", path)
+	t = derefType(t)
+	if t == nil || t.Kind() != reflect.Struct {
+		return msg
+	}
+
+	best, bestDist := "", 3
+	for i := 0; i < t.NumField(); i++ {
+		candidate := jsonName(t.Field(i))
+		if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	if best != "" {
+		msg += fmt.Sprintf(" (did you mean %s?)", best)
+	}
+	return msg
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
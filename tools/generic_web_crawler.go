@@ -1,16 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"golang.org/x/net/html"
 	"io"
-	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -20,76 +16,9 @@ import (
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
-)
-
-// CrawlItem represents a generic item crawled from a webpage
-type CrawlItem struct {
-	URL         string            `json:"url"`
-	Title       string            `json:"title"`
-	Description string            `json:"description"`
-	Attributes  map[string]string `json:"attributes"`
-	Content     []string          `json:"content"`
-	Links       []string          `json:"links"`
-	Timestamp   time.Time         `json:"timestamp"`
-}
-
-// FileConfig holds the configuration loaded from a JSON file
-type FileConfig struct {
-	BaseURL             string              `json:"BaseURL"`
-	StartPage           int                 `json:"StartPage"`
-	EndPage             int                 `json:"EndPage"`
-	PagePattern         string              `json:"PagePattern"`
-	Selector            string              `json:"Selector"`
-	AttributeSelector   string              `json:"AttributeSelector"`
-	AdvancedConfig      AdvancedConfig      `json:"AdvancedConfig"`
-	TwoPhaseCrawlConfig TwoPhaseCrawlConfig `json:"TwoPhaseCrawlConfig"`
-	OutputFile          string              `json:"OutputFile"`
-	TwoPhaseCrawl       bool                `json:"TwoPhaseCrawl"`
-	FollowLinks         bool                `json:"FollowLinks"`
-}
-
-// AdvancedConfig holds advanced configuration options for the crawler
-type AdvancedConfig struct {
-	MaxConcurrent int      `json:"MaxConcurrent"`
-	MaxRetries    int      `json:"MaxRetries"`
-	RetryDelay    int      `json:"RetryDelay"`
-	RateLimit     int      `json:"RateLimit"`
-	MaxDepth      int      `json:"MaxDepth"`
-	CustomFilters []string `json:"CustomFilters"`
-}
-
-// AttributeConfig holds configuration for attributes to extract in two-phase crawl
-type AttributeConfig struct {
-	Selector          string `json:"Selector"`
-	ElementAttribute  string `json:"ElementAttribute"`
-	JsonAttribute     string `json:"JsonAttribute"`
-	GetElementContent bool   `json:"GetElementContent"`
-}
 
-// TwoPhaseCrawlConfig holds configuration for two-phase crawling
-type TwoPhaseCrawlConfig struct {
-	Attributes []AttributeConfig `json:"Attributes"`
-}
-
-// CrawlConfig holds the configuration for the crawler
-type CrawlConfig struct {
-	BaseURL           string
-	StartPage         int
-	EndPage           int
-	PagePattern       string
-	Selector          string
-	AttributeSelector string
-	ContentSelector   string
-	MaxConcurrent     int
-	MaxRetries        int
-	RetryDelay        time.Duration
-	RateLimit         time.Duration
-	OutputFile        string
-	TwoPhaseCrawl     bool
-	FollowLinks       bool
-	MaxDepth          int
-	CustomFilters     []string
-}
+	"go-project/tools/crawler"
+)
 
 // WebCrawlerGUI represents the GUI for the web crawler
 type WebCrawlerGUI struct {
@@ -97,10 +26,10 @@ type WebCrawlerGUI struct {
 	window fyne.Window
 
 	// Config file
-	configFile       string
-	fileConfig       FileConfig
-	configLoaded     bool
-	configUploadBtn  *widget.Button
+	configFile        string
+	fileConfig        crawler.FileConfig
+	configLoaded      bool
+	configUploadBtn   *widget.Button
 	configStatusLabel *widget.Label
 	configDisplayArea *widget.Entry
 
@@ -123,6 +52,7 @@ type WebCrawlerGUI struct {
 	// Checkboxes
 	twoPhaseCrawlCheck *widget.Check
 	followLinksCheck   *widget.Check
+	resumeCheck        *widget.Check
 
 	// Output and status
 	outputTextArea *widget.Entry
@@ -141,20 +71,21 @@ type WebCrawlerGUI struct {
 
 	// State
 	crawlInProgress bool
-	stopRequested   bool
-	crawlResults    []CrawlItem
-	crawlConfig     CrawlConfig
+	cancelCrawl     context.CancelFunc
 
 	// Templates
-	templates map[string]CrawlConfig
+	templateDir string
+	templates   map[string]crawler.Template
+	// template is the template the current config was made from, with its
+	// variables still in place
+	template *crawler.Template
 }
 
 // NewWebCrawlerGUI creates a new instance of the web crawler GUI
 func NewWebCrawlerGUI() *WebCrawlerGUI {
 	gui := &WebCrawlerGUI{
 		app:          app.New(),
-		crawlResults: make([]CrawlItem, 0),
-		templates:    make(map[string]CrawlConfig),
+		templates:    make(map[string]crawler.Template),
 		configLoaded: false,
 	}
 
@@ -177,106 +108,25 @@ func NewWebCrawlerGUI() *WebCrawlerGUI {
 
 // loadConfigFromFile loads configuration from a JSON file
 func (g *WebCrawlerGUI) loadConfigFromFile(filePath string) error {
-	data, err := os.ReadFile(filePath)
+	config, err := crawler.LoadConfig(filePath)
 	if err != nil {
-		return fmt.Errorf("error reading config file: %v", err)
-	}
-
-	// Remove comments from JSON (comments start with // and continue to end of line)
-	re := regexp.MustCompile(`//.*`)
-	cleanJSON := re.ReplaceAllString(string(data), "")
-
-	var config FileConfig
-	if err := json.Unmarshal([]byte(cleanJSON), &config); err != nil {
-		return fmt.Errorf("error parsing config file: %v", err)
-	}
-
-	// Validate the loaded configuration
-	if err := g.validateFileConfig(config); err != nil {
-		return fmt.Errorf("invalid configuration: %v", err)
+		// Point at the offending lines when the file can be checked
+		if _, problems, checkErr := crawler.CheckConfigFile(filePath); checkErr == nil && len(problems) > 0 {
+			msgs := make([]string, len(problems))
+			for i, p := range problems {
+				msgs[i] = p.Error()
+			}
+			return fmt.Errorf("invalid configuration:\n%s", strings.Join(msgs, "\n"))
+		}
+		return err
 	}
 
 	g.fileConfig = config
+	g.template = nil
 	g.updateUIFromConfig()
 	return nil
 }
 
-// validateFileConfig validates the FileConfig structure
-func (g *WebCrawlerGUI) validateFileConfig(config FileConfig) error {
-	// Check required fields
-	if config.BaseURL == "" {
-		return fmt.Errorf("BaseURL is required")
-	}
-
-	if config.PagePattern == "" {
-		return fmt.Errorf("PagePattern is required")
-	}
-
-	if !strings.Contains(config.BaseURL, config.PagePattern) {
-		return fmt.Errorf("BaseURL must contain the PagePattern: %s", config.PagePattern)
-	}
-
-	if config.Selector == "" {
-		return fmt.Errorf("Selector is required")
-	}
-
-	if config.AttributeSelector == "" {
-		return fmt.Errorf("AttributeSelector is required")
-	}
-
-	if config.StartPage < 1 {
-		return fmt.Errorf("StartPage must be at least 1")
-	}
-
-	if config.EndPage < config.StartPage {
-		return fmt.Errorf("EndPage must be greater than or equal to StartPage")
-	}
-
-	if config.OutputFile == "" {
-		return fmt.Errorf("OutputFile is required")
-	}
-
-	// Validate AdvancedConfig
-	if config.AdvancedConfig.MaxConcurrent < 1 {
-		return fmt.Errorf("AdvancedConfig.MaxConcurrent must be at least 1")
-	}
-
-	if config.AdvancedConfig.MaxRetries < 0 {
-		return fmt.Errorf("AdvancedConfig.MaxRetries must be at least 0")
-	}
-
-	if config.AdvancedConfig.RetryDelay < 0 {
-		return fmt.Errorf("AdvancedConfig.RetryDelay must be at least 0")
-	}
-
-	if config.AdvancedConfig.RateLimit < 0 {
-		return fmt.Errorf("AdvancedConfig.RateLimit must be at least 0")
-	}
-
-	// Validate TwoPhaseCrawlConfig if TwoPhaseCrawl is enabled
-	if config.TwoPhaseCrawl && len(config.TwoPhaseCrawlConfig.Attributes) == 0 {
-		return fmt.Errorf("TwoPhaseCrawlConfig.Attributes is required when TwoPhaseCrawl is enabled")
-	}
-
-	// Validate each attribute in TwoPhaseCrawlConfig
-	for i, attr := range config.TwoPhaseCrawlConfig.Attributes {
-		if attr.Selector == "" {
-			return fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].Selector is required", i)
-		}
-
-		if attr.JsonAttribute == "" {
-			return fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].JsonAttribute is required", i)
-		}
-
-		// If GetElementContent is false, ElementAttribute is required
-		if !attr.GetElementContent && attr.ElementAttribute == "" {
-			return fmt.Errorf("TwoPhaseCrawlConfig.Attributes[%d].ElementAttribute is required when GetElementContent is false", i)
-		}
-	}
-
-	return nil
-}
-
 // updateUIFromConfig updates the UI fields from the loaded configuration
 func (g *WebCrawlerGUI) updateUIFromConfig() {
 	if !g.configLoaded {
@@ -290,6 +140,7 @@ func (g *WebCrawlerGUI) updateUIFromConfig() {
 	g.endPageEntry.SetText(fmt.Sprintf("%d", g.fileConfig.EndPage))
 	g.selectorEntry.SetText(g.fileConfig.Selector)
 	g.attrSelectorEntry.SetText(g.fileConfig.AttributeSelector)
+	g.contentSelectorEntry.SetText(g.fileConfig.ContentSelector)
 
 	// Update advanced settings
 	g.maxConcurrentEntry.SetText(fmt.Sprintf("%d", g.fileConfig.AdvancedConfig.MaxConcurrent))
@@ -322,78 +173,6 @@ func (g *WebCrawlerGUI) updateUIFromConfig() {
 	}
 }
 
-// fileConfigToCrawlConfig converts a FileConfig to a CrawlConfig
-func (g *WebCrawlerGUI) fileConfigToCrawlConfig() CrawlConfig {
-	return CrawlConfig{
-		BaseURL:           g.fileConfig.BaseURL,
-		StartPage:         g.fileConfig.StartPage,
-		EndPage:           g.fileConfig.EndPage,
-		PagePattern:       g.fileConfig.PagePattern,
-		Selector:          g.fileConfig.Selector,
-		AttributeSelector: g.fileConfig.AttributeSelector,
-		ContentSelector:   "", // Not in FileConfig
-		MaxConcurrent:     g.fileConfig.AdvancedConfig.MaxConcurrent,
-		MaxRetries:        g.fileConfig.AdvancedConfig.MaxRetries,
-		RetryDelay:        time.Duration(g.fileConfig.AdvancedConfig.RetryDelay) * time.Second,
-		RateLimit:         time.Duration(g.fileConfig.AdvancedConfig.RateLimit) * time.Millisecond,
-		OutputFile:        g.fileConfig.OutputFile,
-		TwoPhaseCrawl:     g.fileConfig.TwoPhaseCrawl,
-		FollowLinks:       g.fileConfig.FollowLinks,
-		MaxDepth:          g.fileConfig.AdvancedConfig.MaxDepth,
-		CustomFilters:     g.fileConfig.AdvancedConfig.CustomFilters,
-	}
-}
-
-// validateConfig validates the configuration and returns an error if invalid
-func (g *WebCrawlerGUI) validateConfig(config CrawlConfig) error {
-	// Check required fields
-	if config.BaseURL == "" {
-		return fmt.Errorf("base URL is required")
-	}
-
-	if config.PagePattern == "" {
-		return fmt.Errorf("page pattern is required")
-	}
-
-	if !strings.Contains(config.BaseURL, config.PagePattern) {
-		return fmt.Errorf("base URL must contain the page pattern: %s", config.PagePattern)
-	}
-
-	if config.Selector == "" {
-		return fmt.Errorf("selector is required")
-	}
-
-	if config.StartPage < 1 {
-		return fmt.Errorf("start page must be at least 1")
-	}
-
-	if config.EndPage < config.StartPage {
-		return fmt.Errorf("end page must be greater than or equal to start page")
-	}
-
-	if config.MaxConcurrent < 1 {
-		return fmt.Errorf("max concurrent requests must be at least 1")
-	}
-
-	if config.MaxRetries < 0 {
-		return fmt.Errorf("max retries must be at least 0")
-	}
-
-	if config.RetryDelay < 0 {
-		return fmt.Errorf("retry delay must be at least 0")
-	}
-
-	if config.RateLimit < 0 {
-		return fmt.Errorf("rate limit must be at least 0")
-	}
-
-	if config.OutputFile == "" {
-		return fmt.Errorf("output file is required")
-	}
-
-	return nil
-}
-
 // Run starts the GUI application
 func (g *WebCrawlerGUI) Run() {
 	g.window.ShowAndRun()
@@ -480,8 +259,11 @@ func (g *WebCrawlerGUI) createInputFields() {
 	g.followLinksCheck = widget.NewCheck("Follow links found in pages", nil)
 	g.followLinksCheck.SetChecked(false)
 
+	g.resumeCheck = widget.NewCheck("Resume from checkpoint (continue a stopped crawl)", nil)
+	g.resumeCheck.SetChecked(false)
+
 	// Template selector
-	g.templateSelect = widget.NewSelect([]string{"Custom", "News Site", "E-commerce", "Blog", "Forum"}, func(selected string) {
+	g.templateSelect = widget.NewSelect([]string{"Custom"}, func(selected string) {
 		if selected != "Custom" {
 			g.loadTemplate(selected)
 		}
@@ -622,6 +404,7 @@ func (g *WebCrawlerGUI) layoutUI() {
 			g.twoPhaseCrawlCheck,
 			g.followLinksCheck,
 		),
+		g.resumeCheck,
 	)
 
 	advancedSettings := container.NewVBox(
@@ -638,11 +421,11 @@ func (g *WebCrawlerGUI) layoutUI() {
 
 		container.NewGridWithColumns(2,
 			container.NewVBox(
-				widget.NewLabel("Retry Delay (seconds):"),
+				widget.NewLabel("Retry Backoff Base (seconds):"),
 				g.retryDelayEntry,
 			),
 			container.NewVBox(
-				widget.NewLabel("Rate Limit (ms):"),
+				widget.NewLabel("Rate Limit per host (ms):"),
 				g.rateLimitEntry,
 			),
 		),
@@ -707,20 +490,14 @@ func (g *WebCrawlerGUI) startCrawling() {
 		return
 	}
 
-	g.crawlInProgress = true
-	g.stopRequested = false
-	g.crawlResults = make([]CrawlItem, 0)
-
-	g.startButton.Disable()
-	g.stopButton.Enable()
 	g.clearOutput()
 
-	var config CrawlConfig
+	var config crawler.FileConfig
 
 	// Use loaded configuration if available, otherwise get from UI
 	if g.configLoaded {
 		g.appendOutput("Using configuration from file: " + g.configFile)
-		config = g.fileConfigToCrawlConfig()
+		config = g.fileConfig
 	} else {
 		// Check if default config exists
 		defaultConfigPath := "config.json"
@@ -730,7 +507,7 @@ func (g *WebCrawlerGUI) startCrawling() {
 				g.configFile = defaultConfigPath
 				g.configLoaded = true
 				g.appendOutput("Using default configuration from: " + defaultConfigPath)
-				config = g.fileConfigToCrawlConfig()
+				config = g.fileConfig
 
 				// Update UI to show loaded config
 				g.updateUIFromConfig()
@@ -745,22 +522,45 @@ func (g *WebCrawlerGUI) startCrawling() {
 		}
 	}
 
-	// Validate the configuration
-	if err := g.validateConfig(config); err != nil {
+	// Validate the configuration and prepare the crawler
+	c, err := crawler.New(config)
+	if err != nil {
 		g.appendOutput("Error: " + err.Error())
 		g.updateStatus("Configuration error")
-		g.crawlInProgress = false
-		g.stopButton.Disable()
-		g.startButton.Enable()
 		dialog.ShowError(fmt.Errorf("Configuration error: %v", err), g.window)
 		return
 	}
 
-	g.crawlConfig = config
+	if g.resumeCheck.Checked {
+		if err := c.Resume(); err != nil {
+			g.appendOutput("Error: " + err.Error())
+			dialog.ShowError(fmt.Errorf("Cannot resume: %v", err), g.window)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancelCrawl = cancel
+	g.crawlInProgress = true
+	g.startButton.Disable()
+	g.stopButton.Enable()
+
+	// Forward crawler events to the output area
+	go func() {
+		for ev := range c.Events() {
+			switch ev.Type {
+			case crawler.EventLog:
+				g.appendOutput(ev.Message)
+			case crawler.EventProgress:
+				g.updateProgress(ev.Progress)
+			}
+		}
+	}()
 
 	// Start crawling in a goroutine
 	go func() {
 		defer func() {
+			cancel()
 			g.crawlInProgress = false
 			g.stopButton.Disable()
 			g.startButton.Enable()
@@ -768,22 +568,14 @@ func (g *WebCrawlerGUI) startCrawling() {
 		}()
 
 		g.updateStatus("Crawling started...")
-
-		if config.TwoPhaseCrawl {
-			g.twoPhaseWebCrawl(config)
-		} else {
-			g.singlePhaseWebCrawl(config)
-		}
-
-		// Save results
-		g.saveResults(config.OutputFile)
+		c.Run(ctx)
 	}()
 }
 
 // stopCrawling stops the ongoing crawling process
 func (g *WebCrawlerGUI) stopCrawling() {
-	if g.crawlInProgress {
-		g.stopRequested = true
+	if g.crawlInProgress && g.cancelCrawl != nil {
+		g.cancelCrawl()
 		g.updateStatus("Stopping crawl...")
 	}
 }
@@ -812,7 +604,7 @@ func (g *WebCrawlerGUI) updateProgress(value float64) {
 }
 
 // getConfigFromUI extracts the crawler configuration from UI inputs
-func (g *WebCrawlerGUI) getConfigFromUI() CrawlConfig {
+func (g *WebCrawlerGUI) getConfigFromUI() crawler.FileConfig {
 	startPage := 1
 	fmt.Sscanf(g.startPageEntry.Text, "%d", &startPage)
 
@@ -840,573 +632,45 @@ func (g *WebCrawlerGUI) getConfigFromUI() CrawlConfig {
 		customFilters = strings.Split(g.customFiltersEntry.Text, "\n")
 	}
 
-	return CrawlConfig{
-		BaseURL:           g.urlEntry.Text,
-		StartPage:         startPage,
-		EndPage:           endPage,
-		PagePattern:       g.pagePatternEntry.Text,
-		Selector:          g.selectorEntry.Text,
-		AttributeSelector: g.attrSelectorEntry.Text,
-		ContentSelector:   g.contentSelectorEntry.Text,
-		MaxConcurrent:     maxConcurrent,
-		MaxRetries:        maxRetries,
-		RetryDelay:        time.Duration(retryDelay) * time.Second,
-		RateLimit:         time.Duration(rateLimit) * time.Millisecond,
-		OutputFile:        g.outputFileEntry.Text,
-		TwoPhaseCrawl:     g.twoPhaseCrawlCheck.Checked,
-		FollowLinks:       g.followLinksCheck.Checked,
-		MaxDepth:          maxDepth,
-		CustomFilters:     customFilters,
-	}
-}
-
-// singlePhaseWebCrawl performs a one-time crawl without following links
-func (g *WebCrawlerGUI) singlePhaseWebCrawl(config CrawlConfig) {
-	// Control concurrency with a semaphore
-	semaphore := make(chan struct{}, config.MaxConcurrent)
-
-	// Create a custom HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Rate limiter to avoid overloading the server
-	rl := time.Tick(config.RateLimit)
-
-	// Track results for reporting
-	var (
-		mutex      sync.Mutex
-		successful int
-		failed     int
-	)
-
-	// Wait group to track completion
-	var wg sync.WaitGroup
-
-	// Function to fetch a single page with retries
-	fetchPage := func(pageNum int) {
-		defer func() {
-			wg.Done()
-			<-semaphore // Release the semaphore slot
-		}()
-
-		if g.stopRequested {
-			return
-		}
-
-		pageUrl := strings.Replace(config.BaseURL, config.PagePattern, fmt.Sprintf("%d", pageNum), 1)
-
-		// Implement retry logic
-		var doc *html.Node
-		success := false
-
-		for attempt := 0; attempt < config.MaxRetries; attempt++ {
-			if g.stopRequested {
-				return
-			}
-
-			if attempt > 0 {
-				g.appendOutput(fmt.Sprintf("Retrying page %d (attempt %d/%d)...", pageNum, attempt+1, config.MaxRetries))
-				time.Sleep(config.RetryDelay * time.Duration(attempt)) // Exponential backoff
-			}
-
-			<-rl // Rate limiting
-			g.appendOutput(fmt.Sprintf("Fetching page %d: %s", pageNum, pageUrl))
-
-			resp, err := client.Get(pageUrl)
-			if err != nil {
-				g.appendOutput(fmt.Sprintf("Error fetching page %d (attempt %d/%d): %v",
-					pageNum, attempt+1, config.MaxRetries, err))
-				continue // Try again
-			}
-
-			// Use a safe way to close the body
-			func() {
-				defer resp.Body.Close()
-
-				// Check for non-successful status code
-				if resp.StatusCode != http.StatusOK {
-					g.appendOutput(fmt.Sprintf("Error on page %d (attempt %d/%d): status code %d",
-						pageNum, attempt+1, config.MaxRetries, resp.StatusCode))
-					return
-				}
-
-				// Try to parse the HTML
-				var parseErr error
-				doc, parseErr = html.Parse(resp.Body)
-				if parseErr != nil {
-					g.appendOutput(fmt.Sprintf("Error parsing page %d (attempt %d/%d): %v",
-						pageNum, attempt+1, config.MaxRetries, parseErr))
-					return
-				}
-
-				// If we reach here, we succeeded
-				success = true
-			}()
-
-			if success {
-				break // Exit retry loop on success
-			}
-		}
-
-		// If we still couldn't fetch the page after all retries
-		if !success {
-			g.appendOutput(fmt.Sprintf("Failed to fetch page %d after %d attempts", pageNum, config.MaxRetries))
-			mutex.Lock()
-			failed++
-			mutex.Unlock()
-			return
-		}
-
-		// Process the HTML document
-		var f func(*html.Node)
-		elementCount := 0
-		f = func(n *html.Node) {
-			if g.stopRequested {
-				return
-			}
-
-			if n.Type == html.ElementNode && n.Data == config.Selector {
-				elementCount++
-
-				item := CrawlItem{
-					URL:        pageUrl,
-					Timestamp:  time.Now(),
-					Attributes: make(map[string]string),
-					Content:    make([]string, 0),
-					Links:      make([]string, 0),
-				}
-
-				// Extract title if available
-				if n.FirstChild != nil {
-					item.Title = strings.TrimSpace(extractTextContent(n))
-				}
-
-				// Extract attributes
-				for _, attr := range n.Attr {
-					item.Attributes[attr.Key] = attr.Val
-
-					// If this is the attribute we're looking for
-					if attr.Key == config.AttributeSelector {
-						// If it's a link, add it to links
-						if attr.Key == "href" || attr.Key == "src" {
-							item.Links = append(item.Links, attr.Val)
-						}
-					}
-				}
-
-				// Extract content from specified selector
-				if config.ContentSelector != "" {
-					extractContent(n, config.ContentSelector, &item)
-				}
-
-				// Apply custom filters if any
-				if len(config.CustomFilters) > 0 {
-					for _, filter := range config.CustomFilters {
-						re, err := regexp.Compile(filter)
-						if err == nil {
-							for i, content := range item.Content {
-								if re.MatchString(content) {
-									item.Content[i] = re.ReplaceAllString(content, "")
-								}
-							}
-						}
-					}
-				}
-
-				mutex.Lock()
-				g.crawlResults = append(g.crawlResults, item)
-				mutex.Unlock()
-
-				g.appendOutput(fmt.Sprintf("Found item: %s", item.Title))
-			}
-
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				f(c)
-			}
-		}
-
-		f(doc)
-
-		g.appendOutput(fmt.Sprintf("Successfully processed page %d, found %d %s elements",
-			pageNum, elementCount, config.Selector))
-
-		mutex.Lock()
-		successful++
-		mutex.Unlock()
-
-		// Update progress
-		progress := float64(pageNum-config.StartPage+1) / float64(config.EndPage-config.StartPage+1)
-		g.updateProgress(progress)
-	}
-
-	// Start crawling pages
-	startTime := time.Now()
-
-	for page := config.StartPage; page <= config.EndPage; page++ {
-		if g.stopRequested {
-			break
-		}
-
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire a semaphore slot
-		go fetchPage(page)
-	}
-
-	// Wait for all pages to be processed
-	wg.Wait()
-
-	// Report results
-	duration := time.Since(startTime)
-	totalPages := config.EndPage - config.StartPage + 1
-	g.appendOutput("\nCrawling summary:")
-	g.appendOutput(fmt.Sprintf("Total pages attempted: %d", totalPages))
-	g.appendOutput(fmt.Sprintf("Successfully crawled: %d (%.1f%%)", successful, float64(successful)/float64(totalPages)*100))
-	g.appendOutput(fmt.Sprintf("Failed pages: %d (%.1f%%)", failed, float64(failed)/float64(totalPages)*100))
-	g.appendOutput(fmt.Sprintf("Total time: %s", duration))
-	g.appendOutput(fmt.Sprintf("Total items found: %d", len(g.crawlResults)))
-}
-
-// twoPhaseWebCrawl performs a two-phase crawl: first get all links, then crawl each link
-func (g *WebCrawlerGUI) twoPhaseWebCrawl(config CrawlConfig) {
-	// Phase 1: Get all links
-	g.appendOutput("Phase 1: Collecting links...")
-
-	links := make([]string, 0)
-	var linksMutex sync.Mutex
-
-	// Control concurrency with a semaphore
-	semaphore := make(chan struct{}, config.MaxConcurrent)
-
-	// Create a custom HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Rate limiter to avoid overloading the server
-	rl := time.Tick(config.RateLimit)
-
-	// Wait group to track completion
-	var wg sync.WaitGroup
-
-	// Function to fetch links from a single page
-	fetchLinks := func(pageNum int) {
-		defer func() {
-			wg.Done()
-			<-semaphore // Release the semaphore slot
-		}()
-
-		if g.stopRequested {
-			return
-		}
-
-		pageUrl := strings.Replace(config.BaseURL, config.PagePattern, fmt.Sprintf("%d", pageNum), 1)
-
-		// Implement retry logic
-		var doc *html.Node
-		success := false
-
-		for attempt := 0; attempt < config.MaxRetries; attempt++ {
-			if g.stopRequested {
-				return
-			}
-
-			if attempt > 0 {
-				time.Sleep(config.RetryDelay * time.Duration(attempt)) // Exponential backoff
-			}
-
-			<-rl // Rate limiting
-
-			resp, err := client.Get(pageUrl)
-			if err != nil {
-				continue // Try again
-			}
-
-			// Use a safe way to close the body
-			func() {
-				defer resp.Body.Close()
-
-				// Check for non-successful status code
-				if resp.StatusCode != http.StatusOK {
-					return
-				}
-
-				// Try to parse the HTML
-				var parseErr error
-				doc, parseErr = html.Parse(resp.Body)
-				if parseErr != nil {
-					return
-				}
-
-				// If we reach here, we succeeded
-				success = true
-			}()
-
-			if success {
-				break // Exit retry loop on success
-			}
-		}
-
-		// If we still couldn't fetch the page after all retries
-		if !success {
-			return
-		}
-
-		// Process the HTML document to find links
-		var f func(*html.Node)
-		pageLinks := make([]string, 0)
-
-		f = func(n *html.Node) {
-			if g.stopRequested {
-				return
-			}
-
-			if n.Type == html.ElementNode && n.Data == config.Selector {
-				for _, attr := range n.Attr {
-					if attr.Key == config.AttributeSelector {
-						pageLinks = append(pageLinks, attr.Val)
-						break
-					}
-				}
-			}
-
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				f(c)
-			}
-		}
-
-		f(doc)
-
-		// Add found links to the global list
-		if len(pageLinks) > 0 {
-			linksMutex.Lock()
-			links = append(links, pageLinks...)
-			linksMutex.Unlock()
-
-			g.appendOutput(fmt.Sprintf("Found %d links on page %d", len(pageLinks), pageNum))
-		}
-
-		// Update progress for phase 1
-		progress := float64(pageNum-config.StartPage+1) / float64(config.EndPage-config.StartPage+1) * 0.5
-		g.updateProgress(progress)
-	}
-
-	// Start collecting links
-	for page := config.StartPage; page <= config.EndPage; page++ {
-		if g.stopRequested {
-			break
-		}
-
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire a semaphore slot
-		go fetchLinks(page)
-	}
-
-	// Wait for all link collection to complete
-	wg.Wait()
-
-	if g.stopRequested {
-		g.appendOutput("Crawling stopped during phase 1")
-		return
-	}
-
-	// Phase 2: Crawl each link
-	g.appendOutput(fmt.Sprintf("\nPhase 2: Crawling %d individual links...", len(links)))
-
-	// Reset wait group and progress
-	wg = sync.WaitGroup{}
-
-	// Function to crawl a single link
-	crawlLink := func(url string, index int) {
-		defer func() {
-			wg.Done()
-			<-semaphore // Release the semaphore slot
-		}()
-
-		if g.stopRequested {
-			return
-		}
-
-		// Implement retry logic
-		var doc *html.Node
-		success := false
-
-		for attempt := 0; attempt < config.MaxRetries; attempt++ {
-			if g.stopRequested {
-				return
-			}
-
-			if attempt > 0 {
-				time.Sleep(config.RetryDelay * time.Duration(attempt)) // Exponential backoff
-			}
-
-			<-rl // Rate limiting
-
-			resp, err := client.Get(url)
-			if err != nil {
-				continue // Try again
-			}
-
-			// Use a safe way to close the body
-			func() {
-				defer resp.Body.Close()
-
-				// Check for non-successful status code
-				if resp.StatusCode != http.StatusOK {
-					return
-				}
-
-				// Try to parse the HTML
-				var parseErr error
-				doc, parseErr = html.Parse(resp.Body)
-				if parseErr != nil {
-					return
-				}
-
-				// If we reach here, we succeeded
-				success = true
-			}()
-
-			if success {
-				break // Exit retry loop on success
-			}
-		}
-
-		// If we still couldn't fetch the page after all retries
-		if !success {
-			return
-		}
-
-		// Create a new crawl item
-		item := CrawlItem{
-			URL:        url,
-			Timestamp:  time.Now(),
-			Attributes: make(map[string]string),
-			Content:    make([]string, 0),
-			Links:      make([]string, 0),
-		}
-
-		// Extract title
-		var title string
-		var f func(*html.Node)
-		f = func(n *html.Node) {
-			if n.Type == html.ElementNode && n.Data == "title" {
-				title = extractTextContent(n)
-				return
-			}
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				f(c)
-			}
-		}
-		f(doc)
-		item.Title = strings.TrimSpace(title)
-
-		// Extract content based on content selector
-		if config.ContentSelector != "" {
-			var extractContentFunc func(*html.Node)
-			extractContentFunc = func(n *html.Node) {
-				if n.Type == html.ElementNode && hasClass(n, config.ContentSelector) {
-					content := strings.TrimSpace(extractTextContent(n))
-					if content != "" {
-						item.Content = append(item.Content, content)
-					}
-				}
-
-				for c := n.FirstChild; c != nil; c = c.NextSibling {
-					extractContentFunc(c)
-				}
-			}
-			extractContentFunc(doc)
-		}
-
-		// Apply custom filters if any
-		if len(config.CustomFilters) > 0 {
-			for _, filter := range config.CustomFilters {
-				re, err := regexp.Compile(filter)
-				if err == nil {
-					for i, content := range item.Content {
-						if re.MatchString(content) {
-							item.Content[i] = re.ReplaceAllString(content, "")
-						}
-					}
-				}
-			}
-		}
-
-		// Add to results
-		linksMutex.Lock()
-		g.crawlResults = append(g.crawlResults, item)
-		linksMutex.Unlock()
-
-		g.appendOutput(fmt.Sprintf("Crawled: %s", item.Title))
-
-		// Update progress for phase 2
-		progress := 0.5 + (float64(index+1) / float64(len(links)) * 0.5)
-		g.updateProgress(progress)
-	}
-
-	// Start crawling individual links
-	for i, link := range links {
-		if g.stopRequested {
-			break
-		}
-
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire a semaphore slot
-		go crawlLink(link, i)
-	}
-
-	// Wait for all crawling to complete
-	wg.Wait()
-
-	g.appendOutput(fmt.Sprintf("\nCrawling completed. Found %d items.", len(g.crawlResults)))
-}
-
-// saveResults saves the crawl results to a JSON file
-func (g *WebCrawlerGUI) saveResults(filename string) {
-	if len(g.crawlResults) == 0 {
-		g.appendOutput("No results to save.")
+	// Settings without a field in the UI come from the loaded config file
+	config := g.fileConfig
+	config.BaseURL = g.urlEntry.Text
+	config.StartPage = startPage
+	config.EndPage = endPage
+	config.PagePattern = g.pagePatternEntry.Text
+	config.Selector = g.selectorEntry.Text
+	config.AttributeSelector = g.attrSelectorEntry.Text
+	config.ContentSelector = g.contentSelectorEntry.Text
+	config.AdvancedConfig.MaxConcurrent = maxConcurrent
+	config.AdvancedConfig.MaxRetries = maxRetries
+	config.AdvancedConfig.RetryDelay = retryDelay
+	config.AdvancedConfig.RateLimit = rateLimit
+	config.AdvancedConfig.MaxDepth = maxDepth
+	config.AdvancedConfig.CustomFilters = customFilters
+	config.OutputFile = g.outputFileEntry.Text
+	config.TwoPhaseCrawl = g.twoPhaseCrawlCheck.Checked
+	config.FollowLinks = g.followLinksCheck.Checked
+	return config
+}
+
+// previewURL fetches the first listing page and shows what each selector of
+// the configuration matches on it and which items would be extracted
+func (g *WebCrawlerGUI) previewURL() {
+	if g.urlEntry.Text == "" {
+		dialog.ShowInformation("Error", "Please enter a URL to preview", g.window)
 		return
 	}
 
-	// Create output directory if it doesn't exist
-	dir := filepath.Dir(filename)
-	if dir != "." && dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			g.appendOutput(fmt.Sprintf("Error creating directory: %v", err))
-			return
-		}
-	}
-
-	// Convert results to JSON
-	data, err := json.MarshalIndent(g.crawlResults, "", "  ")
-	if err != nil {
-		g.appendOutput(fmt.Sprintf("Error marshalling data to JSON: %v", err))
-		return
+	config := g.getConfigFromUI()
+	if g.configLoaded {
+		config = g.fileConfig
 	}
-
-	// Write to file
-	err = os.WriteFile(filename, data, 0644)
+	c, err := crawler.New(config)
 	if err != nil {
-		g.appendOutput(fmt.Sprintf("Error writing JSON to file: %v", err))
-		return
-	}
-
-	g.appendOutput(fmt.Sprintf("Data saved to %s", filename))
-}
-
-// previewURL fetches and displays a preview of the URL
-func (g *WebCrawlerGUI) previewURL() {
-	if g.urlEntry.Text == "" {
-		dialog.ShowInformation("Error", "Please enter a URL to preview", g.window)
+		dialog.ShowError(fmt.Errorf("Configuration error: %v", err), g.window)
 		return
 	}
-
-	// Replace page pattern with the start page
-	startPage := 1
-	fmt.Sscanf(g.startPageEntry.Text, "%d", &startPage)
-
-	url := strings.Replace(g.urlEntry.Text, g.pagePatternEntry.Text, fmt.Sprintf("%d", startPage), 1)
+	url := config.StartURLs()[0]
 
 	// Show a loading dialog
 	loadingDialog := dialog.NewInformation("Loading", "Fetching URL preview...", g.window)
@@ -1414,11 +678,9 @@ func (g *WebCrawlerGUI) previewURL() {
 
 	// Fetch the URL in a goroutine
 	go func() {
-		client := &http.Client{
-			Timeout: 10 * time.Second,
-		}
-
-		resp, err := client.Get(url)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		report, err := c.Preview(ctx, url, crawler.PreviewOptions{})
 
 		// Hide the loading dialog when we're done
 		loadingDialog.Hide()
@@ -1427,97 +689,17 @@ func (g *WebCrawlerGUI) previewURL() {
 			dialog.ShowInformation("Error", fmt.Sprintf("Error fetching URL: %v", err), g.window)
 			return
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			dialog.ShowInformation("Error", fmt.Sprintf("Error: Status code %d", resp.StatusCode), g.window)
-			return
-		}
-
-		doc, err := html.Parse(resp.Body)
-		if err != nil {
-			dialog.ShowInformation("Error", fmt.Sprintf("Error parsing HTML: %v", err), g.window)
-			return
-		}
-
-		// Extract title
-		var title string
-		var f func(*html.Node)
-		f = func(n *html.Node) {
-			if n.Type == html.ElementNode && n.Data == "title" {
-				title = extractTextContent(n)
-				return
-			}
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				f(c)
-			}
-		}
-		f(doc)
-
-		// Extract elements matching the selector
-		var elements []string
-		if g.selectorEntry.Text != "" {
-			var findElements func(*html.Node)
-			findElements = func(n *html.Node) {
-				if n.Type == html.ElementNode && n.Data == g.selectorEntry.Text {
-					elements = append(elements, extractTextContent(n))
-				}
-				for c := n.FirstChild; c != nil; c = c.NextSibling {
-					findElements(c)
-				}
-			}
-			findElements(doc)
-		}
+		var text strings.Builder
+		report.WriteText(&text)
 
 		// Create a new window to show the preview
 		previewWindow := g.app.NewWindow("URL Preview")
 
-		// Create content for the preview window
-		titleLabel := widget.NewLabel("Title: " + title)
-		urlLabel := widget.NewLabel("URL: " + url)
-
-		var content *fyne.Container
-
-		if len(elements) > 0 {
-			elementsLabel := widget.NewLabel(fmt.Sprintf("Found %d matching elements:", len(elements)))
-
-			// Create a list of elements
-			elementsList := widget.NewList(
-				func() int {
-					return len(elements)
-				},
-				func() fyne.CanvasObject {
-					return widget.NewLabel("Template")
-				},
-				func(id widget.ListItemID, obj fyne.CanvasObject) {
-					label := obj.(*widget.Label)
-					if id < len(elements) {
-						text := elements[id]
-						if len(text) > 100 {
-							text = text[:97] + "..."
-						}
-						label.SetText(text)
-					}
-				},
-			)
-
-			// Add the list to a scroll container
-			elementScroll := container.NewScroll(elementsList)
-			elementScroll.SetMinSize(fyne.NewSize(500, 300))
-
-			content = container.NewVBox(
-				titleLabel,
-				urlLabel,
-				elementsLabel,
-				elementScroll,
-			)
-		} else {
-			content = container.NewVBox(
-				titleLabel,
-				urlLabel,
-				widget.NewLabel("No matching elements found."),
-			)
-		}
+		reportArea := widget.NewMultiLineEntry()
+		reportArea.SetText(text.String())
+		reportArea.TextStyle = fyne.TextStyle{Monospace: true}
+		reportArea.Wrapping = fyne.TextWrapOff
 
 		// Add a close button
 		closeButton := widget.NewButton("Close", func() {
@@ -1531,206 +713,188 @@ func (g *WebCrawlerGUI) previewURL() {
 				container.NewHBox(layout.NewSpacer(), closeButton, layout.NewSpacer()),
 				nil,
 				nil,
-				content,
+				reportArea,
 			),
 		)
 
 		// Set window size and show it
-		previewWindow.Resize(fyne.NewSize(600, 400))
+		previewWindow.Resize(fyne.NewSize(800, 600))
 		previewWindow.Show()
 	}()
 }
 
-// saveCurrentConfig saves the current configuration as a template
+// saveCurrentConfig saves the current configuration as a template file
 func (g *WebCrawlerGUI) saveCurrentConfig() {
 	// Create a dialog to get the template name
 	nameEntry := widget.NewEntry()
 	nameEntry.SetPlaceHolder("Template Name")
+	descriptionEntry := widget.NewEntry()
+	descriptionEntry.SetPlaceHolder("Description (optional)")
 
 	dialog.ShowCustomConfirm("Save Template", "Save", "Cancel",
 		container.NewVBox(
 			widget.NewLabel("Enter a name for this template:"),
 			nameEntry,
+			descriptionEntry,
+			widget.NewLabel("Saved to "+g.templateDir),
 		),
 		func(save bool) {
 			if save && nameEntry.Text != "" {
-				config := g.getConfigFromUI()
-				g.templates[nameEntry.Text] = config
-
-				// Update template selector
-				templateNames := make([]string, 0, len(g.templates)+1)
-				templateNames = append(templateNames, "Custom")
-				for name := range g.templates {
-					templateNames = append(templateNames, name)
+				t := crawler.Template{
+					Name:        nameEntry.Text,
+					Description: descriptionEntry.Text,
+					Config:      g.getConfigFromUI(),
+				}
+				if existing, ok := g.templates[t.Name]; ok && existing.Path != "" {
+					t.Path = existing.Path
+				}
+				// Saving the template being edited keeps its variables
+				var dropped []string
+				if g.template != nil && strings.EqualFold(g.template.Name, t.Name) {
+					t.Config, t.Variables, dropped = g.templateEdits(*g.template)
 				}
-				g.templateSelect.Options = templateNames
 
-				dialog.ShowInformation("Template Saved",
-					fmt.Sprintf("Template '%s' has been saved.", nameEntry.Text),
-					g.window)
+				file, err := crawler.SaveTemplate(g.templateDir, t)
+				if err != nil {
+					dialog.ShowError(err, g.window)
+					return
+				}
+				g.loadTemplates()
+
+				msg := fmt.Sprintf("Template '%s' has been saved to %s.", t.Name, file)
+				if len(dropped) > 0 {
+					msg += fmt.Sprintf("\n\nThe edited fields replaced the variables %s, which were removed.", strings.Join(dropped, ", "))
+				}
+				dialog.ShowInformation("Template Saved", msg, g.window)
 			}
 		},
 		g.window,
 	)
 }
 
-// loadTemplate loads a saved template
-func (g *WebCrawlerGUI) loadTemplate(name string) {
-	if config, ok := g.templates[name]; ok {
-		// Update UI with template values
-		g.urlEntry.SetText(config.BaseURL)
-		g.pagePatternEntry.SetText(config.PagePattern)
-		g.startPageEntry.SetText(fmt.Sprintf("%d", config.StartPage))
-		g.endPageEntry.SetText(fmt.Sprintf("%d", config.EndPage))
-		g.selectorEntry.SetText(config.Selector)
-		g.attrSelectorEntry.SetText(config.AttributeSelector)
-		g.contentSelectorEntry.SetText(config.ContentSelector)
-		g.maxConcurrentEntry.SetText(fmt.Sprintf("%d", config.MaxConcurrent))
-		g.maxRetriesEntry.SetText(fmt.Sprintf("%d", config.MaxRetries))
-		g.retryDelayEntry.SetText(fmt.Sprintf("%d", int(config.RetryDelay.Seconds())))
-		g.rateLimitEntry.SetText(fmt.Sprintf("%d", int(config.RateLimit.Milliseconds())))
-		g.outputFileEntry.SetText(config.OutputFile)
-		g.maxDepthEntry.SetText(fmt.Sprintf("%d", config.MaxDepth))
-		g.twoPhaseCrawlCheck.SetChecked(config.TwoPhaseCrawl)
-		g.followLinksCheck.SetChecked(config.FollowLinks)
-
-		// Set custom filters
-		if len(config.CustomFilters) > 0 {
-			g.customFiltersEntry.SetText(strings.Join(config.CustomFilters, "\n"))
+// templateEdits applies the fields edited in the UI to the config of t,
+// which still has its variables. Fields left as they were instantiated keep
+// their placeholders; variables no longer used by any field are dropped.
+func (g *WebCrawlerGUI) templateEdits(t crawler.Template) (crawler.FileConfig, []crawler.TemplateVariable, []string) {
+	ui, loaded, config := g.getConfigFromUI(), g.fileConfig, t.Config
+	if ui.BaseURL != loaded.BaseURL {
+		config.BaseURL = ui.BaseURL
+	}
+	if ui.PagePattern != loaded.PagePattern {
+		config.PagePattern = ui.PagePattern
+	}
+	if ui.Selector != loaded.Selector {
+		config.Selector = ui.Selector
+	}
+	if ui.AttributeSelector != loaded.AttributeSelector {
+		config.AttributeSelector = ui.AttributeSelector
+	}
+	if ui.ContentSelector != loaded.ContentSelector {
+		config.ContentSelector = ui.ContentSelector
+	}
+	if ui.OutputFile != loaded.OutputFile {
+		config.OutputFile = ui.OutputFile
+	}
+	if strings.Join(ui.AdvancedConfig.CustomFilters, "\n") != strings.Join(loaded.AdvancedConfig.CustomFilters, "\n") {
+		config.AdvancedConfig.CustomFilters = ui.AdvancedConfig.CustomFilters
+	}
+	// Numbers and switches cannot hold placeholders
+	config.StartPage = ui.StartPage
+	config.EndPage = ui.EndPage
+	config.AdvancedConfig.MaxConcurrent = ui.AdvancedConfig.MaxConcurrent
+	config.AdvancedConfig.MaxRetries = ui.AdvancedConfig.MaxRetries
+	config.AdvancedConfig.RetryDelay = ui.AdvancedConfig.RetryDelay
+	config.AdvancedConfig.RateLimit = ui.AdvancedConfig.RateLimit
+	config.AdvancedConfig.MaxDepth = ui.AdvancedConfig.MaxDepth
+	config.TwoPhaseCrawl = ui.TwoPhaseCrawl
+	config.FollowLinks = ui.FollowLinks
+
+	data, _ := json.Marshal(config)
+	var variables []crawler.TemplateVariable
+	var dropped []string
+	for _, v := range t.Variables {
+		if strings.Contains(string(data), "{"+v.Name+"}") {
+			variables = append(variables, v)
 		} else {
-			g.customFiltersEntry.SetText("")
+			dropped = append(dropped, v.Name)
 		}
 	}
+	return config, variables, dropped
 }
 
-// loadTemplates loads predefined templates
-func (g *WebCrawlerGUI) loadTemplates() {
-	// News site template
-	g.templates["News Site"] = CrawlConfig{
-		BaseURL:           "https://example.com/news/page/{page}",
-		StartPage:         1,
-		EndPage:           10,
-		PagePattern:       "{page}",
-		Selector:          "article",
-		AttributeSelector: "href",
-		ContentSelector:   "div.content",
-		MaxConcurrent:     10,
-		MaxRetries:        3,
-		RetryDelay:        2 * time.Second,
-		RateLimit:         200 * time.Millisecond,
-		OutputFile:        "news_articles.json",
-		TwoPhaseCrawl:     true,
-		FollowLinks:       false,
-		MaxDepth:          1,
-		CustomFilters:     []string{"\\d+\\s+comments", "advertisement"},
+// loadTemplate instantiates a template, asking for its variables first, and
+// uses the result as the current configuration
+func (g *WebCrawlerGUI) loadTemplate(name string) {
+	t, ok := g.templates[name]
+	if !ok {
+		return
 	}
 
-	// E-commerce template
-	g.templates["E-commerce"] = CrawlConfig{
-		BaseURL:           "https://example.com/products/page/{page}",
-		StartPage:         1,
-		EndPage:           10,
-		PagePattern:       "{page}",
-		Selector:          "div.product",
-		AttributeSelector: "href",
-		ContentSelector:   "div.description",
-		MaxConcurrent:     10,
-		MaxRetries:        3,
-		RetryDelay:        2 * time.Second,
-		RateLimit:         300 * time.Millisecond,
-		OutputFile:        "products.json",
-		TwoPhaseCrawl:     true,
-		FollowLinks:       false,
-		MaxDepth:          1,
-		CustomFilters:     []string{"Out of stock", "\\$\\d+\\.\\d+"},
+	apply := func(values map[string]string) {
+		config, err := t.Instantiate(values)
+		if err != nil {
+			dialog.ShowError(err, g.window)
+			return
+		}
+		g.fileConfig = config
+		g.template = &t
+		g.configFile = "template " + t.Name
+		g.configLoaded = true
+		g.updateUIFromConfig()
 	}
-
-	// Blog template
-	g.templates["Blog"] = CrawlConfig{
-		BaseURL:           "https://example.com/blog/page/{page}",
-		StartPage:         1,
-		EndPage:           5,
-		PagePattern:       "{page}",
-		Selector:          "article",
-		AttributeSelector: "href",
-		ContentSelector:   "div.post-content",
-		MaxConcurrent:     5,
-		MaxRetries:        3,
-		RetryDelay:        2 * time.Second,
-		RateLimit:         500 * time.Millisecond,
-		OutputFile:        "blog_posts.json",
-		TwoPhaseCrawl:     true,
-		FollowLinks:       false,
-		MaxDepth:          1,
-		CustomFilters:     []string{"Posted by", "\\d+ comments"},
+	if len(t.Variables) == 0 {
+		apply(nil)
+		return
 	}
 
-	// Forum template
-	g.templates["Forum"] = CrawlConfig{
-		BaseURL:           "https://example.com/forum/page/{page}",
-		StartPage:         1,
-		EndPage:           10,
-		PagePattern:       "{page}",
-		Selector:          "div.thread",
-		AttributeSelector: "href",
-		ContentSelector:   "div.post-content",
-		MaxConcurrent:     8,
-		MaxRetries:        3,
-		RetryDelay:        2 * time.Second,
-		RateLimit:         400 * time.Millisecond,
-		OutputFile:        "forum_threads.json",
-		TwoPhaseCrawl:     true,
-		FollowLinks:       true,
-		MaxDepth:          2,
-		CustomFilters:     []string{"Posted by", "\\d+ replies", "\\d+ views"},
+	entries := make(map[string]*widget.Entry, len(t.Variables))
+	items := make([]*widget.FormItem, 0, len(t.Variables))
+	for _, v := range t.Variables {
+		entry := widget.NewEntry()
+		entry.SetText(v.Default)
+		entries[v.Name] = entry
+		item := widget.NewFormItem(v.Name, entry)
+		item.HintText = v.Description
+		items = append(items, item)
 	}
-}
-
-// extractContent extracts content from nodes matching the given selector
-func extractContent(n *html.Node, selector string, item *CrawlItem) {
-	var traverse func(*html.Node)
-	traverse = func(node *html.Node) {
-		if node.Type == html.ElementNode && hasClass(node, selector) {
-			content := strings.TrimSpace(extractTextContent(node))
-			if content != "" {
-				item.Content = append(item.Content, content)
-			}
+	dialog.ShowForm("Template: "+t.Name, "Use", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
 		}
-
-		for c := node.FirstChild; c != nil; c = c.NextSibling {
-			traverse(c)
+		values := make(map[string]string, len(entries))
+		for name, entry := range entries {
+			values[name] = entry.Text
 		}
-	}
-
-	traverse(n)
+		apply(values)
+	}, g.window)
 }
 
-// extractTextContent extracts all text content from a node and its children
-func extractTextContent(n *html.Node) string {
-	if n.Type == html.TextNode {
-		return n.Data
+// loadTemplates loads the built-in templates and those saved in the user's
+// template directory
+func (g *WebCrawlerGUI) loadTemplates() {
+	if g.templateDir == "" {
+		dir, err := crawler.DefaultTemplateDir()
+		if err != nil {
+			g.appendOutput("Warning: user templates are unavailable: " + err.Error())
+		}
+		g.templateDir = dir
 	}
 
-	var result string
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		result += extractTextContent(c)
+	templates, err := crawler.LoadTemplates(g.templateDir)
+	if err != nil {
+		g.appendOutput("Warning: failed to load templates: " + err.Error())
+		return
 	}
-	return result
-}
 
-// hasClass checks if an HTML element has a specific class
-func hasClass(n *html.Node, className string) bool {
-	for _, attr := range n.Attr {
-		if attr.Key == "class" {
-			classes := strings.Fields(attr.Val)
-			for _, class := range classes {
-				if class == className {
-					return true
-				}
-			}
-		}
+	g.templates = make(map[string]crawler.Template, len(templates))
+	templateNames := make([]string, 0, len(templates)+1)
+	templateNames = append(templateNames, "Custom")
+	for _, t := range templates {
+		g.templates[t.Name] = t
+		templateNames = append(templateNames, t.Name)
 	}
-	return false
+	g.templateSelect.Options = templateNames
+	g.templateSelect.Refresh()
 }
 
 func main() {
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -15,29 +16,38 @@ import (
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/protoparse"
 	"github.com/jhump/protoreflect/dynamic"
-	"golang.org/x/sys/windows/registry"
 )
 
-const (
-	DefaultWSPort = "54675"
-	RegistryKey   = `SOFTWARE\WOW6432Node\OPSWAT\MD4M`
-	RegistryValue = "ws_port"
-)
+const DefaultWSPort = "54675"
 
 type Backend struct {
 	Conn        *websocket.Conn
 	Server      *http.Server
 	LogFunc     func(string)
+	FrameFunc   func(Frame)
+	ConnectFunc func(remoteAddr string)
 	ProtoFolder string
 	FileDescs   map[string]*desc.FileDescriptor
 	mu          sync.Mutex
 	upgrader    websocket.Upgrader
+	payloadDesc *desc.MessageDescriptor
+
+	// Request table: RequestTimeout is how long a sent message waits for its
+	// reply, RequestsFunc is called when the table changes
+	RequestTimeout    time.Duration
+	RequestsFunc      func()
+	reqMu             sync.Mutex
+	requests          map[string]*PendingRequest
+	requestOrder      []string
+	lastTransactionID int64
 }
 
 func NewBackend(logFunc func(string)) *Backend {
 	return &Backend{
-		LogFunc:   logFunc,
-		FileDescs: make(map[string]*desc.FileDescriptor),
+		LogFunc:        logFunc,
+		FileDescs:      make(map[string]*desc.FileDescriptor),
+		RequestTimeout: DefaultRequestTimeout,
+		requests:       make(map[string]*PendingRequest),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
@@ -52,23 +62,6 @@ func (b *Backend) Log(format string, args ...interface{}) {
 	}
 }
 
-func (b *Backend) GetWSPort() string {
-	k, err := registry.OpenKey(registry.LOCAL_MACHINE, RegistryKey, registry.QUERY_VALUE)
-	if err != nil {
-		b.Log("Registry key not found, using default port: %s", DefaultWSPort)
-		return DefaultWSPort
-	}
-	defer k.Close()
-
-	port, _, err := k.GetStringValue(RegistryValue)
-	if err != nil {
-		b.Log("Registry value not found, using default port: %s", DefaultWSPort)
-		return DefaultWSPort
-	}
-	b.Log("Found port in registry: %s", port)
-	return port
-}
-
 func (b *Backend) ScanProtoFiles(root string) ([]string, error) {
 	var files []string
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
@@ -124,13 +117,20 @@ func (b *Backend) StartServer(port string) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", b.handleWebSocket)
 
-	b.Server = &http.Server{
+	// Listen before returning so a port in use is reported to the caller,
+	// and keep no server that never started
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	server := &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
+	b.Server = server
 
 	go func() {
-		if err := b.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			b.Log("Server error: %v", err)
 		}
 	}()
@@ -160,6 +160,9 @@ func (b *Backend) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	b.mu.Unlock()
 
 	b.Log("Client connected from %s", r.RemoteAddr)
+	if b.ConnectFunc != nil {
+		b.ConnectFunc(r.RemoteAddr)
+	}
 
 	// Start listening
 	go b.listen()
@@ -199,16 +202,27 @@ func (b *Backend) listen() {
 		}
 
 		payloadData := message[4:]
-		b.Log("Received %d bytes payload", len(payloadData))
-
-		// Here we should deserialize the payload
-		// We need the Payload descriptor.
-		// For now, just log the raw bytes or try to decode if we have the descriptor loaded.
-		// In a real app, we would decode Kiosk.Payload.
+		frame := b.DecodeFrame(payloadData)
+		b.resolveRequest(&frame)
+		if frame.Err != nil {
+			b.Log("Received %d bytes payload, decode error: %v", len(payloadData), frame.Err)
+		} else {
+			b.Log("Received %s (%d bytes)", frame.TypeURL, len(payloadData))
+		}
+		if b.FrameFunc != nil {
+			b.FrameFunc(frame)
+		}
 	}
 }
 
+// Send sends msg wrapped in a Kiosk.Payload and tracks it in the request
+// table until its reply arrives or RequestTimeout passes
 func (b *Backend) Send(msg *dynamic.Message, payloadDesc *desc.MessageDescriptor) error {
+	_, err := b.SendTracked(msg, payloadDesc)
+	return err
+}
+
+func (b *Backend) send(msg *dynamic.Message, payloadDesc *desc.MessageDescriptor, transactionID string) error {
 	b.mu.Lock()
 	conn := b.Conn
 	b.mu.Unlock()
@@ -240,7 +254,7 @@ func (b *Backend) Send(msg *dynamic.Message, payloadDesc *desc.MessageDescriptor
 		dataField := metaMsg.GetMessageDescriptor().FindFieldByName("data")
 		if dataField != nil {
 			// Set transactionID
-			metaMsg.PutMapField(dataField, "transactionID", fmt.Sprintf("%d", time.Now().UnixMilli()))
+			metaMsg.PutMapField(dataField, "transactionID", transactionID)
 		}
 		payloadMsg.SetField(metaField, metaMsg)
 	}
@@ -296,6 +310,6 @@ func (b *Backend) Send(msg *dynamic.Message, payloadDesc *desc.MessageDescriptor
 		return fmt.Errorf("write error: %w", err)
 	}
 
-	b.Log("Sent message: %s (%d bytes)", msg.GetMessageDescriptor().GetName(), len(payloadBytes))
+	b.Log("Sent message: %s (%d bytes, transaction %s)", msg.GetMessageDescriptor().GetName(), len(payloadBytes), transactionID)
 	return nil
 }
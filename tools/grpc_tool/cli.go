@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// Exit codes of the headless mode
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitUsage      = 2
+	exitTimeout    = 3
+	exitUnexpected = 4
+)
+
+const cliUsage = `Usage:
+  grpc_tool send -message Name (-data JSON | -file request.json) [options]
+  grpc_tool send -collection C -request R [-env E] [-var name=value]... [options]
+  grpc_tool messages [-proto folder]
+  grpc_tool collections list|save|remove|import|export ...
+  grpc_tool env list|show|set|unset|use|remove ...
+
+send starts the WebSocket server, waits for the Kiosk Service to connect,
+sends the message wrapped in Kiosk.Payload and prints the decoded replies as
+JSON. {{variables}} in the message are taken from the environment.
+
+Exit codes: 0 ok, 1 error, 2 usage, 3 timeout, 4 unexpected response.
+`
+
+// runCLI runs the headless mode and returns the exit code
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "send":
+		return sendCommand(args[1:])
+	case "messages":
+		return messagesCommand(args[1:])
+	case "collections":
+		return collectionsCommand(args[1:])
+	case "env":
+		return envCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q; expected send, messages, collections or env\n", args[0])
+	return exitUsage
+}
+
+// defaultProtoFolder is the Protobuf folder the GUI defaults to
+func defaultProtoFolder() string {
+	cwd, _ := os.Getwd()
+	return filepath.Join(cwd, "..", "Protobuf")
+}
+
+// newCLIBackend returns a backend logging to stderr when verbose
+func newCLIBackend(verbose bool) *Backend {
+	return NewBackend(func(msg string) {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[%s] %s\n", time.Now().Format("15:04:05"), msg)
+		}
+	})
+}
+
+// LoadProtoFolder scans root and parses every proto file, at most
+// parallelism at a time. It returns the number of files parsed and fails
+// only when nothing could be parsed.
+func (b *Backend) LoadProtoFolder(root string, parallelism int) (int, error) {
+	files, err := b.ScanProtoFiles(root)
+	if err != nil {
+		return 0, fmt.Errorf("error scanning %s: %w", root, err)
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no proto files in %s", root)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, parallelism)
+	parsed := 0
+	for _, file := range files {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(filename string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if _, err := b.ParseProto(root, filename); err != nil {
+				b.Log("Failed to parse %s: %v", filename, err)
+				return
+			}
+			mu.Lock()
+			parsed++
+			mu.Unlock()
+		}(file)
+	}
+	wg.Wait()
+
+	b.Log("Successfully parsed %d/%d proto files.", parsed, len(files))
+	if parsed == 0 {
+		return 0, fmt.Errorf("none of the %d proto files in %s could be parsed", len(files), root)
+	}
+	return parsed, nil
+}
+
+// messagesCommand lists the messages of the proto folder
+func messagesCommand(args []string) int {
+	flags := flag.NewFlagSet("messages", flag.ContinueOnError)
+	protoFolder := flags.String("proto", defaultProtoFolder(), "Folder with the .proto files")
+	verbose := flags.Bool("v", false, "Log progress to stderr")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	b := newCLIBackend(*verbose)
+	if _, err := b.LoadProtoFolder(*protoFolder, 8); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	var names []string
+	for _, fd := range b.FileDescs {
+		for _, md := range fd.GetMessageTypes() {
+			names = append(names, md.GetFullyQualifiedName())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return exitOK
+}
+
+// sendCommand sends one message and waits for the replies
+func sendCommand(args []string) int {
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	protoFolder := flags.String("proto", defaultProtoFolder(), "Folder with the .proto files")
+	messageName := flags.String("message", "", "Message to send, e.g. Kiosk.AuthRequest or AuthRequest")
+	data := flags.String("data", "", "Message as inline JSON")
+	file := flags.String("file", "", "Message as a JSON file, - for stdin")
+	port := flags.String("port", "", "WebSocket port (default: the registry value or "+DefaultWSPort+")")
+	connectTimeout := flags.Duration("connect-timeout", 30*time.Second, "How long to wait for the Kiosk Service to connect")
+	timeout := flags.Duration("timeout", 10*time.Second, "How long to wait for the replies after sending")
+	replies := flags.Int("replies", 1, "Number of replies to wait for (0 to exit after sending); a single reply must carry the request's transactionID unless -any")
+	anyReply := flags.Bool("any", false, "Take the first replies whether or not they carry the request's transactionID")
+	expect := flags.String("expect", "", "Message type every reply must have, e.g. Kiosk.AuthResponse")
+	verbose := flags.Bool("v", false, "Log progress to stderr")
+	storeDir := storeFlag(flags)
+	collection := flags.String("collection", "", "Collection of the saved request to send")
+	request := flags.String("request", "", "Saved request to send")
+	envName := flags.String("env", "", "Environment of the {{variables}} (default: the active one)")
+	vars := varsFlag{}
+	flags.Var(vars, "var", "Set a variable, name=value (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), cliUsage+"\nOptions of send:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	saved := *collection != "" || *request != ""
+	if saved {
+		if *collection == "" || *request == "" || *messageName != "" || *data != "" || *file != "" {
+			flags.Usage()
+			return exitUsage
+		}
+	} else if *messageName == "" || (*data == "") == (*file == "") {
+		flags.Usage()
+		return exitUsage
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	store := &Store{Dir: *storeDir}
+	envs, err := store.Environments()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	variables, err := envs.Variables(*envName, vars)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+
+	req := SavedRequest{Message: *messageName, Body: json.RawMessage(*data)}
+	if saved {
+		c, err := store.Collection(*collection)
+		if err == nil {
+			req, err = c.Request(*request)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+	} else if *file != "" {
+		if *file == "-" {
+			req.Body, err = io.ReadAll(os.Stdin)
+		} else {
+			req.Body, err = os.ReadFile(*file)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading message:", err)
+			return exitError
+		}
+	}
+
+	b := newCLIBackend(*verbose)
+	if _, err := b.LoadProtoFolder(*protoFolder, 8); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	payloadDesc, err := b.FindMessageByTypeURL("Kiosk.Payload")
+	if errors.Is(err, ErrUnknownMessage) {
+		fmt.Fprintln(os.Stderr, "Error: Kiosk.Payload not found; is Payload.proto in the proto folder?")
+		return exitError
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	b.SetPayloadDescriptor(payloadDesc)
+
+	msg, err := b.BuildSavedRequest(req, variables)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	var expected *desc.MessageDescriptor
+	if *expect != "" {
+		if expected, err = b.FindMessageByTypeURL(*expect); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -expect: %v\n", err)
+			return exitError
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	connected := make(chan struct{}, 1)
+	frames := make(chan Frame, 16)
+	b.ConnectFunc = func(string) {
+		select {
+		case connected <- struct{}{}:
+		default:
+		}
+	}
+	// A single reply is matched by transactionID and other frames are only
+	// reported; otherwise every frame counts as a reply
+	matchReply := *replies == 1 && !*anyReply
+	b.FrameFunc = func(f Frame) {
+		if matchReply {
+			if f.Latency == 0 {
+				fmt.Fprintf(os.Stderr, "Ignoring frame %s (transaction %q)\n", f.TypeURL, f.TransactionID)
+			}
+			return
+		}
+		select {
+		case frames <- f:
+		case <-ctx.Done():
+		}
+	}
+	b.RequestTimeout = *timeout
+
+	if *port == "" {
+		*port = b.GetWSPort()
+	}
+	if err := b.StartServer(*port); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	defer b.StopServer()
+
+	fmt.Fprintf(os.Stderr, "Waiting for the Kiosk Service on port %s...\n", *port)
+	select {
+	case <-connected:
+	case <-time.After(*connectTimeout):
+		fmt.Fprintf(os.Stderr, "Error: timed out after %s waiting for a client to connect\n", *connectTimeout)
+		return exitTimeout
+	case <-ctx.Done():
+		return exitError
+	}
+
+	check := func(f Frame) error {
+		fmt.Print(strings.ReplaceAll(f.String(), "\r\n", "\n"))
+		if f.Err != nil {
+			return f.Err
+		}
+		if expected != nil {
+			got, err := b.FindMessageByTypeURL(f.TypeURL)
+			if err != nil || got.GetFullyQualifiedName() != expected.GetFullyQualifiedName() {
+				return fmt.Errorf("got %s, want %s", f.TypeURL, expected.GetFullyQualifiedName())
+			}
+		}
+		return nil
+	}
+
+	if matchReply {
+		req, err := b.SendAndWait(ctx, msg, payloadDesc, *timeout)
+		switch {
+		case errors.Is(err, ErrRequestTimeout):
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitTimeout
+		case err != nil:
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		if err := check(*req.Reply); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: unexpected response:", err)
+			return exitUnexpected
+		}
+		return exitOK
+	}
+
+	if err := b.Send(msg, payloadDesc); err != nil {
+		fmt.Fprintln(os.Stderr, "Send error:", err)
+		return exitError
+	}
+	code, err := awaitReplies(ctx, frames, *replies, *timeout, check)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	return code
+}
+
+// awaitReplies passes n replies to check and returns the exit code: a
+// timeout when fewer arrive in time, unexpected when check fails
+func awaitReplies(ctx context.Context, frames <-chan Frame, n int, timeout time.Duration, check func(Frame) error) (int, error) {
+	deadline := time.After(timeout)
+	for i := 0; i < n; i++ {
+		select {
+		case f := <-frames:
+			if err := check(f); err != nil {
+				return exitUnexpected, fmt.Errorf("unexpected response: %w", err)
+			}
+		case <-deadline:
+			return exitTimeout, fmt.Errorf("timed out after %s waiting for reply %d of %d", timeout, i+1, n)
+		case <-ctx.Done():
+			return exitError, errors.New("interrupted")
+		}
+	}
+	return exitOK, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const collectionsUsage = `Usage:
+  grpc_tool collections list [collection]
+  grpc_tool collections save -collection C -request R -message Name (-data JSON | -file body.json) [-proto-file File.proto]
+  grpc_tool collections remove collection [request]
+  grpc_tool collections import [-overwrite] collection.json
+  grpc_tool collections export collection file.json
+
+  grpc_tool env list
+  grpc_tool env show [environment]
+  grpc_tool env set environment name=value...
+  grpc_tool env unset environment name...
+  grpc_tool env use environment
+  grpc_tool env remove environment
+
+Every command takes -store folder (default: grpc_tool in the user config folder).
+`
+
+// varsFlag collects repeated name=value flags
+type varsFlag map[string]string
+
+func (v varsFlag) String() string {
+	var pairs []string
+	for k, value := range v {
+		pairs = append(pairs, k+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v varsFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[strings.TrimSpace(name)] = value
+	return nil
+}
+
+// storeFlag adds -store to flags
+func storeFlag(flags *flag.FlagSet) *string {
+	dir, err := DefaultStoreDir()
+	if err != nil {
+		dir = "grpc_tool"
+	}
+	return flags.String("store", dir, "Folder of the saved collections and environments")
+}
+
+// collectionsCommand manages saved request collections
+func collectionsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, collectionsUsage)
+		return exitUsage
+	}
+	sub, args := args[0], args[1:]
+	flags := flag.NewFlagSet("collections "+sub, flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), collectionsUsage) }
+	storeDir := storeFlag(flags)
+
+	switch sub {
+	case "list":
+		if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+			return exitUsage
+		}
+		store := &Store{Dir: *storeDir}
+		if flags.NArg() == 1 {
+			c, err := store.Collection(flags.Arg(0))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				return exitError
+			}
+			for _, r := range c.Requests {
+				fmt.Printf("%-30s %s\n", r.Name, r.Message)
+			}
+			return exitOK
+		}
+		collections, err := store.Collections()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		if len(collections) == 0 {
+			fmt.Printf("No collections in %s\n", store.Dir)
+		}
+		for _, c := range collections {
+			fmt.Printf("%-30s %d requests\n", c.Name, len(c.Requests))
+		}
+		return exitOK
+
+	case "save":
+		collection := flags.String("collection", "", "Collection to save the request in")
+		request := flags.String("request", "", "Name of the request")
+		message := flags.String("message", "", "Message type, e.g. Kiosk.AuthRequest")
+		protoFile := flags.String("proto-file", "", "Proto file of the message, relative to the proto folder")
+		data := flags.String("data", "", "Message fields as inline JSON")
+		file := flags.String("file", "", "Message fields as a JSON file")
+		if err := flags.Parse(args); err != nil {
+			return exitUsage
+		}
+		if *collection == "" || *request == "" || *message == "" || (*data == "") == (*file == "") || flags.NArg() > 0 {
+			flags.Usage()
+			return exitUsage
+		}
+		body := []byte(*data)
+		if *file != "" {
+			var err error
+			if body, err = os.ReadFile(*file); err != nil {
+				fmt.Fprintln(os.Stderr, "Error reading message:", err)
+				return exitError
+			}
+		}
+		if !json.Valid(body) {
+			fmt.Fprintln(os.Stderr, "Error: the message fields are not valid JSON")
+			return exitError
+		}
+		store := &Store{Dir: *storeDir}
+		req := SavedRequest{Name: *request, ProtoFile: *protoFile, Message: *message, Body: body}
+		if err := store.SaveRequest(*collection, req); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		fmt.Printf("Saved %s in %s\n", *request, *collection)
+		return exitOK
+
+	case "remove":
+		if err := flags.Parse(args); err != nil || flags.NArg() < 1 || flags.NArg() > 2 {
+			return exitUsage
+		}
+		store := &Store{Dir: *storeDir}
+		if flags.NArg() == 1 {
+			if err := store.RemoveCollection(flags.Arg(0)); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				return exitError
+			}
+			fmt.Printf("Removed collection %s\n", flags.Arg(0))
+			return exitOK
+		}
+		c, err := store.Collection(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		i := c.find(flags.Arg(1))
+		if i < 0 {
+			fmt.Fprintf(os.Stderr, "Error: no request %q in collection %q\n", flags.Arg(1), c.Name)
+			return exitError
+		}
+		c.Requests = append(c.Requests[:i], c.Requests[i+1:]...)
+		if err := store.SaveCollection(c); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		fmt.Printf("Removed %s from %s\n", flags.Arg(1), c.Name)
+		return exitOK
+
+	case "import":
+		overwrite := flags.Bool("overwrite", false, "Replace a collection of the same name")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+			return exitUsage
+		}
+		store := &Store{Dir: *storeDir}
+		c, err := store.ImportCollection(flags.Arg(0), *overwrite)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		fmt.Printf("Imported %s (%d requests)\n", c.Name, len(c.Requests))
+		return exitOK
+
+	case "export":
+		if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+			return exitUsage
+		}
+		store := &Store{Dir: *storeDir}
+		if err := store.ExportCollection(flags.Arg(0), flags.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitError
+		}
+		fmt.Printf("Exported %s to %s\n", flags.Arg(0), flags.Arg(1))
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "Unknown collections command %q\n", sub)
+	return exitUsage
+}
+
+// envCommand manages environments
+func envCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, collectionsUsage)
+		return exitUsage
+	}
+	sub, args := args[0], args[1:]
+	flags := flag.NewFlagSet("env "+sub, flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), collectionsUsage) }
+	storeDir := storeFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	store := &Store{Dir: *storeDir}
+	envs, err := store.Environments()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+
+	switch sub {
+	case "list":
+		if len(envs.Environments) == 0 {
+			fmt.Printf("No environments in %s\n", store.Dir)
+		}
+		for _, env := range envs.Environments {
+			active := " "
+			if strings.EqualFold(env.Name, envs.Active) {
+				active = "*"
+			}
+			fmt.Printf("%s %-30s %d variables\n", active, env.Name, len(env.Variables))
+		}
+		return exitOK
+
+	case "show":
+		name := envs.Active
+		if flags.NArg() > 0 {
+			name = flags.Arg(0)
+		}
+		env := envs.Find(name)
+		if env == nil {
+			fmt.Fprintf(os.Stderr, "Error: no environment %q\n", name)
+			return exitError
+		}
+		names := make([]string, 0, len(env.Variables))
+		for k := range env.Variables {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Printf("%s=%s\n", k, env.Variables[k])
+		}
+		return exitOK
+
+	case "set":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return exitUsage
+		}
+		env := envs.Find(flags.Arg(0))
+		if env == nil {
+			envs.Environments = append(envs.Environments, Environment{Name: flags.Arg(0)})
+			env = &envs.Environments[len(envs.Environments)-1]
+		}
+		if env.Variables == nil {
+			env.Variables = make(map[string]string)
+		}
+		for _, pair := range flags.Args()[1:] {
+			if err := varsFlag(env.Variables).Set(pair); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				return exitUsage
+			}
+		}
+
+	case "unset":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return exitUsage
+		}
+		env := envs.Find(flags.Arg(0))
+		if env == nil {
+			fmt.Fprintf(os.Stderr, "Error: no environment %q\n", flags.Arg(0))
+			return exitError
+		}
+		for _, name := range flags.Args()[1:] {
+			delete(env.Variables, name)
+		}
+
+	case "use":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return exitUsage
+		}
+		env := envs.Find(flags.Arg(0))
+		if env == nil {
+			fmt.Fprintf(os.Stderr, "Error: no environment %q\n", flags.Arg(0))
+			return exitError
+		}
+		envs.Active = env.Name
+
+	case "remove":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return exitUsage
+		}
+		kept := envs.Environments[:0]
+		for _, env := range envs.Environments {
+			if !strings.EqualFold(env.Name, flags.Arg(0)) {
+				kept = append(kept, env)
+			}
+		}
+		if len(kept) == len(envs.Environments) {
+			fmt.Fprintf(os.Stderr, "Error: no environment %q\n", flags.Arg(0))
+			return exitError
+		}
+		envs.Environments = kept
+		if strings.EqualFold(envs.Active, flags.Arg(0)) {
+			envs.Active = ""
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown env command %q\n", sub)
+		return exitUsage
+	}
+
+	if err := store.SaveEnvironments(envs); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	return exitOK
+}
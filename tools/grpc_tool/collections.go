@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// ErrCollectionExists is returned by ImportCollection without overwrite
+var ErrCollectionExists = errors.New("collection already exists")
+
+// SavedRequest is a message saved with its field values, like a request in
+// a Postman collection
+type SavedRequest struct {
+	Name string `json:"name"`
+	// ProtoFile is the file of the message, relative to the proto folder
+	ProtoFile string `json:"protoFile,omitempty"`
+	// Message is the message type, e.g. Kiosk.AuthRequest
+	Message string `json:"message"`
+	// Body holds the field values as protobuf JSON; string values may use
+	// {{variable}} placeholders
+	Body json.RawMessage `json:"body"`
+}
+
+// Collection is a named list of saved requests, stored as one JSON file so
+// it can be exported and shared as is
+type Collection struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Requests    []SavedRequest `json:"requests"`
+}
+
+// Environment is a named set of variables substituted into request bodies
+type Environment struct {
+	Name      string            `json:"name"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Environments is the environments file with the active environment
+type Environments struct {
+	Active       string        `json:"active,omitempty"`
+	Environments []Environment `json:"environments"`
+}
+
+// Store keeps collections and environments in a folder:
+// collections/<name>.json and environments.json
+type Store struct {
+	Dir string
+}
+
+// DefaultStoreDir is grpc_tool in the user's config folder
+func DefaultStoreDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config folder: %w", err)
+	}
+	return filepath.Join(dir, "grpc_tool"), nil
+}
+
+func (s *Store) collectionsDir() string {
+	return filepath.Join(s.Dir, "collections")
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// collectionPath is the file of a collection, named after it. Names
+// differing only in case or punctuation share a file, so saving checks that
+// the file holds the same collection.
+func (s *Store) collectionPath(name string) string {
+	file := strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(name), "_"), "_.")
+	if file == "" {
+		file = "collection"
+	}
+	return filepath.Join(s.collectionsDir(), file+".json")
+}
+
+// Collections returns every collection, sorted by name
+func (s *Store) Collections() ([]Collection, error) {
+	paths, err := filepath.Glob(filepath.Join(s.collectionsDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var collections []Collection
+	for _, path := range paths {
+		c, err := ReadCollection(path)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return strings.ToLower(collections[i].Name) < strings.ToLower(collections[j].Name)
+	})
+	return collections, nil
+}
+
+// Collection returns the collection with the name
+func (s *Store) Collection(name string) (Collection, error) {
+	c, err := ReadCollection(s.collectionPath(name))
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !strings.EqualFold(c.Name, name)) {
+		return Collection{}, fmt.Errorf("no collection %q", name)
+	}
+	return c, err
+}
+
+// checkFileName returns an error when the file of the collection name holds
+// a collection of another name, such as "auth_flow" for "Auth Flow", which
+// saving name would replace
+func (s *Store) checkFileName(name string) error {
+	c, err := ReadCollection(s.collectionPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(c.Name, name) {
+		return fmt.Errorf("collection %q would replace %q, which is saved in the same file; choose another name", name, c.Name)
+	}
+	return nil
+}
+
+// SaveCollection writes c, replacing the collection of the same name
+func (s *Store) SaveCollection(c Collection) error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("collection name is required")
+	}
+	if err := s.checkFileName(c.Name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.collectionsDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.collectionsDir(), err)
+	}
+	return WriteCollection(s.collectionPath(c.Name), c)
+}
+
+// RemoveCollection deletes the collection with the name
+func (s *Store) RemoveCollection(name string) error {
+	if _, err := s.Collection(name); err != nil {
+		return err
+	}
+	err := os.Remove(s.collectionPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no collection %q", name)
+	}
+	return err
+}
+
+// SaveRequest adds req to the collection, replacing the request of the same
+// name; the collection is created when needed
+func (s *Store) SaveRequest(collection string, req SavedRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("request name is required")
+	}
+	if err := s.checkFileName(collection); err != nil {
+		return err
+	}
+	c, err := ReadCollection(s.collectionPath(collection))
+	if errors.Is(err, os.ErrNotExist) {
+		c, err = Collection{Name: collection}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if i := c.find(req.Name); i >= 0 {
+		c.Requests[i] = req
+	} else {
+		c.Requests = append(c.Requests, req)
+	}
+	return s.SaveCollection(c)
+}
+
+// Request returns the saved request of a collection
+func (c Collection) Request(name string) (SavedRequest, error) {
+	if i := c.find(name); i >= 0 {
+		return c.Requests[i], nil
+	}
+	return SavedRequest{}, fmt.Errorf("no request %q in collection %q", name, c.Name)
+}
+
+func (c Collection) find(name string) int {
+	for i, r := range c.Requests {
+		if strings.EqualFold(r.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ImportCollection copies a collection file into the store. An existing
+// collection of the same name is only replaced with overwrite.
+func (s *Store) ImportCollection(path string, overwrite bool) (Collection, error) {
+	c, err := ReadCollection(path)
+	if err != nil {
+		return c, err
+	}
+	if err := s.checkFileName(c.Name); err != nil {
+		return c, err
+	}
+	if _, err := os.Stat(s.collectionPath(c.Name)); err == nil && !overwrite {
+		return c, fmt.Errorf("%q: %w", c.Name, ErrCollectionExists)
+	}
+	return c, s.SaveCollection(c)
+}
+
+// ExportCollection writes the collection with the name to path
+func (s *Store) ExportCollection(name, path string) error {
+	c, err := s.Collection(name)
+	if err != nil {
+		return err
+	}
+	return WriteCollection(path, c)
+}
+
+// ReadCollection reads and checks a collection file
+func ReadCollection(path string) (Collection, error) {
+	var c Collection
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid collection %s: %w", path, err)
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		return c, fmt.Errorf("invalid collection %s: name is missing", path)
+	}
+	for i, r := range c.Requests {
+		if r.Name == "" || r.Message == "" {
+			return c, fmt.Errorf("invalid collection %s: request %d needs a name and a message", path, i+1)
+		}
+		if len(r.Body) > 0 && !json.Valid(r.Body) {
+			return c, fmt.Errorf("invalid collection %s: request %q has an invalid body", path, r.Name)
+		}
+	}
+	return c, nil
+}
+
+// WriteCollection writes c to path as indented JSON
+func WriteCollection(path string, c Collection) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode collection: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write collection: %w", err)
+	}
+	return nil
+}
+
+// Environments reads environments.json; a missing file has no environments
+func (s *Store) Environments() (*Environments, error) {
+	envs := &Environments{}
+	data, err := os.ReadFile(filepath.Join(s.Dir, "environments.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return envs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, envs); err != nil {
+		return nil, fmt.Errorf("invalid environments.json: %w", err)
+	}
+	return envs, nil
+}
+
+// SaveEnvironments writes environments.json
+func (s *Store) SaveEnvironments(envs *Environments) error {
+	sort.Slice(envs.Environments, func(i, j int) bool {
+		return strings.ToLower(envs.Environments[i].Name) < strings.ToLower(envs.Environments[j].Name)
+	})
+	data, err := json.MarshalIndent(envs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode environments: %w", err)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.Dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, "environments.json"), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write environments: %w", err)
+	}
+	return nil
+}
+
+// Find returns the environment with the name, or nil
+func (e *Environments) Find(name string) *Environment {
+	for i := range e.Environments {
+		if strings.EqualFold(e.Environments[i].Name, name) {
+			return &e.Environments[i]
+		}
+	}
+	return nil
+}
+
+// Variables returns the variables of the named environment, or of the active
+// one when name is empty, with overrides applied on top
+func (e *Environments) Variables(name string, overrides map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+	if name == "" {
+		name = e.Active
+	}
+	if name != "" {
+		env := e.Find(name)
+		if env == nil {
+			return nil, fmt.Errorf("no environment %q", name)
+		}
+		for k, v := range env.Variables {
+			vars[k] = v
+		}
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+var variablePattern = regexp.MustCompile(`{{\s*([$A-Za-z_][A-Za-z0-9_.-]*)\s*}}`)
+
+// ExpandVariables replaces {{name}} placeholders in the string values and
+// keys of a JSON body with vars. Besides the environment there are
+// {{$timestamp}} (Unix milliseconds) and {{$guid}}. Unknown variables are
+// an error.
+func ExpandVariables(body []byte, vars map[string]string) ([]byte, error) {
+	if !bytes.Contains(body, []byte("{{")) {
+		return body, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	missing := make(map[string]bool)
+	expand := func(s string) string {
+		return variablePattern.ReplaceAllStringFunc(s, func(m string) string {
+			name := variablePattern.FindStringSubmatch(m)[1]
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			switch name {
+			case "$timestamp":
+				return strconv.FormatInt(time.Now().UnixMilli(), 10)
+			case "$guid":
+				return newGUID()
+			}
+			missing[name] = true
+			return m
+		})
+	}
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case string:
+			return expand(t)
+		case []interface{}:
+			for i := range t {
+				t[i] = walk(t[i])
+			}
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(t))
+			for k, elem := range t {
+				out[expand(k)] = walk(elem)
+			}
+			return out
+		}
+		return v
+	}
+	v = walk(v)
+
+	if len(missing) > 0 {
+		var names []string
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("undefined variables: %s", strings.Join(names, ", "))
+	}
+	return json.Marshal(v)
+}
+
+func newGUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// BuildSavedRequest finds the message of req among the parsed proto files and
+// fills it from the body with the variables expanded
+func (b *Backend) BuildSavedRequest(req SavedRequest, vars map[string]string) (*dynamic.Message, error) {
+	body := []byte(req.Body)
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	}
+	body, err := ExpandVariables(body, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	fd := b.FileDescs[filepath.ToSlash(req.ProtoFile)]
+	b.mu.Unlock()
+	var md *desc.MessageDescriptor
+	if fd != nil {
+		md = fd.FindMessage(req.Message)
+	}
+	if md == nil {
+		if md, err = b.FindMessageByTypeURL(req.Message); err != nil {
+			return nil, err
+		}
+	}
+	msg, err := b.MessageFromJSON(md, string(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON for %s: %w", md.GetFullyQualifiedName(), err)
+	}
+	return msg, nil
+}
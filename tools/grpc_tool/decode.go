@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// Frame is an inbound WebSocket frame and its decoded form
+type Frame struct {
+	Time time.Time
+	Size int
+	// TypeURL is the type_url of the Kiosk.Payload message, if it was decoded
+	TypeURL string
+	// TransactionID is metadata.data["transactionID"] of the payload
+	TransactionID string
+	// Latency is the round trip when the frame answers a sent request
+	Latency time.Duration
+	// JSON is the decoded payload; empty when it could not be decoded
+	JSON string
+	// Hex is a dump of the bytes that could not be decoded
+	Hex string
+	Err error
+}
+
+// String renders the frame for the response pane
+func (f Frame) String() string {
+	var sb strings.Builder
+	title := f.TypeURL
+	if title == "" {
+		title = "undecoded frame"
+	}
+	fmt.Fprintf(&sb, "[%s] %s (%d bytes)", f.Time.Format("15:04:05.000"), title, f.Size)
+	if f.Latency > 0 {
+		fmt.Fprintf(&sb, ", reply to %s after %s", f.TransactionID, roundLatency(f.Latency))
+	} else if f.TransactionID != "" {
+		fmt.Fprintf(&sb, ", transaction %s", f.TransactionID)
+	}
+	sb.WriteString("\r\n")
+	if f.Err != nil {
+		fmt.Fprintf(&sb, "Decode error: %v\r\n", f.Err)
+	}
+	if f.JSON != "" {
+		sb.WriteString(strings.ReplaceAll(f.JSON, "\n", "\r\n"))
+		sb.WriteString("\r\n")
+	}
+	if f.Hex != "" {
+		sb.WriteString(strings.ReplaceAll(strings.TrimRight(f.Hex, "\n"), "\n", "\r\n"))
+		sb.WriteString("\r\n")
+	}
+	return sb.String()
+}
+
+// SetPayloadDescriptor sets the Kiosk.Payload descriptor used to decode
+// inbound frames
+func (b *Backend) SetPayloadDescriptor(md *desc.MessageDescriptor) {
+	b.mu.Lock()
+	b.payloadDesc = md
+	b.mu.Unlock()
+}
+
+// DecodeFrame decodes a Kiosk.Payload and unpacks its Any message by
+// type_url. What cannot be decoded is returned as a hex dump.
+func (b *Backend) DecodeFrame(data []byte) Frame {
+	frame := Frame{Time: time.Now(), Size: len(data)}
+
+	b.mu.Lock()
+	payloadDesc := b.payloadDesc
+	b.mu.Unlock()
+	if payloadDesc == nil {
+		frame.Err = fmt.Errorf("Kiosk.Payload definition not loaded")
+		frame.Hex = hex.Dump(data)
+		return frame
+	}
+
+	payloadMsg := dynamic.NewMessage(payloadDesc)
+	if err := payloadMsg.Unmarshal(data); err != nil {
+		frame.Err = fmt.Errorf("failed to unmarshal payload: %w", err)
+		frame.Hex = hex.Dump(data)
+		return frame
+	}
+	frame.TransactionID = payloadTransactionID(payloadMsg)
+
+	// Render the payload as JSON with the Any inlined the way jsonpb does:
+	// {"@type": type_url, ...fields of the unpacked message}
+	var fields []string
+	add := func(name string, value []byte) {
+		key, _ := json.Marshal(name)
+		fields = append(fields, string(key)+":"+string(value))
+	}
+	for _, fd := range payloadDesc.GetFields() {
+		if !payloadMsg.HasField(fd) {
+			continue
+		}
+		if fd.GetName() == "message" && fd.GetMessageType() != nil && fd.GetMessageType().GetFullyQualifiedName() == "google.protobuf.Any" {
+			value, err := b.unpackAny(payloadMsg.GetField(fd), &frame)
+			if err != nil {
+				frame.Err = err
+			}
+			add(fd.GetJSONName(), value)
+			continue
+		}
+		value, err := b.marshalField(payloadMsg, fd)
+		if err != nil {
+			frame.Err = fmt.Errorf("failed to render %s: %w", fd.GetName(), err)
+			frame.Hex = hex.Dump(data)
+			return frame
+		}
+		add(fd.GetJSONName(), value)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte("{"+strings.Join(fields, ",")+"}"), "", "  "); err != nil {
+		frame.Err = fmt.Errorf("failed to render payload: %w", err)
+		frame.Hex = hex.Dump(data)
+		return frame
+	}
+	frame.JSON = out.String()
+	return frame
+}
+
+// unpackAny renders the message packed in a google.protobuf.Any. When its
+// type is unknown or its bytes do not decode, the value is left out of the
+// JSON and dumped in frame.Hex.
+func (b *Backend) unpackAny(field interface{}, frame *Frame) ([]byte, error) {
+	var typeURL string
+	var value []byte
+	switch anyMsg := field.(type) {
+	case *dynamic.Message:
+		typeURL, _ = anyMsg.GetFieldByName("type_url").(string)
+		value, _ = anyMsg.GetFieldByName("value").([]byte)
+	case interface {
+		GetTypeUrl() string
+		GetValue() []byte
+	}:
+		// Well-known types are unmarshalled into their generated types
+		typeURL, value = anyMsg.GetTypeUrl(), anyMsg.GetValue()
+	}
+	frame.TypeURL = typeURL
+	typeJSON, _ := json.Marshal(typeURL)
+
+	md, err := b.FindMessageByTypeURL(typeURL)
+	if err != nil {
+		frame.Hex = hex.Dump(value)
+		return []byte(`{"@type":` + string(typeJSON) + `}`), err
+	}
+	inner := dynamic.NewMessage(md)
+	if err := inner.Unmarshal(value); err != nil {
+		frame.Hex = hex.Dump(value)
+		return []byte(`{"@type":` + string(typeJSON) + `}`), fmt.Errorf("failed to unmarshal %s: %w", md.GetFullyQualifiedName(), err)
+	}
+	innerJSON, err := inner.MarshalJSONPB(b.jsonMarshaler())
+	if err != nil {
+		frame.Hex = hex.Dump(value)
+		return []byte(`{"@type":` + string(typeJSON) + `}`), fmt.Errorf("failed to render %s: %w", md.GetFullyQualifiedName(), err)
+	}
+
+	innerJSON = bytes.TrimSpace(innerJSON)
+	if string(innerJSON) == "{}" {
+		return []byte(`{"@type":` + string(typeJSON) + `}`), nil
+	}
+	return []byte(`{"@type":` + string(typeJSON) + `,` + string(innerJSON[1:])), nil
+}
+
+// ErrUnknownMessage is returned by FindMessageByTypeURL when no parsed
+// message has the name
+var ErrUnknownMessage = errors.New("unknown message type")
+
+// ErrAmbiguousMessage is returned by FindMessageByTypeURL when a simple name
+// matches messages of different packages or parents
+var ErrAmbiguousMessage = errors.New("ambiguous message type")
+
+// FindMessageByTypeURL finds the descriptor of an Any type_url among the
+// parsed proto files. Like the Kiosk UI it is loose about the URL: the
+// prefix up to the last '/' is ignored, and a name that is not fully
+// qualified matches the one message, nested or not, with that simple name.
+func (b *Backend) FindMessageByTypeURL(typeURL string) (*desc.MessageDescriptor, error) {
+	name := typeURL[strings.LastIndex(typeURL, "/")+1:]
+	if name == "" {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMessage, typeURL)
+	}
+
+	b.mu.Lock()
+	files := make([]string, 0, len(b.FileDescs))
+	for file := range b.FileDescs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	fds := make([]*desc.FileDescriptor, len(files))
+	for i, file := range files {
+		fds[i] = b.FileDescs[file]
+	}
+	b.mu.Unlock()
+
+	for _, fd := range fds {
+		if md := fd.FindMessage(name); md != nil {
+			return md, nil
+		}
+	}
+
+	// The same message may be found in several files when one of them is
+	// imported by another, so matches are told apart by their full name
+	short := name[strings.LastIndex(name, ".")+1:]
+	matches := make(map[string]*desc.MessageDescriptor)
+	var walk func(mds []*desc.MessageDescriptor)
+	walk = func(mds []*desc.MessageDescriptor) {
+		for _, md := range mds {
+			if md.GetName() == short && !md.IsMapEntry() {
+				if _, ok := matches[md.GetFullyQualifiedName()]; !ok {
+					matches[md.GetFullyQualifiedName()] = md
+				}
+			}
+			walk(md.GetNestedMessageTypes())
+		}
+	}
+	for _, fd := range fds {
+		walk(fd.GetMessageTypes())
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w %q", ErrUnknownMessage, typeURL)
+	case 1:
+		for _, md := range matches {
+			return md, nil
+		}
+	}
+	names := make([]string, 0, len(matches))
+	for fqn := range matches {
+		names = append(names, fqn)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("%w %q: %s", ErrAmbiguousMessage, typeURL, strings.Join(names, ", "))
+}
+
+// marshalField renders one field of msg as JSON
+func (b *Backend) marshalField(msg *dynamic.Message, fd *desc.FieldDescriptor) ([]byte, error) {
+	if fd.GetMessageType() != nil && !fd.IsRepeated() && !fd.IsMap() {
+		if m, ok := msg.GetField(fd).(*dynamic.Message); ok {
+			return m.MarshalJSONPB(b.jsonMarshaler())
+		}
+	}
+	// Render scalars, lists and maps through a message holding just the field
+	single := dynamic.NewMessage(msg.GetMessageDescriptor())
+	single.SetField(fd, msg.GetField(fd))
+	js, err := single.MarshalJSONPB(b.jsonMarshaler())
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(js, &obj); err != nil {
+		return nil, err
+	}
+	return obj[fd.GetJSONName()], nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// fieldTypeName describes the type of a field for the form labels, e.g.
+// "repeated int64" or "map<string, Kiosk.Metadata>"
+func fieldTypeName(fd *desc.FieldDescriptor) string {
+	if fd.IsMap() {
+		return "map<" + elementTypeName(fd.GetMapKeyType()) + ", " + elementTypeName(fd.GetMapValueType()) + ">"
+	}
+	if fd.IsRepeated() {
+		return "repeated " + elementTypeName(fd)
+	}
+	return elementTypeName(fd)
+}
+
+func elementTypeName(fd *desc.FieldDescriptor) string {
+	if md := fd.GetMessageType(); md != nil {
+		return md.GetFullyQualifiedName()
+	}
+	if ed := fd.GetEnumType(); ed != nil {
+		return ed.GetFullyQualifiedName()
+	}
+	return strings.ToLower(strings.TrimPrefix(fd.GetType().String(), "TYPE_"))
+}
+
+// isWellKnown reports whether md is one of the google.protobuf types with a
+// special JSON form, such as Timestamp or Any. The form edits them as JSON.
+func isWellKnown(md *desc.MessageDescriptor) bool {
+	name := md.GetFullyQualifiedName()
+	return strings.HasPrefix(name, "google.protobuf.") && name != "google.protobuf.Empty"
+}
+
+// zeroValue is the value a new element of fd starts with
+func zeroValue(fd *desc.FieldDescriptor) interface{} {
+	if md := fd.GetMessageType(); md != nil {
+		return dynamic.NewMessage(md)
+	}
+	if ed := fd.GetEnumType(); ed != nil {
+		return ed.GetValues()[0].GetNumber()
+	}
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return false
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return ""
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return []byte{}
+	}
+	v, _ := parseScalar(fd, "0")
+	return v
+}
+
+// parseScalar parses the text of a scalar field into the Go type dynamic
+// messages use for it. Bytes are base64, as in the protobuf JSON mapping.
+func parseScalar(fd *desc.FieldDescriptor, text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return text, nil
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if b, err := enc.DecodeString(text); err == nil {
+				return b, nil
+			}
+		}
+		return nil, errors.New("not valid base64")
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.ParseBool(text)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32:
+		v, err := parseInt(text, 32)
+		return int32(v), numError(err)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_SINT64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64:
+		v, err := parseInt(text, 64)
+		return v, numError(err)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_FIXED32:
+		v, err := parseUint(text, 32)
+		return uint32(v), numError(err)
+	case descriptorpb.FieldDescriptorProto_TYPE_UINT64, descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		v, err := parseUint(text, 64)
+		return v, numError(err)
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT:
+		v, err := strconv.ParseFloat(text, 32)
+		return float32(v), numError(err)
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		v, err := strconv.ParseFloat(text, 64)
+		return v, numError(err)
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		ed := fd.GetEnumType()
+		if vd := ed.FindValueByName(text); vd != nil {
+			return vd.GetNumber(), nil
+		}
+		v, err := parseInt(text, 32)
+		if err != nil {
+			return nil, fmt.Errorf("no value %q in %s", text, ed.GetName())
+		}
+		return int32(v), nil
+	}
+	return nil, fmt.Errorf("%s is not a scalar", fieldTypeName(fd))
+}
+
+// parseInt parses a decimal integer, or a hexadecimal one with a 0x prefix.
+// A leading zero does not make it octal: "010" is ten.
+func parseInt(text string, bitSize int) (int64, error) {
+	sign, digits := "", text
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		sign, digits = digits[:1], digits[1:]
+	}
+	if hex, ok := cutHexPrefix(digits); ok {
+		return strconv.ParseInt(sign+hex, 16, bitSize)
+	}
+	return strconv.ParseInt(text, 10, bitSize)
+}
+
+// parseUint is parseInt for unsigned integers
+func parseUint(text string, bitSize int) (uint64, error) {
+	if hex, ok := cutHexPrefix(text); ok {
+		return strconv.ParseUint(hex, 16, bitSize)
+	}
+	return strconv.ParseUint(text, 10, bitSize)
+}
+
+func cutHexPrefix(text string) (string, bool) {
+	if len(text) > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') && text[2] != '-' && text[2] != '+' {
+		return text[2:], true
+	}
+	return text, false
+}
+
+// numError drops the strconv prefix, which repeats the input
+func numError(err error) error {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		if numErr.Err == strconv.ErrRange {
+			return errors.New("out of range")
+		}
+		return errors.New("not a number")
+	}
+	return err
+}
+
+// formatScalar is the inverse of parseScalar
+func formatScalar(fd *desc.FieldDescriptor, v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t)
+	case int32:
+		if ed := fd.GetEnumType(); ed != nil {
+			if vd := ed.FindValueByNumber(t); vd != nil {
+				return vd.GetName()
+			}
+		}
+	case float32:
+		return strconv.FormatFloat(float64(t), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// anyResolver resolves the type_url of google.protobuf.Any values against
+// the parsed proto files, then the well-known types
+type anyResolver struct {
+	b *Backend
+}
+
+func (r anyResolver) Resolve(typeURL string) (proto.Message, error) {
+	md, err := r.b.FindMessageByTypeURL(typeURL)
+	if err == nil {
+		return dynamic.NewMessage(md), nil
+	}
+	if !errors.Is(err, ErrUnknownMessage) {
+		return nil, err
+	}
+	mt, findErr := protoregistry.GlobalTypes.FindMessageByURL(typeURL)
+	if findErr != nil {
+		return nil, err
+	}
+	return proto.MessageV1(mt.New().Interface()), nil
+}
+
+// jsonMarshaler renders messages as compact protobuf JSON, unpacking Any
+// values of the parsed message types
+func (b *Backend) jsonMarshaler() *jsonpb.Marshaler {
+	return &jsonpb.Marshaler{AnyResolver: anyResolver{b}}
+}
+
+// MessageToJSON renders msg as indented protobuf JSON
+func (b *Backend) MessageToJSON(msg *dynamic.Message) (string, error) {
+	js, err := msg.MarshalJSONPB(b.jsonMarshaler())
+	if err != nil {
+		return "", err
+	}
+	// jsonpb leaves Any values unindented, so indent the whole text here
+	var out bytes.Buffer
+	if err := json.Indent(&out, js, "", "  "); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// MessageFromJSON parses protobuf JSON into a new message of type md
+func (b *Backend) MessageFromJSON(md *desc.MessageDescriptor, js string) (*dynamic.Message, error) {
+	msg := dynamic.NewMessage(md)
+	if strings.TrimSpace(js) == "" {
+		return msg, nil
+	}
+	if err := msg.UnmarshalJSONPB(&jsonpb.Unmarshaler{AnyResolver: anyResolver{b}}, []byte(js)); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// fieldToJSON renders one value of fd as JSON, e.g. a Timestamp as
+// "2024-01-02T15:04:05Z"
+func (b *Backend) fieldToJSON(fd *desc.FieldDescriptor, v interface{}) (string, error) {
+	holder := dynamic.NewMessage(fd.GetOwner())
+	var err error
+	if fd.IsRepeated() {
+		err = holder.TryAddRepeatedField(fd, v)
+	} else {
+		err = holder.TrySetField(fd, v)
+	}
+	if err != nil {
+		return "", err
+	}
+	js, err := holder.MarshalJSONPB(b.jsonMarshaler())
+	if err != nil {
+		return "", err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(js, &obj); err != nil {
+		return "", err
+	}
+	value := obj[fd.GetJSONName()]
+	if fd.IsRepeated() {
+		var list []json.RawMessage
+		if err := json.Unmarshal(value, &list); err != nil || len(list) != 1 {
+			return "", fmt.Errorf("failed to render %s", fd.GetName())
+		}
+		value = list[0]
+	}
+	return string(value), nil
+}
+
+// fieldFromJSON is the inverse of fieldToJSON
+func (b *Backend) fieldFromJSON(fd *desc.FieldDescriptor, js string) (interface{}, error) {
+	if fd.IsRepeated() {
+		js = "[" + js + "]"
+	}
+	key, _ := json.Marshal(fd.GetJSONName())
+	holder := dynamic.NewMessage(fd.GetOwner())
+	// Merge rather than unmarshal so that required fields of the holder are
+	// not checked
+	if err := holder.UnmarshalMergeJSONPB(&jsonpb.Unmarshaler{AnyResolver: anyResolver{b}}, []byte("{"+string(key)+":"+js+"}")); err != nil {
+		return nil, err
+	}
+	if fd.IsRepeated() {
+		if holder.FieldLength(fd) != 1 {
+			return nil, errors.New("expected a single value")
+		}
+		return holder.GetRepeatedField(fd, 0), nil
+	}
+	return holder.GetField(fd), nil
+}
+
+// asDynamic returns a message value of fd as a *dynamic.Message, converting
+// generated types such as the well-known ones
+func asDynamic(fd *desc.FieldDescriptor, v interface{}) (*dynamic.Message, error) {
+	if dm, ok := v.(*dynamic.Message); ok && dm != nil {
+		return dm, nil
+	}
+	dm := dynamic.NewMessage(fd.GetMessageType())
+	if pm, ok := v.(proto.Message); ok && pm != nil {
+		if err := dm.ConvertFrom(pm); err != nil {
+			return nil, err
+		}
+	}
+	return dm, nil
+}
@@ -0,0 +1,519 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/lxn/walk"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// MessageForm edits a dynamic message in place with a widget for every field,
+// recursing into nested messages, repeated fields, maps and oneofs
+type MessageForm struct {
+	backend *Backend
+	msg     *dynamic.Message
+	// errs holds the input that does not parse, by field path
+	errs map[string]error
+}
+
+// NewMessageForm renders msg into parent, showing the values it already has
+func NewMessageForm(parent walk.Container, backend *Backend, msg *dynamic.Message) *MessageForm {
+	f := &MessageForm{backend: backend, msg: msg, errs: make(map[string]error)}
+	f.renderMessage(parent, msg, "")
+	return f
+}
+
+// Err reports the fields whose input does not parse
+func (f *MessageForm) Err() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(f.errs))
+	for path := range f.errs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var msgs []string
+	for _, path := range paths {
+		msgs = append(msgs, path+": "+f.errs[path].Error())
+	}
+	return fmt.Errorf("invalid fields: %s", strings.Join(msgs, "; "))
+}
+
+// clearErrs forgets the errors of the fields under path, whose widgets are
+// about to be replaced
+func (f *MessageForm) clearErrs(path string) {
+	for p := range f.errs {
+		if p == path || strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			delete(f.errs, p)
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (f *MessageForm) renderMessage(parent walk.Container, msg *dynamic.Message, path string) {
+	md := msg.GetMessageDescriptor()
+	oneofs := make(map[string]bool)
+	for _, fd := range md.GetFields() {
+		// Proto3 optional fields are in a oneof of their own
+		if od := fd.GetOneOf(); od != nil && !fd.IsProto3Optional() {
+			if !oneofs[od.GetName()] {
+				oneofs[od.GetName()] = true
+				f.renderOneOf(parent, msg, od, path)
+			}
+			continue
+		}
+		f.renderField(parent, msg, fd, joinPath(path, fd.GetName()))
+	}
+	if len(md.GetFields()) == 0 {
+		lbl, _ := walk.NewLabel(parent)
+		lbl.SetText("(no fields)")
+	}
+}
+
+func (f *MessageForm) renderField(parent walk.Container, msg *dynamic.Message, fd *desc.FieldDescriptor, path string) {
+	switch {
+	case fd.IsMap():
+		f.renderMap(parent, msg, fd, path)
+	case fd.IsRepeated():
+		f.renderRepeated(parent, msg, fd, path)
+	case fd.GetMessageType() != nil && !isWellKnown(fd.GetMessageType()):
+		f.renderNested(parent, msg, fd, path)
+	default:
+		row := newFieldRow(parent, fd.GetName()+" ("+fieldTypeName(fd)+")")
+		var value interface{}
+		if msg.HasField(fd) {
+			value = msg.GetField(fd)
+		}
+		inOneOf := fd.GetOneOf() != nil && !fd.IsProto3Optional()
+		f.valueEditor(row, fd, value, path, func(v interface{}) error {
+			if v == nil {
+				// A oneof keeps its choice while the input is empty
+				if !inOneOf {
+					msg.ClearField(fd)
+					return nil
+				}
+				v = zeroValue(fd)
+			}
+			return msg.TrySetField(fd, v)
+		})
+	}
+}
+
+// renderNested renders a message field in a group box that is checked
+// when the field is set. Its fields are only rendered once checked, so
+// recursive types do not expand forever.
+func (f *MessageForm) renderNested(parent walk.Container, msg *dynamic.Message, fd *desc.FieldDescriptor, path string) {
+	gb, _ := walk.NewGroupBox(parent)
+	gb.SetTitle(fd.GetName() + " (" + fieldTypeName(fd) + ")")
+	gb.SetLayout(newFormLayout())
+
+	nested := dynamic.NewMessage(fd.GetMessageType())
+	if msg.HasField(fd) {
+		var err error
+		if nested, err = asDynamic(fd, msg.GetField(fd)); err != nil {
+			f.errs[path] = err
+			return
+		}
+		msg.SetField(fd, nested)
+	}
+
+	// A message chosen in a oneof is always set
+	if fd.GetOneOf() != nil && !fd.IsProto3Optional() {
+		msg.SetField(fd, nested)
+		f.renderMessage(gb, nested, path)
+		return
+	}
+
+	rendered := false
+	gb.SetCheckable(true)
+	gb.SetChecked(msg.HasField(fd))
+	if msg.HasField(fd) {
+		f.renderMessage(gb, nested, path)
+		rendered = true
+	}
+	gb.CheckedChanged().Attach(func() {
+		if !gb.Checked() {
+			msg.ClearField(fd)
+			f.clearErrs(path)
+			return
+		}
+		msg.SetField(fd, nested)
+		if !rendered {
+			rendered = true
+			gb.Synchronize(func() {
+				gb.SetSuspended(true)
+				defer gb.SetSuspended(false)
+				f.renderMessage(gb, nested, path)
+			})
+		}
+	})
+}
+
+// renderRepeated renders the elements of a repeated field with Add and
+// Remove buttons
+func (f *MessageForm) renderRepeated(parent walk.Container, msg *dynamic.Message, fd *desc.FieldDescriptor, path string) {
+	gb, _ := walk.NewGroupBox(parent)
+	gb.SetTitle(fd.GetName() + " (" + fieldTypeName(fd) + ")")
+	gb.SetLayout(newFormLayout())
+	list, _ := walk.NewComposite(gb)
+	list.SetLayout(newFormLayout())
+	addBtn, _ := walk.NewPushButton(gb)
+	addBtn.SetText("Add")
+
+	var render func()
+	render = func() {
+		list.SetSuspended(true)
+		defer list.SetSuspended(false)
+		disposeChildren(list)
+		f.clearErrs(path)
+
+		for i := 0; i < msg.FieldLength(fd); i++ {
+			i := i
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			row := newFieldRow(list, fmt.Sprintf("[%d]", i))
+			value := msg.GetRepeatedField(fd, i)
+			if fd.GetMessageType() != nil && !isWellKnown(fd.GetMessageType()) {
+				nested, err := asDynamic(fd, value)
+				if err != nil {
+					f.errs[itemPath] = err
+					continue
+				}
+				msg.SetRepeatedField(fd, i, nested)
+				box, _ := walk.NewComposite(row)
+				box.SetLayout(newFormLayout())
+				f.renderMessage(box, nested, itemPath)
+			} else {
+				f.valueEditor(row, fd, value, itemPath, func(v interface{}) error {
+					if v == nil {
+						v = zeroValue(fd)
+					}
+					return msg.TrySetRepeatedField(fd, i, v)
+				})
+			}
+
+			removeBtn, _ := walk.NewPushButton(row)
+			removeBtn.SetText("Remove")
+			removeBtn.Clicked().Attach(func() {
+				// Rebuild after the click, the button is disposed by render
+				list.Synchronize(func() {
+					var kept []interface{}
+					for j := 0; j < msg.FieldLength(fd); j++ {
+						if j != i {
+							kept = append(kept, msg.GetRepeatedField(fd, j))
+						}
+					}
+					msg.SetField(fd, kept)
+					render()
+				})
+			})
+		}
+	}
+	render()
+
+	addBtn.Clicked().Attach(func() {
+		msg.AddRepeatedField(fd, zeroValue(fd))
+		list.Synchronize(render)
+	})
+}
+
+// mapEntry is a row of a map editor. Keys are kept apart from the message
+// so that an entry being renamed does not clash with the others.
+type mapEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// renderMap renders the entries of a map field, sorted by key, with Add and
+// Remove buttons
+func (f *MessageForm) renderMap(parent walk.Container, msg *dynamic.Message, fd *desc.FieldDescriptor, path string) {
+	keyFd, valueFd := fd.GetMapKeyType(), fd.GetMapValueType()
+	gb, _ := walk.NewGroupBox(parent)
+	gb.SetTitle(fd.GetName() + " (" + fieldTypeName(fd) + ")")
+	gb.SetLayout(newFormLayout())
+	list, _ := walk.NewComposite(gb)
+	list.SetLayout(newFormLayout())
+	addBtn, _ := walk.NewPushButton(gb)
+	addBtn.SetText("Add")
+
+	var entries []*mapEntry
+	msg.ForEachMapFieldEntry(fd, func(k, v interface{}) bool {
+		entries = append(entries, &mapEntry{key: k, value: v})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return formatScalar(keyFd, entries[i].key) < formatScalar(keyFd, entries[j].key)
+	})
+
+	// sync writes the entries back to the message; a duplicate key is an
+	// error on the later entry
+	sync := func() {
+		msg.ClearField(fd)
+		seen := make(map[interface{}]bool)
+		for i, e := range entries {
+			entryPath := fmt.Sprintf("%s[%d]", path, i)
+			delete(f.errs, entryPath)
+			if seen[e.key] {
+				f.errs[entryPath] = fmt.Errorf("duplicate key %s", formatScalar(keyFd, e.key))
+				continue
+			}
+			seen[e.key] = true
+			msg.PutMapField(fd, e.key, e.value)
+		}
+	}
+
+	var render func()
+	render = func() {
+		list.SetSuspended(true)
+		defer list.SetSuspended(false)
+		disposeChildren(list)
+		f.clearErrs(path)
+
+		for i, e := range entries {
+			i, e := i, e
+			entryPath := fmt.Sprintf("%s[%d]", path, i)
+			row := newFieldRow(list, fmt.Sprintf("[%d]", i))
+			f.valueEditor(row, keyFd, e.key, entryPath+".key", func(v interface{}) error {
+				if v == nil {
+					v = zeroValue(keyFd)
+				}
+				e.key = v
+				sync()
+				return nil
+			})
+
+			if valueFd.GetMessageType() != nil && !isWellKnown(valueFd.GetMessageType()) {
+				nested, err := asDynamic(valueFd, e.value)
+				if err != nil {
+					f.errs[entryPath+".value"] = err
+				} else {
+					e.value = nested
+					box, _ := walk.NewComposite(row)
+					box.SetLayout(newFormLayout())
+					f.renderMessage(box, nested, entryPath+".value")
+				}
+			} else {
+				f.valueEditor(row, valueFd, e.value, entryPath+".value", func(v interface{}) error {
+					if v == nil {
+						v = zeroValue(valueFd)
+					}
+					e.value = v
+					sync()
+					return nil
+				})
+			}
+
+			removeBtn, _ := walk.NewPushButton(row)
+			removeBtn.SetText("Remove")
+			removeBtn.Clicked().Attach(func() {
+				list.Synchronize(func() {
+					entries = append(entries[:i], entries[i+1:]...)
+					render()
+				})
+			})
+		}
+		sync()
+	}
+	render()
+
+	addBtn.Clicked().Attach(func() {
+		entries = append(entries, &mapEntry{key: zeroValue(keyFd), value: zeroValue(valueFd)})
+		list.Synchronize(render)
+	})
+}
+
+// renderOneOf renders a choice of the oneof's fields and the editor of the
+// chosen one
+func (f *MessageForm) renderOneOf(parent walk.Container, msg *dynamic.Message, od *desc.OneOfDescriptor, path string) {
+	gb, _ := walk.NewGroupBox(parent)
+	gb.SetTitle(od.GetName() + " (oneof)")
+	gb.SetLayout(newFormLayout())
+	choiceCB, _ := walk.NewDropDownBox(gb)
+	host, _ := walk.NewComposite(gb)
+	host.SetLayout(newFormLayout())
+
+	choices := od.GetChoices()
+	items := []string{"(none)"}
+	for _, fd := range choices {
+		items = append(items, fd.GetName()+" ("+fieldTypeName(fd)+")")
+	}
+	choiceCB.SetModel(items)
+
+	render := func(chosen *desc.FieldDescriptor) {
+		host.SetSuspended(true)
+		defer host.SetSuspended(false)
+		disposeChildren(host)
+		for _, fd := range choices {
+			f.clearErrs(joinPath(path, fd.GetName()))
+		}
+		if chosen != nil {
+			f.renderField(host, msg, chosen, joinPath(path, chosen.GetName()))
+		}
+	}
+
+	chosen, _ := msg.GetOneOfField(od)
+	current := 0
+	for i, fd := range choices {
+		if chosen != nil && fd.GetNumber() == chosen.GetNumber() {
+			current = i + 1
+		}
+	}
+	choiceCB.SetCurrentIndex(current)
+	render(chosen)
+
+	choiceCB.CurrentIndexChanged().Attach(func() {
+		idx := choiceCB.CurrentIndex()
+		if idx < 0 || idx > len(choices) {
+			return
+		}
+		var fd *desc.FieldDescriptor
+		if idx > 0 {
+			fd = choices[idx-1]
+		}
+		if cur, _ := msg.GetOneOfField(od); cur == fd || (cur != nil && fd != nil && cur.GetNumber() == fd.GetNumber()) {
+			return
+		}
+		msg.ClearOneOfField(od)
+		if fd != nil {
+			msg.SetField(fd, zeroValue(fd))
+		}
+		host.Synchronize(func() { render(fd) })
+	})
+}
+
+// valueEditor adds the editor of one scalar, enum or well-known value to
+// parent. value is nil when unset; set is called with nil when the input is
+// cleared.
+func (f *MessageForm) valueEditor(parent walk.Container, fd *desc.FieldDescriptor, value interface{}, path string, set func(interface{}) error) {
+	if fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BOOL {
+		chk, _ := walk.NewCheckBox(parent)
+		chk.SetText("True")
+		chk.SetChecked(value == true)
+		chk.CheckedChanged().Attach(func() {
+			if err := set(chk.Checked()); err != nil {
+				f.errs[path] = err
+			}
+		})
+		return
+	}
+
+	if ed := fd.GetEnumType(); ed != nil {
+		cmb, _ := walk.NewDropDownBox(parent)
+		var items []string
+		var numbers []int32
+		current := -1
+		for _, vd := range ed.GetValues() {
+			items = append(items, vd.GetName())
+			numbers = append(numbers, vd.GetNumber())
+		}
+		if n, ok := value.(int32); ok {
+			if ed.FindValueByNumber(n) == nil {
+				// Keep a number the enum does not define, e.g. from JSON
+				items = append(items, fmt.Sprint(n))
+				numbers = append(numbers, n)
+			}
+			for i, num := range numbers {
+				if num == n {
+					current = i
+					break
+				}
+			}
+		}
+		cmb.SetModel(items)
+		cmb.SetCurrentIndex(current)
+		cmb.CurrentIndexChanged().Attach(func() {
+			if idx := cmb.CurrentIndex(); idx >= 0 {
+				if err := set(numbers[idx]); err != nil {
+					f.errs[path] = err
+				}
+			}
+		})
+		return
+	}
+
+	// Everything else is typed: numbers, strings, base64 bytes and the JSON
+	// form of well-known messages
+	hint := fieldTypeName(fd)
+	parse := func(text string) (interface{}, error) { return parseScalar(fd, text) }
+	text := formatScalar(fd, value)
+	if md := fd.GetMessageType(); md != nil {
+		hint = md.GetFullyQualifiedName() + " as JSON"
+		parse = func(text string) (interface{}, error) { return f.backend.fieldFromJSON(fd, text) }
+		text = ""
+		if value != nil {
+			js, err := f.backend.fieldToJSON(fd, value)
+			if err != nil {
+				f.errs[path] = err
+			}
+			text = js
+		}
+	} else if fd.GetType() == descriptorpb.FieldDescriptorProto_TYPE_BYTES {
+		hint = "bytes as base64"
+	}
+
+	le, _ := walk.NewLineEdit(parent)
+	le.SetText(text)
+	le.SetCueBanner(hint)
+	le.SetToolTipText(hint)
+	le.TextChanged().Attach(func() {
+		var err error
+		if strings.TrimSpace(le.Text()) == "" && fd.GetType() != descriptorpb.FieldDescriptorProto_TYPE_STRING {
+			err = set(nil)
+		} else {
+			var v interface{}
+			if v, err = parse(le.Text()); err == nil {
+				err = set(v)
+			}
+		}
+		if err != nil {
+			f.errs[path] = err
+			le.SetToolTipText(hint + ": " + err.Error())
+			return
+		}
+		delete(f.errs, path)
+		le.SetToolTipText(hint)
+	})
+}
+
+// newFieldRow adds a row with the field label, to which the editor is added
+func newFieldRow(parent walk.Container, label string) *walk.Composite {
+	row, _ := walk.NewComposite(parent)
+	layout := walk.NewHBoxLayout()
+	layout.SetMargins(walk.Margins{})
+	row.SetLayout(layout)
+	lbl, _ := walk.NewLabel(row)
+	lbl.SetText(label)
+	lbl.SetMinMaxSize(walk.Size{Width: 200}, walk.Size{})
+	return row
+}
+
+func newFormLayout() walk.Layout {
+	layout := walk.NewVBoxLayout()
+	layout.SetMargins(walk.Margins{HNear: 5, VNear: 5, HFar: 5, VFar: 5})
+	layout.SetSpacing(5)
+	return layout
+}
+
+// disposeChildren disposes the widgets of c; clearing the list would only
+// detach them
+func disposeChildren(c walk.Container) {
+	var widgets []walk.Widget
+	for i := 0; i < c.Children().Len(); i++ {
+		widgets = append(widgets, c.Children().At(i))
+	}
+	for _, w := range widgets {
+		w.Dispose()
+	}
+}
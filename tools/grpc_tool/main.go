@@ -1,10 +1,14 @@
+//go:build windows
+
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,17 +16,26 @@ import (
 	"github.com/jhump/protoreflect/dynamic"
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
-	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func main() {
+	// With arguments the tool runs headless, see cli.go
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	var mw *walk.MainWindow
 	var logTE *walk.TextEdit
+	var responseTE *walk.TextEdit
+	var requestsTV *walk.TableView
 	var folderLE *walk.LineEdit
 	var fileCB *walk.ComboBox
 	var msgCB *walk.ComboBox
 	var sendBtn *walk.PushButton
 	var formComp *walk.Composite
+	var formSV *walk.ScrollView
+	var jsonTE *walk.TextEdit
+	var jsonModeBtn *walk.PushButton
 	var useDefaultCB *walk.CheckBox
 
 	// State
@@ -35,12 +48,32 @@ func main() {
 			})
 		}
 	})
+	backend.FrameFunc = func(frame Frame) {
+		if responseTE != nil {
+			responseTE.Synchronize(func() {
+				responseTE.AppendText(frame.String() + "\r\n")
+			})
+		}
+	}
+
+	requestsModel := NewRequestTableModel()
+	backend.RequestsFunc = func() {
+		if requestsTV != nil {
+			requestsTV.Synchronize(func() {
+				requestsModel.Rows = backend.Requests()
+				requestsModel.PublishRowsReset()
+			})
+		}
+	}
 
 	var currentProtoFolder string
 	var currentFileDesc *desc.FileDescriptor
 	var currentMessageDesc *desc.MessageDescriptor
 	var payloadDesc *desc.MessageDescriptor
 	var currentDynamicMsg *dynamic.Message
+	var currentForm *MessageForm
+	// jsonMode shows the raw JSON editor instead of the form
+	jsonMode := false
 
 	// Rebuild/clear re-entrancy guard
 	isBuildingForm := false
@@ -127,9 +160,10 @@ func main() {
 		msgsModel.Items = []string{}
 		msgsModel.PublishItemsReset()
 
-		// Load Payload.proto for wrapping messages
+		// Load Payload.proto for wrapping and decoding messages
 		if info, ok := protoCache["Payload.proto"]; ok {
 			payloadDesc = info.Descriptor.FindMessage("Kiosk.Payload")
+			backend.SetPayloadDescriptor(payloadDesc)
 			if payloadDesc != nil {
 				backend.Log("Loaded Kiosk.Payload definition.")
 			} else {
@@ -155,7 +189,9 @@ func main() {
 			formComp.SetSuspended(true)
 			defer formComp.SetSuspended(false)
 			// Dispose all dynamically added controls
-			formComp.Children().Clear()
+			disposeChildren(formComp)
+			currentForm = nil
+			jsonTE.SetText("")
 		})
 	}
 
@@ -189,7 +225,7 @@ func main() {
 		msgCB.SetCurrentIndex(-1)
 	}
 
-	// Imperative Form Generation
+	// Imperative Form Generation, from the fields currentDynamicMsg has
 	generateForm := func() {
 		// Guard against nested calls that could corrupt the widget list
 		if isBuildingForm {
@@ -202,70 +238,67 @@ func main() {
 		defer formComp.SetSuspended(false)
 
 		// Dispose all children safely
-		formComp.Children().Clear()
+		disposeChildren(formComp)
+		currentForm = nil
 
-		if currentMessageDesc == nil {
+		if currentMessageDesc == nil || currentDynamicMsg == nil {
 			return
 		}
+		currentForm = NewMessageForm(formComp, backend, currentDynamicMsg)
+	}
 
-		currentDynamicMsg = dynamic.NewMessage(currentMessageDesc)
-
-		for _, field := range currentMessageDesc.GetFields() {
-			f := field
+	// showJSON fills the raw editor from currentDynamicMsg
+	showJSON := func() error {
+		js, err := backend.MessageToJSON(currentDynamicMsg)
+		if err != nil {
+			return err
+		}
+		jsonTE.SetText(strings.ReplaceAll(js, "\n", "\r\n"))
+		return nil
+	}
 
-			// Label
-			lbl, _ := walk.NewLabel(formComp)
-			lbl.SetText(f.GetName() + " (" + f.GetType().String() + ")")
+	// syncMessage brings currentDynamicMsg up to date with the editor in use
+	syncMessage := func() error {
+		if currentDynamicMsg == nil || currentMessageDesc == nil {
+			return errors.New("no message selected")
+		}
+		if jsonMode {
+			msg, err := backend.MessageFromJSON(currentMessageDesc, jsonTE.Text())
+			if err != nil {
+				return fmt.Errorf("invalid JSON: %w", err)
+			}
+			currentDynamicMsg = msg
+			return nil
+		}
+		if currentForm != nil {
+			return currentForm.Err()
+		}
+		return nil
+	}
 
-			// Input
-			if f.IsRepeated() {
-				_, _ = walk.NewLineEdit(formComp)
-				// le.SetToolTipText("Comma separated values")
-			} else {
-				switch f.GetType() {
-				case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
-					chk, _ := walk.NewCheckBox(formComp)
-					chk.SetText("True")
-					chk.CheckedChanged().Attach(func() {
-						currentDynamicMsg.SetField(f, chk.Checked())
-					})
-				case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
-					cmb, _ := walk.NewComboBox(formComp)
-					var items []string
-					for _, v := range f.GetEnumType().GetValues() {
-						items = append(items, v.GetName())
-					}
-					cmb.SetModel(items)
-					cmb.CurrentIndexChanged().Attach(func() {
-						idx := cmb.CurrentIndex()
-						if idx >= 0 {
-							valDesc := f.GetEnumType().FindValueByName(items[idx])
-							if valDesc != nil {
-								currentDynamicMsg.SetField(f, valDesc.GetNumber())
-							}
-						}
-					})
-				default:
-					// String, Int, etc.
-					le, _ := walk.NewLineEdit(formComp)
-					le.TextChanged().Attach(func() {
-						txt := le.Text()
-						if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_STRING {
-							currentDynamicMsg.SetField(f, txt)
-						} else {
-							// Int parsing
-							var val int64
-							fmt.Sscanf(txt, "%d", &val)
-							if f.GetType() == descriptorpb.FieldDescriptorProto_TYPE_INT32 {
-								currentDynamicMsg.SetField(f, int32(val))
-							} else {
-								currentDynamicMsg.SetField(f, val)
-							}
-						}
-					})
-				}
+	// toggleJSONMode switches between the form and the raw JSON editor,
+	// carrying the message over
+	toggleJSONMode := func() {
+		if currentDynamicMsg != nil {
+			if err := syncMessage(); err != nil {
+				backend.Log("Cannot switch editor: %v", err)
+				return
+			}
+			if jsonMode {
+				generateForm()
+			} else if err := showJSON(); err != nil {
+				backend.Log("Error rendering JSON: %v", err)
+				return
 			}
 		}
+		jsonMode = !jsonMode
+		formSV.SetVisible(!jsonMode)
+		jsonTE.SetVisible(jsonMode)
+		if jsonMode {
+			jsonModeBtn.SetText("Edit as Form")
+		} else {
+			jsonModeBtn.SetText("Edit as JSON")
+		}
 	}
 
 	onMessageSelected := func() {
@@ -288,13 +321,227 @@ func main() {
 			return
 		}
 
+		currentDynamicMsg = dynamic.NewMessage(currentMessageDesc)
+
 		// Defer form generation until after current event completes to avoid re-entrancy
 		mw.Synchronize(func() {
 			generateForm()
+			if jsonMode {
+				if err := showJSON(); err != nil {
+					backend.Log("Error rendering JSON: %v", err)
+				}
+			}
 			sendBtn.SetEnabled(true)
 		})
 	}
 
+	// Saved request collections and environments
+	var collectionCB, savedRequestCB, environmentCB *walk.ComboBox
+	var saveNameLE *walk.LineEdit
+	collectionsModel := NewStringListModel()
+	savedRequestsModel := NewStringListModel()
+	environmentsModel := NewStringListModel()
+	var collections []Collection
+	const noEnvironment = "(no environment)"
+
+	store := &Store{}
+	if dir, err := DefaultStoreDir(); err != nil {
+		backend.Log("Collections unavailable: %v", err)
+	} else {
+		store.Dir = dir
+	}
+
+	reloadCollections := func() {
+		var err error
+		if collections, err = store.Collections(); err != nil {
+			backend.Log("Error loading collections: %v", err)
+		}
+		names := []string{}
+		for _, c := range collections {
+			names = append(names, c.Name)
+		}
+		collectionsModel.Items = names
+		collectionsModel.PublishItemsReset()
+		savedRequestsModel.Items = []string{}
+		savedRequestsModel.PublishItemsReset()
+
+		envs, err := store.Environments()
+		if err != nil {
+			backend.Log("Error loading environments: %v", err)
+			envs = &Environments{}
+		}
+		environmentsModel.Items = []string{noEnvironment}
+		active := 0
+		for _, env := range envs.Environments {
+			environmentsModel.Items = append(environmentsModel.Items, env.Name)
+			if strings.EqualFold(env.Name, envs.Active) {
+				active = len(environmentsModel.Items) - 1
+			}
+		}
+		environmentsModel.PublishItemsReset()
+		if environmentCB != nil {
+			environmentCB.SetCurrentIndex(active)
+		}
+	}
+	reloadCollections()
+
+	selectedCollection := func() *Collection {
+		idx := collectionCB.CurrentIndex()
+		if idx < 0 || idx >= len(collections) {
+			return nil
+		}
+		return &collections[idx]
+	}
+
+	selectCollection := func(name string) {
+		for i, c := range collections {
+			if strings.EqualFold(c.Name, name) {
+				collectionCB.SetCurrentIndex(i)
+				return
+			}
+		}
+	}
+
+	onCollectionSelected := func() {
+		savedRequestsModel.Items = []string{}
+		if c := selectedCollection(); c != nil {
+			for _, r := range c.Requests {
+				savedRequestsModel.Items = append(savedRequestsModel.Items, r.Name)
+			}
+		}
+		savedRequestsModel.PublishItemsReset()
+		savedRequestCB.SetCurrentIndex(-1)
+	}
+
+	onEnvironmentSelected := func() {
+		idx := environmentCB.CurrentIndex()
+		if idx < 0 || idx >= len(environmentsModel.Items) {
+			return
+		}
+		active := ""
+		if idx > 0 {
+			active = environmentsModel.Items[idx]
+		}
+		envs, err := store.Environments()
+		if err != nil || strings.EqualFold(envs.Active, active) {
+			return
+		}
+		envs.Active = active
+		if err := store.SaveEnvironments(envs); err != nil {
+			backend.Log("Error saving environments: %v", err)
+			return
+		}
+		backend.Log("Active environment: %s", environmentsModel.Items[idx])
+	}
+
+	saveCurrent := func() {
+		if currentDynamicMsg == nil || currentMessageDesc == nil {
+			backend.Log("Select a message to save first.")
+			return
+		}
+		if err := syncMessage(); err != nil {
+			backend.Log("Cannot save: %v", err)
+			return
+		}
+		collection, name := "", strings.TrimSpace(saveNameLE.Text())
+		if c, r, ok := strings.Cut(name, "/"); ok {
+			collection, name = strings.TrimSpace(c), strings.TrimSpace(r)
+		} else if c := selectedCollection(); c != nil {
+			collection = c.Name
+		}
+		if collection == "" || name == "" {
+			backend.Log("Enter the name to save as: collection / request")
+			return
+		}
+
+		body, err := backend.MessageToJSON(currentDynamicMsg)
+		if err != nil {
+			backend.Log("Error encoding message: %v", err)
+			return
+		}
+		req := SavedRequest{Name: name, Message: currentMessageDesc.GetFullyQualifiedName(), Body: []byte(body)}
+		if idx := fileCB.CurrentIndex(); idx >= 0 && idx < len(filesModel.Items) {
+			req.ProtoFile = filesModel.Items[idx]
+		}
+		if err := store.SaveRequest(collection, req); err != nil {
+			backend.Log("Error saving request: %v", err)
+			return
+		}
+		backend.Log("Saved %s in collection %s", name, collection)
+		reloadCollections()
+		selectCollection(collection)
+	}
+
+	sendSaved := func() {
+		c := selectedCollection()
+		idx := savedRequestCB.CurrentIndex()
+		if c == nil || idx < 0 || idx >= len(c.Requests) {
+			backend.Log("Select a saved request first.")
+			return
+		}
+		if payloadDesc == nil {
+			backend.Log("Error: Payload descriptor not loaded.")
+			return
+		}
+		req := c.Requests[idx]
+
+		vars := map[string]string{}
+		if envIdx := environmentCB.CurrentIndex(); envIdx > 0 {
+			envs, err := store.Environments()
+			if err == nil {
+				vars, err = envs.Variables(environmentsModel.Items[envIdx], nil)
+			}
+			if err != nil {
+				backend.Log("Error loading environment: %v", err)
+				return
+			}
+		}
+		msg, err := backend.BuildSavedRequest(req, vars)
+		if err != nil {
+			backend.Log("Error in saved request %s: %v", req.Name, err)
+			return
+		}
+		if err := backend.Send(msg, payloadDesc); err != nil {
+			backend.Log("Send error: %v", err)
+		}
+	}
+
+	importCollection := func() {
+		dlg := &walk.FileDialog{Title: "Import Collection", Filter: "Collections (*.json)|*.json"}
+		if ok, _ := dlg.ShowOpen(mw); !ok {
+			return
+		}
+		c, err := store.ImportCollection(dlg.FilePath, false)
+		if errors.Is(err, ErrCollectionExists) &&
+			walk.MsgBox(mw, "Import Collection", fmt.Sprintf("Replace the collection %q?", c.Name), walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) == walk.DlgCmdYes {
+			c, err = store.ImportCollection(dlg.FilePath, true)
+		}
+		if err != nil {
+			backend.Log("Error importing %s: %v", dlg.FilePath, err)
+			return
+		}
+		backend.Log("Imported collection %s (%d requests)", c.Name, len(c.Requests))
+		reloadCollections()
+		selectCollection(c.Name)
+	}
+
+	exportCollection := func() {
+		c := selectedCollection()
+		if c == nil {
+			backend.Log("Select a collection to export first.")
+			return
+		}
+		dlg := &walk.FileDialog{Title: "Export Collection", Filter: "Collections (*.json)|*.json", FilePath: filepath.Base(store.collectionPath(c.Name))}
+		if ok, _ := dlg.ShowSave(mw); !ok {
+			return
+		}
+		if err := store.ExportCollection(c.Name, dlg.FilePath); err != nil {
+			backend.Log("Error exporting %s: %v", c.Name, err)
+			return
+		}
+		backend.Log("Exported collection %s to %s", c.Name, dlg.FilePath)
+	}
+
 	// Main Window
 	if _, err := (MainWindow{
 		AssignTo: &mw,
@@ -378,6 +625,47 @@ func main() {
 					},
 				},
 			},
+			GroupBox{
+				Title:  "Collections",
+				Layout: Grid{Columns: 6, Spacing: 10, Margins: Margins{Top: 5, Bottom: 5, Left: 10, Right: 10}},
+				Font:   Font{PointSize: 10, Bold: true},
+				Children: []Widget{
+					Label{Text: "Collection:", Font: Font{PointSize: 10}},
+					ComboBox{
+						AssignTo:              &collectionCB,
+						Model:                 collectionsModel,
+						MinSize:               Size{Width: 180, Height: 28},
+						Font:                  Font{PointSize: 10},
+						OnCurrentIndexChanged: onCollectionSelected,
+					},
+					Label{Text: "Request:", Font: Font{PointSize: 10}},
+					ComboBox{
+						AssignTo: &savedRequestCB,
+						Model:    savedRequestsModel,
+						MinSize:  Size{Width: 180, Height: 28},
+						Font:     Font{PointSize: 10},
+					},
+					Label{Text: "Environment:", Font: Font{PointSize: 10}},
+					ComboBox{
+						AssignTo:              &environmentCB,
+						Model:                 environmentsModel,
+						MinSize:               Size{Width: 150, Height: 28},
+						Font:                  Font{PointSize: 10},
+						OnCurrentIndexChanged: onEnvironmentSelected,
+					},
+					LineEdit{
+						AssignTo:   &saveNameLE,
+						CueBanner:  "collection / request",
+						ColumnSpan: 2,
+						MinSize:    Size{Width: 200, Height: 28},
+						Font:       Font{PointSize: 10},
+					},
+					PushButton{Text: "Save Current", Font: Font{PointSize: 10}, OnClicked: saveCurrent},
+					PushButton{Text: "Send Saved", Font: Font{PointSize: 10}, OnClicked: sendSaved},
+					PushButton{Text: "Import...", Font: Font{PointSize: 10}, OnClicked: importCollection},
+					PushButton{Text: "Export...", Font: Font{PointSize: 10}, OnClicked: exportCollection},
+				},
+			},
 			Composite{
 				Layout: HBox{Margins: Margins{Top: 10, Bottom: 10, Left: 10, Right: 10}},
 				Children: []Widget{
@@ -392,6 +680,10 @@ func main() {
 							if currentDynamicMsg == nil {
 								return
 							}
+							if err := syncMessage(); err != nil {
+								backend.Log("Cannot send: %v", err)
+								return
+							}
 							if payloadDesc == nil {
 								backend.Log("Error: Payload descriptor not loaded.")
 								return
@@ -411,8 +703,21 @@ func main() {
 				MinSize: Size{Width: 0, Height: 200},
 				Font:    Font{PointSize: 10, Bold: true},
 				Children: []Widget{
+					Composite{
+						Layout: HBox{MarginsZero: true},
+						Children: []Widget{
+							HSpacer{},
+							PushButton{
+								AssignTo:  &jsonModeBtn,
+								Text:      "Edit as JSON",
+								Font:      Font{PointSize: 9},
+								OnClicked: toggleJSONMode,
+							},
+						},
+					},
 					ScrollView{
-						Layout: VBox{},
+						AssignTo: &formSV,
+						Layout:   VBox{},
 						Children: []Widget{
 							Composite{
 								AssignTo: &formComp,
@@ -420,6 +725,53 @@ func main() {
 							},
 						},
 					},
+					TextEdit{
+						AssignTo: &jsonTE,
+						Visible:  false,
+						VScroll:  true,
+						Font:     Font{PointSize: 9, Family: "Consolas"},
+					},
+				},
+			},
+			GroupBox{
+				Title:  "Responses",
+				Layout: VBox{Margins: Margins{Top: 5, Bottom: 5, Left: 5, Right: 5}},
+				Font:   Font{PointSize: 10, Bold: true},
+				Children: []Widget{
+					TextEdit{
+						AssignTo: &responseTE,
+						ReadOnly: true,
+						VScroll:  true,
+						Font:     Font{PointSize: 9, Family: "Consolas"},
+					},
+					PushButton{
+						Text: "Clear",
+						Font: Font{PointSize: 9},
+						OnClicked: func() {
+							responseTE.SetText("")
+						},
+					},
+				},
+			},
+			GroupBox{
+				Title:   "Requests",
+				Layout:  VBox{Margins: Margins{Top: 5, Bottom: 5, Left: 5, Right: 5}},
+				MinSize: Size{Width: 0, Height: 120},
+				Font:    Font{PointSize: 10, Bold: true},
+				Children: []Widget{
+					TableView{
+						AssignTo: &requestsTV,
+						Model:    requestsModel,
+						Font:     Font{PointSize: 9},
+						Columns: []TableViewColumn{
+							{Title: "Transaction", Width: 130},
+							{Title: "Message", Width: 200},
+							{Title: "Sent", Width: 100},
+							{Title: "Status", Width: 110},
+							{Title: "Latency", Width: 80, Alignment: AlignFar},
+							{Title: "Reply", Width: 200},
+						},
+					},
 				},
 			},
 			GroupBox{
@@ -459,3 +811,45 @@ func (m *StringListModel) ItemCount() int {
 func (m *StringListModel) Value(index int) interface{} {
 	return m.Items[index]
 }
+
+// RequestTableModel shows the backend's request table
+type RequestTableModel struct {
+	walk.TableModelBase
+	Rows []PendingRequest
+}
+
+func NewRequestTableModel() *RequestTableModel {
+	return &RequestTableModel{}
+}
+
+func (m *RequestTableModel) RowCount() int {
+	return len(m.Rows)
+}
+
+func (m *RequestTableModel) Value(row, col int) interface{} {
+	r := m.Rows[row]
+	switch col {
+	case 0:
+		return r.TransactionID
+	case 1:
+		return r.Message
+	case 2:
+		return r.SentAt.Format("15:04:05.000")
+	case 3:
+		if r.Err != nil {
+			return fmt.Sprintf("%s: %v", r.Status, r.Err)
+		}
+		return string(r.Status)
+	case 4:
+		if r.Reply == nil {
+			return ""
+		}
+		return roundLatency(r.Latency).String()
+	case 5:
+		if r.Reply == nil {
+			return ""
+		}
+		return r.Reply.TypeURL
+	}
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// The GUI needs Windows; elsewhere the tool only runs headless, see cli.go
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+const (
+	DefaultRequestTimeout = 10 * time.Second
+	// maxRequests is how many requests the table keeps
+	maxRequests = 200
+)
+
+// ErrRequestTimeout is returned by SendAndWait when no reply arrives in time
+var ErrRequestTimeout = errors.New("timed out waiting for the reply")
+
+type RequestStatus string
+
+const (
+	StatusPending  RequestStatus = "pending"
+	StatusAnswered RequestStatus = "answered"
+	StatusTimedOut RequestStatus = "timed out"
+	// StatusLate is a reply that arrived after the request timed out
+	StatusLate   RequestStatus = "answered late"
+	StatusFailed RequestStatus = "failed"
+)
+
+// PendingRequest is a sent message and what became of it, matched to its
+// reply by the transactionID in the Kiosk.Payload metadata
+type PendingRequest struct {
+	TransactionID string
+	Message       string
+	SentAt        time.Time
+	Timeout       time.Duration
+	Status        RequestStatus
+	// Latency is the round trip until the reply arrived
+	Latency time.Duration
+	Reply   *Frame
+	Err     error
+
+	// done is closed when the request is answered, times out or fails
+	done  chan struct{}
+	timer *time.Timer
+}
+
+// Requests returns the request table, oldest first
+func (b *Backend) Requests() []PendingRequest {
+	b.reqMu.Lock()
+	defer b.reqMu.Unlock()
+	list := make([]PendingRequest, 0, len(b.requestOrder))
+	for _, id := range b.requestOrder {
+		list = append(list, *b.requests[id])
+	}
+	return list
+}
+
+// Request returns the request with the transaction ID
+func (b *Backend) Request(transactionID string) (PendingRequest, bool) {
+	b.reqMu.Lock()
+	defer b.reqMu.Unlock()
+	req, ok := b.requests[transactionID]
+	if !ok {
+		return PendingRequest{}, false
+	}
+	return *req, true
+}
+
+// SendTracked sends msg like Send and returns its transaction ID; the reply
+// is expected within RequestTimeout
+func (b *Backend) SendTracked(msg *dynamic.Message, payloadDesc *desc.MessageDescriptor) (string, error) {
+	req, err := b.sendTracked(msg, payloadDesc, b.RequestTimeout)
+	if err != nil {
+		return "", err
+	}
+	return req.TransactionID, nil
+}
+
+// SendAndWait sends msg and waits for the reply carrying the same
+// transactionID, at most timeout (RequestTimeout when 0). It returns the
+// request with its reply and latency, or ErrRequestTimeout.
+func (b *Backend) SendAndWait(ctx context.Context, msg *dynamic.Message, payloadDesc *desc.MessageDescriptor, timeout time.Duration) (PendingRequest, error) {
+	if timeout <= 0 {
+		timeout = b.RequestTimeout
+	}
+	sent, err := b.sendTracked(msg, payloadDesc, timeout)
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	select {
+	case <-sent.done:
+	case <-ctx.Done():
+		req, _ := b.Request(sent.TransactionID)
+		return req, ctx.Err()
+	}
+	req, _ := b.Request(sent.TransactionID)
+	if req.Status == StatusTimedOut {
+		return req, fmt.Errorf("transaction %s: %w after %s", req.TransactionID, ErrRequestTimeout, timeout)
+	}
+	return req, nil
+}
+
+func (b *Backend) sendTracked(msg *dynamic.Message, payloadDesc *desc.MessageDescriptor, timeout time.Duration) (*PendingRequest, error) {
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	req := &PendingRequest{
+		TransactionID: b.nextTransactionID(),
+		Message:       msg.GetMessageDescriptor().GetFullyQualifiedName(),
+		SentAt:        time.Now(),
+		Timeout:       timeout,
+		Status:        StatusPending,
+		done:          make(chan struct{}),
+	}
+
+	// Register before sending so a fast reply finds the request
+	b.reqMu.Lock()
+	b.requests[req.TransactionID] = req
+	b.requestOrder = append(b.requestOrder, req.TransactionID)
+	b.trimRequests()
+	req.timer = time.AfterFunc(timeout, func() { b.expireRequest(req.TransactionID) })
+	b.reqMu.Unlock()
+	b.requestsChanged()
+
+	if err := b.send(msg, payloadDesc, req.TransactionID); err != nil {
+		// The send may have blocked past the timeout, in which case the
+		// request has already expired and done is closed
+		b.reqMu.Lock()
+		if req.Status == StatusPending {
+			req.timer.Stop()
+			req.Status = StatusFailed
+			req.Err = err
+			close(req.done)
+		}
+		b.reqMu.Unlock()
+		b.requestsChanged()
+		return nil, err
+	}
+	return req, nil
+}
+
+// nextTransactionID returns the current time in milliseconds, as the Kiosk UI
+// does, bumped when needed so that every request gets its own ID
+func (b *Backend) nextTransactionID() string {
+	b.reqMu.Lock()
+	defer b.reqMu.Unlock()
+	id := time.Now().UnixMilli()
+	if id <= b.lastTransactionID {
+		id = b.lastTransactionID + 1
+	}
+	b.lastTransactionID = id
+	return strconv.FormatInt(id, 10)
+}
+
+// expireRequest marks a request still pending after its timeout
+func (b *Backend) expireRequest(id string) {
+	b.reqMu.Lock()
+	req, ok := b.requests[id]
+	if !ok || req.Status != StatusPending {
+		b.reqMu.Unlock()
+		return
+	}
+	req.Status = StatusTimedOut
+	close(req.done)
+	b.reqMu.Unlock()
+
+	b.Log("Request %s (%s) timed out after %s", id, req.Message, req.Timeout)
+	b.requestsChanged()
+}
+
+// resolveRequest matches an inbound frame to the request with its
+// transactionID and records the round trip in the frame and the table
+func (b *Backend) resolveRequest(frame *Frame) {
+	if frame.TransactionID == "" {
+		return
+	}
+	b.reqMu.Lock()
+	req, ok := b.requests[frame.TransactionID]
+	if !ok || req.Reply != nil {
+		b.reqMu.Unlock()
+		return
+	}
+	req.Latency = frame.Time.Sub(req.SentAt)
+	frame.Latency = req.Latency
+	reply := *frame
+	req.Reply = &reply
+	switch req.Status {
+	case StatusPending:
+		req.timer.Stop()
+		req.Status = StatusAnswered
+		close(req.done)
+	case StatusTimedOut:
+		req.Status = StatusLate
+	}
+	b.reqMu.Unlock()
+	b.requestsChanged()
+}
+
+// trimRequests drops the oldest finished requests beyond maxRequests.
+// b.reqMu must be held.
+func (b *Backend) trimRequests() {
+	for len(b.requestOrder) > maxRequests {
+		dropped := false
+		for i, id := range b.requestOrder {
+			if b.requests[id].Status != StatusPending {
+				delete(b.requests, id)
+				b.requestOrder = append(b.requestOrder[:i], b.requestOrder[i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			return
+		}
+	}
+}
+
+// roundLatency rounds to milliseconds, or microseconds below a millisecond
+func roundLatency(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func (b *Backend) requestsChanged() {
+	if b.RequestsFunc != nil {
+		b.RequestsFunc()
+	}
+}
+
+// payloadTransactionID reads metadata.data["transactionID"] of a Kiosk.Payload
+func payloadTransactionID(payloadMsg *dynamic.Message) string {
+	metaField := payloadMsg.GetMessageDescriptor().FindFieldByName("metadata")
+	if metaField == nil || !payloadMsg.HasField(metaField) {
+		return ""
+	}
+	meta, ok := payloadMsg.GetField(metaField).(*dynamic.Message)
+	if !ok {
+		return ""
+	}
+	dataField := meta.GetMessageDescriptor().FindFieldByName("data")
+	if dataField == nil || !dataField.IsMap() {
+		return ""
+	}
+	v, err := meta.TryGetMapField(dataField, "transactionID")
+	if err != nil {
+		return ""
+	}
+	id, _ := v.(string)
+	return id
+}
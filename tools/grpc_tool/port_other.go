@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// GetWSPort returns the default port; the MD4M registry key only exists on Windows
+func (b *Backend) GetWSPort() string {
+	b.Log("Using default port: %s", DefaultWSPort)
+	return DefaultWSPort
+}
@@ -0,0 +1,25 @@
+package main
+
+import "golang.org/x/sys/windows/registry"
+
+const (
+	RegistryKey   = `SOFTWARE\WOW6432Node\OPSWAT\MD4M`
+	RegistryValue = "ws_port"
+)
+
+func (b *Backend) GetWSPort() string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, RegistryKey, registry.QUERY_VALUE)
+	if err != nil {
+		b.Log("Registry key not found, using default port: %s", DefaultWSPort)
+		return DefaultWSPort
+	}
+	defer k.Close()
+
+	port, _, err := k.GetStringValue(RegistryValue)
+	if err != nil {
+		b.Log("Registry value not found, using default port: %s", DefaultWSPort)
+		return DefaultWSPort
+	}
+	b.Log("Found port in registry: %s", port)
+	return port
+}